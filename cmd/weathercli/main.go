@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"example.com/weather/internal/weather"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s <cities file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := weather.LoadDotEnv(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	processor, err := weather.NewLocalProcessor(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	response, err := processor.Process(ctx, flag.Arg(0), "")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(response.StatusMessage)
+
+	fmt.Println("Top temperatures:")
+	for _, city := range response.TopTemperatures {
+		fmt.Printf("  %s\t%.1f\t%s\n", city.City, city.Temperature, city.Description)
+	}
+
+	fmt.Println("Top winds:")
+	for _, city := range response.TopWinds {
+		fmt.Printf("  %s\t%.1f\t%s\n", city.City, city.WindSpeed, city.Direction)
+	}
+}
@@ -0,0 +1,162 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultAirPollutionURL is the air pollution endpoint queried when INCLUDE_AQI is set and OWM_BASE_URL is unset
+const defaultAirPollutionURL = "https://api.openweathermap.org/data/2.5/air_pollution"
+
+// AirQuality defines the interface for the current air quality of a location, the index runs
+// from 1 (good) to 5 (very poor) and pollutant concentrations are in μg/m3
+type AirQuality struct {
+	AQI        int `json:"aqi"`
+	Components struct {
+		PM25 float64 `json:"pm2_5"`
+		PM10 float64 `json:"pm10"`
+		O3   float64 `json:"o3"`
+		NO2  float64 `json:"no2"`
+	} `json:"components"`
+}
+
+// AirPollutionResponse defines the interface for the json object returned from the air pollution api
+type AirPollutionResponse struct {
+	List []struct {
+		Main struct {
+			AQI int `json:"aqi"`
+		} `json:"main"`
+		Components struct {
+			PM25 float64 `json:"pm2_5"`
+			PM10 float64 `json:"pm10"`
+			O3   float64 `json:"o3"`
+			NO2  float64 `json:"no2"`
+		} `json:"components"`
+	} `json:"list"`
+}
+
+// AirQualityOutput defines the interface for the csv air quality data
+type AirQualityOutput struct {
+	Rank int     `csv:"Rank" json:"rank" msgpack:"rank" parquet:"name=rank, type=INT32"`
+	City string  `csv:"City" json:"city" msgpack:"city" parquet:"name=city, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Lat  float64 `csv:"Lat" json:"lat" msgpack:"lat" parquet:"name=lat, type=DOUBLE"`
+	Lon  float64 `csv:"Lon" json:"lon" msgpack:"lon" parquet:"name=lon, type=DOUBLE"`
+	AQI  int32   `csv:"AQI" json:"aqi" msgpack:"aqi" parquet:"name=aqi, type=INT32"`
+	PM25 float64 `csv:"PM2.5" json:"pm2_5" msgpack:"pm2_5" parquet:"name=pm2_5, type=DOUBLE"`
+	PM10 float64 `csv:"PM10" json:"pm10" msgpack:"pm10" parquet:"name=pm10, type=DOUBLE"`
+	O3   float64 `csv:"O3" json:"o3" msgpack:"o3" parquet:"name=o3, type=DOUBLE"`
+	NO2  float64 `csv:"NO2" json:"no2" msgpack:"no2" parquet:"name=no2, type=DOUBLE"`
+}
+
+// selectAirPollutionURL derives the air pollution endpoint from OWM_BASE_URL the way groupURL derives the group endpoint,
+//     so a proxy or mock configured there also serves the air quality lookups
+// Inputs:
+//     baseURL: weather endpoint returned by selectWeatherBaseURL
+// Output:
+//     If success returns the air pollution url, defaultAirPollutionURL when OWM_BASE_URL is unset, and nil,
+//     otherwise an error
+func selectAirPollutionURL(baseURL string) (string, error) {
+	if strings.TrimSpace(os.Getenv("OWM_BASE_URL")) == "" {
+		return defaultAirPollutionURL, nil
+	}
+
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid OWM_BASE_URL! %w", err)
+	}
+
+	parsed.Path = strings.TrimSuffix(path.Dir(parsed.Path), "/") + "/air_pollution"
+
+	return parsed.String(), nil
+}
+
+// fetchAirQuality calls the air pollution endpoint for the coordinates of a city
+// Inputs:
+//     ctx: context of the request
+//     weatherClient: http client used to call the api
+//     limiter: rate limiter throttling api calls, nil for no limit
+//     endpoint: air pollution endpoint returned by selectAirPollutionURL
+//     city: Weather of the city, its coordinates are looked up
+//     maxBytes: largest response body accepted
+//     maxRetries: most retries after the first attempt
+// Output:
+//     If success returns the current AirQuality and nil, otherwise an error
+func fetchAirQuality(ctx context.Context, weatherClient *http.Client, limiter *rate.Limiter, endpoint string, city Weather, maxBytes int64, maxRetries int) (*AirQuality, error) {
+	params := url.Values{}
+	params.Set("lat", fmt.Sprint(city.Coord.Lat))
+	params.Set("lon", fmt.Sprint(city.Coord.Lon))
+	params.Set("appid", owmAPIKey())
+
+	status, body, err := getBody(ctx, weatherClient, limiter, endpoint+"?"+params.Encode(), maxBytes, maxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("air quality lookup failed for %s! %d %s", cityName(city), status, strings.TrimSpace(string(body)))
+	}
+
+	pollution := AirPollutionResponse{}
+	if err := json.Unmarshal(body, &pollution); err != nil {
+		return nil, fmt.Errorf("failed to load JSON into Struct! %w", err)
+	}
+
+	if len(pollution.List) == 0 {
+		return nil, fmt.Errorf("air quality lookup failed for %s! no readings", cityName(city))
+	}
+
+	reading := pollution.List[0]
+	airQuality := &AirQuality{AQI: reading.Main.AQI}
+	airQuality.Components.PM25 = reading.Components.PM25
+	airQuality.Components.PM10 = reading.Components.PM10
+	airQuality.Components.O3 = reading.Components.O3
+	airQuality.Components.NO2 = reading.Components.NO2
+
+	return airQuality, nil
+}
+
+// airQualityValue ranks cities by air quality index
+func airQualityValue(city Weather) float64 {
+	if city.AirQuality == nil {
+		return 0
+	}
+
+	return float64(city.AirQuality.AQI)
+}
+
+// hasAirQuality reports whether a city has an air quality reading, cities without one are left out of the ranking
+func hasAirQuality(city Weather) bool {
+	return city.AirQuality != nil
+}
+
+// airQualityRows converts ranked cities into air quality report rows numbered from 1 in ranked order
+func airQualityRows(ranked []Weather) interface{} {
+	rows := make([]AirQualityOutput, len(ranked))
+
+	for i, city := range ranked {
+		rows[i] = AirQualityOutput{
+			Rank: i + 1,
+			City: cityName(city),
+			Lat:  city.Coord.Lat,
+			Lon:  city.Coord.Lon,
+		}
+
+		if city.AirQuality != nil {
+			rows[i].AQI = int32(city.AirQuality.AQI)
+			rows[i].PM25 = city.AirQuality.Components.PM25
+			rows[i].PM10 = city.AirQuality.Components.PM10
+			rows[i].O3 = city.AirQuality.Components.O3
+			rows[i].NO2 = city.AirQuality.Components.NO2
+		}
+	}
+
+	return rows
+}
@@ -0,0 +1,105 @@
+package weather
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchAirQuality(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "test")
+
+	recorded, err := os.ReadFile(filepath.Join("testdata", "air_pollution.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("lat") + "," + r.URL.Query().Get("lon")
+		w.Write(recorded)
+	}))
+	defer server.Close()
+
+	city := Weather{Name: "London"}
+	city.Coord.Lat, city.Coord.Lon = 51.5074, -0.1278
+
+	airQuality, err := fetchAirQuality(context.Background(), server.Client(), nil, server.URL+"/data/2.5/air_pollution", city,
+		defaultMaxResponseBytes, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if query != "51.5074,-0.1278" {
+		t.Errorf("got coordinates %s", query)
+	}
+
+	if airQuality.AQI != 3 || airQuality.Components.PM25 != 14.53 || airQuality.Components.PM10 != 17.11 ||
+		airQuality.Components.O3 != 79.39 || airQuality.Components.NO2 != 19.53 {
+		t.Errorf("got %+v", *airQuality)
+	}
+}
+
+func TestFetchAirQualityNoReadings(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "test")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"list":[]}`))
+	}))
+	defer server.Close()
+
+	if _, err := fetchAirQuality(context.Background(), server.Client(), nil, server.URL, Weather{Name: "London"},
+		defaultMaxResponseBytes, 0); err == nil {
+		t.Error("expected an error for a response without readings")
+	}
+}
+
+func TestWorstAirQualityRanking(t *testing.T) {
+	t.Setenv("USE_INPUT_NAME", "")
+
+	weatherList := []Weather{{Name: "Oslo"}, {Name: "Delhi"}, {Name: "Lima"}, {Name: "Cairo"}}
+	for i, aqi := range []int{1, 5, 0, 0} {
+		if aqi > 0 {
+			weatherList[i].AirQuality = &AirQuality{AQI: aqi}
+		}
+	}
+
+	rows := airQualityRows(metricReports(Config{})["aqi"].rank(weatherList, false)).([]AirQualityOutput)
+
+	// Cities without a reading are left out rather than ranked as zero
+	want := []string{"Delhi", "Oslo"}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+
+	for i, row := range rows {
+		if row.City != want[i] || row.Rank != i+1 {
+			t.Errorf("row %d = %s ranked %d, want %s ranked %d", i, row.City, row.Rank, want[i], i+1)
+		}
+	}
+}
+
+func TestSelectAirPollutionURL(t *testing.T) {
+	tests := []struct {
+		baseEnv string
+		baseURL string
+		want    string
+	}{
+		{"", defaultCurrentWeatherURL, defaultAirPollutionURL},
+		{"", defaultOneCallURL, defaultAirPollutionURL},
+		{"http://localhost:8080/data/2.5/weather", "http://localhost:8080/data/2.5/weather", "http://localhost:8080/data/2.5/air_pollution"},
+		{"http://localhost:8080/weather", "http://localhost:8080/weather", "http://localhost:8080/air_pollution"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("OWM_BASE_URL", tt.baseEnv)
+
+		got, err := selectAirPollutionURL(tt.baseURL)
+		if err != nil || got != tt.want {
+			t.Errorf("selectAirPollutionURL(%q) = %q, %v, want %q", tt.baseURL, got, err, tt.want)
+		}
+	}
+}
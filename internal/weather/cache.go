@@ -0,0 +1,139 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// defaultCacheTTL is how long a cached city's weather is reused when CACHE_TTL_SECONDS is unset
+const defaultCacheTTL = 10 * time.Minute
+
+// DynamoCacheAPI combines the read and write functions used by the weather cache.
+type DynamoCacheAPI interface {
+	DynamoGetItemAPI
+	DynamoPutItemAPI
+}
+
+// CacheRecord defines the interface for a city's weather item cached in DynamoDB across invocations,
+// the table's partition key is a string "city" and expires_at can be enabled as its TTL attribute
+type CacheRecord struct {
+	City      string  `dynamodbav:"city"`
+	Weather   Weather `dynamodbav:"weather"`
+	ExpiresAt int64   `dynamodbav:"expires_at"`
+}
+
+// selectCacheTTL reads the CACHE_TTL_SECONDS env var bounding how long cached weather is reused
+// Output:
+//     If success returns the ttl, defaulting to defaultCacheTTL, and nil, otherwise an error
+func selectCacheTTL() (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv("CACHE_TTL_SECONDS"))
+	if value == "" {
+		return defaultCacheTTL, nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid CACHE_TTL_SECONDS! %s must be a positive integer", value)
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// cacheItemKey builds the cached item key of a city, so weather fetched in another language, from
+//     another api version or without the forecast or air quality this run needs is never reused
+// Inputs:
+//     city: city name to look up
+// Output:
+//     The key, e.g. london:fr:3.0+forecast+aqi
+func (config Config) cacheItemKey(city string) string {
+	variant := config.APIVersion
+	if config.IncludeForecast {
+		variant += "+forecast"
+	}
+	if config.IncludeAirQuality {
+		variant += "+aqi"
+	}
+
+	return normalizeCity(city) + ":" + config.Language + ":" + variant
+}
+
+// cachedWeather looks up a city's weather stored by a previous invocation
+// Inputs:
+//     ctx: context of the invocation
+//     city: city name to look up
+// Output:
+//     If success returns the cached Weather, true when it was found and has not expired, and nil,
+//     otherwise an error
+func (p *Processor) cachedWeather(ctx context.Context, city string) (Weather, bool, error) {
+	table := p.config.CacheTable
+	if table == "" || p.cacheClient == nil {
+		return Weather{}, false, nil
+	}
+
+	output, err := GetItem(ctx, p.cacheClient, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key:       map[string]types.AttributeValue{"city": &types.AttributeValueMemberS{Value: p.config.cacheItemKey(city)}},
+	})
+	if err != nil {
+		return Weather{}, false, fmt.Errorf("error reading cached weather for %s! %w", city, err)
+	}
+
+	if len(output.Item) == 0 {
+		return Weather{}, false, nil
+	}
+
+	record := CacheRecord{}
+	if err := attributevalue.UnmarshalMap(output.Item, &record); err != nil {
+		return Weather{}, false, fmt.Errorf("failed to unmarshal cached weather for %s! %w", city, err)
+	}
+
+	// DynamoDB removes expired items lazily, so the expiry is checked here as well
+	if record.ExpiresAt <= time.Now().Unix() {
+		return Weather{}, false, nil
+	}
+
+	return record.Weather, true, nil
+}
+
+// cacheWeather stores a city's weather for reuse by later invocations
+// Inputs:
+//     ctx: context of the invocation
+//     city: city name the weather was fetched for
+//     cityWeather: Weather returned by the provider
+//     ttl: how long the weather may be reused
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) cacheWeather(ctx context.Context, city string, cityWeather Weather, ttl time.Duration) error {
+	table := p.config.CacheTable
+	if table == "" || p.cacheClient == nil || p.dryRun() {
+		return nil
+	}
+
+	item, err := attributevalue.MarshalMap(CacheRecord{
+		City:      p.config.cacheItemKey(city),
+		Weather:   cityWeather,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal cached weather for %s! %w", city, err)
+	}
+
+	_, err = PutItem(ctx, p.cacheClient, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("error caching weather for %s! %w", city, err)
+	}
+
+	return nil
+}
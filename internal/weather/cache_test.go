@@ -0,0 +1,176 @@
+package weather
+
+import (
+	"context"
+	"io"
+	"log"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeCache stores cached items in memory keyed by city
+type fakeCache struct {
+	items map[string]map[string]types.AttributeValue
+	gets  int
+	puts  int
+}
+
+func (f *fakeCache) GetItem(ctx context.Context, params *dynamodb.GetItemInput,
+	optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.gets++
+
+	return &dynamodb.GetItemOutput{Item: f.items[params.Key["city"].(*types.AttributeValueMemberS).Value]}, nil
+}
+
+func (f *fakeCache) PutItem(ctx context.Context, params *dynamodb.PutItemInput,
+	optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.puts++
+
+	f.items[params.Item["city"].(*types.AttributeValueMemberS).Value] = params.Item
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// fetchCities runs a fresh invocation's fetch stage over cities using the shared cache
+func fetchCities(t *testing.T, cache *fakeCache, provider WeatherProvider, cities ...string) []Weather {
+	t.Helper()
+
+	config := testConfig(t)
+	p := &Processor{cacheClient: cache, weatherProvider: provider, metrics: multiRecorder{}, config: config, logger: log.New(io.Discard, "", 0)}
+
+	input := make(chan string, len(cities))
+	for _, city := range cities {
+		input <- city
+	}
+	close(input)
+
+	var weatherList []Weather
+	if err := p.populateWeatherList(context.Background(), input, map[string]int{}, config, &weatherList); err != nil {
+		t.Fatal(err)
+	}
+
+	return weatherList
+}
+
+func TestCacheReusedAcrossInvocations(t *testing.T) {
+	t.Setenv("CACHE_TABLE", "weather-cache")
+	t.Setenv("DRY_RUN", "")
+	t.Setenv("LANG", "")
+
+	cache := &fakeCache{items: make(map[string]map[string]types.AttributeValue)}
+
+	// The first invocation misses and stores what the api returned
+	first := &countingProvider{calls: map[string]int{}}
+	fetchCities(t, cache, first, "London", "Paris")
+
+	if cache.puts != 2 || len(first.calls) != 2 {
+		t.Fatalf("miss: got %d puts and api calls %v", cache.puts, first.calls)
+	}
+
+	expiresAt, err := strconv.ParseInt(cache.items["london::2.5"]["expires_at"].(*types.AttributeValueMemberN).Value, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := time.Until(time.Unix(expiresAt, 0)); ttl < 9*time.Minute || ttl > 10*time.Minute {
+		t.Errorf("got expiry %v away, want the 10 minute ttl", ttl)
+	}
+
+	// A later invocation reuses the stored weather without calling the api
+	second := &countingProvider{calls: map[string]int{}}
+	weatherList := fetchCities(t, cache, second, "london", "Paris")
+
+	if len(second.calls) != 0 {
+		t.Errorf("hit: got api calls %v, want none", second.calls)
+	}
+
+	if len(weatherList) != 2 || weatherList[0].Name != "London" || weatherList[0].Main.Temp != 6 {
+		t.Errorf("hit: got %+v, want the cached London", weatherList)
+	}
+
+	// Expired items are fetched again even if the table has not removed them yet
+	cache.items["london::2.5"]["expires_at"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)}
+
+	third := &countingProvider{calls: map[string]int{}}
+	fetchCities(t, cache, third, "London", "Paris")
+
+	if third.calls["London"] != 1 || third.calls["Paris"] != 0 {
+		t.Errorf("expired: got api calls %v, want only London", third.calls)
+	}
+}
+
+func TestCacheMissesOnRequestChange(t *testing.T) {
+	t.Setenv("CACHE_TABLE", "weather-cache")
+	t.Setenv("DRY_RUN", "")
+	t.Setenv("LANG", "")
+	t.Setenv("OWM_API_VERSION", "")
+	t.Setenv("INCLUDE_FORECAST", "")
+	t.Setenv("INCLUDE_AQI", "")
+
+	cache := &fakeCache{items: make(map[string]map[string]types.AttributeValue)}
+	fetchCities(t, cache, &countingProvider{calls: map[string]int{}}, "London")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		key  string
+	}{
+		{"api version", map[string]string{"OWM_API_VERSION": "3.0"}, "london::3.0"},
+		{"forecast", map[string]string{"INCLUDE_FORECAST": "true"}, "london::3.0+forecast"},
+		{"air quality", map[string]string{"INCLUDE_AQI": "true"}, "london::2.5+aqi"},
+		{"language", map[string]string{"LANG": "fr"}, "london:fr:2.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			// Weather cached for the plain 2.5 request lacks what this run asks for
+			provider := &countingProvider{calls: map[string]int{}}
+			fetchCities(t, cache, provider, "London")
+
+			if provider.calls["London"] != 1 {
+				t.Errorf("got api calls %v, want London fetched again", provider.calls)
+			}
+
+			if cache.items[tt.key] == nil {
+				t.Errorf("got no item under %s", tt.key)
+			}
+		})
+	}
+
+	// The original request still hits
+	provider := &countingProvider{calls: map[string]int{}}
+	fetchCities(t, cache, provider, "London")
+
+	if len(provider.calls) != 0 {
+		t.Errorf("got api calls %v, want the cached London", provider.calls)
+	}
+}
+
+func TestSelectCacheTTL(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", defaultCacheTTL, false},
+		{"60", time.Minute, false},
+		{"0", 0, true},
+		{"ten", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("CACHE_TTL_SECONDS", tt.value)
+
+		got, err := selectCacheTTL()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("CACHE_TTL_SECONDS %q: got %v, %v", tt.value, got, err)
+		}
+	}
+}
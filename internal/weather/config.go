@@ -0,0 +1,300 @@
+package weather
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Config holds the tunables of a run, read once from the environment and validated up front
+//     so a misconfigured function fails before any city is queried
+type Config struct {
+	// Format serializes the reports, from OUTPUT_FORMAT
+	Format OutputFormat
+	// Sink is where reports are written, from OUTPUT_SINK
+	Sink string
+	// FloatPrecision is how many decimals report values keep, from FLOAT_PRECISION
+	FloatPrecision int
+	// ObjectACL is the canned ACL applied to S3 reports, from S3_ACL
+	ObjectACL types.ObjectCannedACL
+	// KMSKeyID encrypts S3 reports with a customer managed key, from SSE_KMS_KEY_ID
+	KMSKeyID string
+	// OutputDir is the directory written by the file sink, from OUTPUT_DIR
+	OutputDir string
+	// OutputPrefix is prepended to every output key, from OUTPUT_PREFIX, empty or ending in a slash
+	OutputPrefix string
+	// Partition is "date" for Hive style partitioned report keys, from PARTITION_OUTPUT
+	Partition string
+	// ReportBuckets maps a metric name to the bucket overriding OUTPUT_BUCKET for its reports
+	ReportBuckets map[string]string
+	// BackupBucket mirrors every S3 report, from BACKUP_OUTPUT_BUCKET, BackupStrict fails the run on a failed mirror
+	BackupBucket string
+	BackupStrict bool
+	// DryRun only logs S3 writes and deletes, from DRY_RUN
+	DryRun bool
+
+	// Metrics names the reports to produce, from METRICS, INCLUDE_PRECIPITATION and INCLUDE_AQI
+	Metrics      []string
+	WriteHighest bool
+	WriteLowest  bool
+	// RankMode ranks the precipitation report, from RANK_MODE
+	RankMode string
+	// WindUnit is the unit wind speeds are reported in, from WIND_UNIT
+	WindUnit string
+	// WindVector adds u/v components to the wind report, from WIND_VECTOR
+	WindVector bool
+	SortOrders map[string]string
+	// SortBy orders the summary report, empty when it is not written
+	SortBy string
+	// OutputAll adds a dump of every city, OutputAllOnly writes it instead of the ranked reports
+	OutputAll     bool
+	OutputAllOnly bool
+	// MaxRowsPerFile splits larger reports into part files, zero for no limit
+	MaxRowsPerFile int
+	// UseInputName reports cities by the name written in the input file, from USE_INPUT_NAME
+	UseInputName bool
+	// FilterCountry keeps only cities in a single country, an ISO code from FILTER_COUNTRY
+	FilterCountry  string
+	GroupByCountry bool
+	// MinTemp and MaxTemp bound the temperatures of reported cities, nil when unbounded
+	MinTemp *float64
+	MaxTemp *float64
+	// History appends the top cities of every run to history.csv, from ENABLE_HISTORY
+	History bool
+
+	// InputFormat is "csv" or "json" from INPUT_FORMAT, empty to use the input file's extension
+	InputFormat string
+	// InputIsManifest reads the upload file as a list of input files, from INPUT_IS_MANIFEST
+	InputIsManifest bool
+	// MaxInputBytes bounds every input file read, from MAX_INPUT_BYTES
+	MaxInputBytes int64
+	// MaxCities bounds the cities read, zero for no limit, TruncateCities drops the rest instead of failing
+	MaxCities      int
+	TruncateCities bool
+	// CityAllowlist and CityDenylist hold normalized city names, from CITY_ALLOWLIST and CITY_DENYLIST
+	CityAllowlist map[string]bool
+	CityDenylist  map[string]bool
+	// ZipCountry is the country appended to zip codes, from ZIP_COUNTRY_CODE
+	ZipCountry string
+	// CityIDInput looks numeric tokens up as OpenWeatherMap city ids, from CITY_ID_INPUT
+	CityIDInput bool
+	// GeocodeCacheKey names the input file of known coordinates, from GEOCODE_CACHE_KEY
+	GeocodeCacheKey string
+
+	// Provider is the weather provider, from WEATHER_PROVIDER, StaticWeatherFile is read by the static provider
+	Provider          string
+	StaticWeatherFile string
+	// APIVersion is the OpenWeatherMap api called, from OWM_API_VERSION
+	APIVersion        string
+	IncludeForecast   bool
+	IncludeAirQuality bool
+	// Language localizes weather descriptions, from LANG, empty for the api default
+	Language string
+	// HealthCheckCity is the city looked up by a health check, from HEALTH_CHECK_CITY
+	HealthCheckCity string
+
+	// CleanupMode is what happens to the upload file, from CLEANUP_MODE
+	CleanupMode string
+	// ArchivePrefix is where archived upload files are kept, from ARCHIVE_PREFIX, ending in a slash
+	ArchivePrefix string
+	// CityTimeout bounds the api calls made for a single city, zero for none
+	CityTimeout time.Duration
+	// CacheTable and CacheTTL configure the weather reused across invocations, from CACHE_TABLE and CACHE_TTL_SECONDS
+	CacheTable string
+	CacheTTL   time.Duration
+	// IdempotencyTable records processed uploads, from IDEMPOTENCY_TABLE
+	IdempotencyTable string
+	// WeatherTable stores every city's weather, from WEATHER_TABLE
+	WeatherTable string
+	// ProgressInterval is the number of cities between progress logs, zero for none
+	ProgressInterval int
+	// Strict fails the run on the first city error
+	Strict     bool
+	MinCount   int
+	MinPercent float64
+
+	// DeadLetterURL, TopicArn and WebhookURL are told about runs, from DLQ_URL, SNS_TOPIC_ARN and WEBHOOK_URL
+	DeadLetterURL string
+	TopicArn      string
+	WebhookURL    string
+	WebhookStrict bool
+	// PushgatewayURL and PromJob push run metrics to Prometheus, from PROM_PUSHGATEWAY_URL and PROM_JOB
+	PushgatewayURL string
+	PromJob        string
+	// XRay traces the run, from XRAY_ENABLED
+	XRay bool
+}
+
+// loadConfig reads and validates every tunable of a run from the environment
+// Output:
+//     If success returns the Config and nil, otherwise the first configuration error
+func loadConfig() (Config, error) {
+	config := Config{
+		KMSKeyID:          envValue("SSE_KMS_KEY_ID"),
+		OutputDir:         selectOutputDir(),
+		OutputPrefix:      selectOutputPrefix(),
+		ReportBuckets:     selectReportBuckets(),
+		BackupBucket:      envValue("BACKUP_OUTPUT_BUCKET"),
+		BackupStrict:      envEnabled("BACKUP_STRICT"),
+		DryRun:            envEnabled("DRY_RUN"),
+		WindVector:        envEnabled("WIND_VECTOR"),
+		UseInputName:      envEnabled("USE_INPUT_NAME"),
+		GroupByCountry:    envEnabled("GROUP_BY_COUNTRY"),
+		History:           envEnabled("ENABLE_HISTORY"),
+		InputIsManifest:   envEnabled("INPUT_IS_MANIFEST"),
+		CityAllowlist:     cityList(os.Getenv("CITY_ALLOWLIST")),
+		CityDenylist:      cityList(os.Getenv("CITY_DENYLIST")),
+		ZipCountry:        selectZipCountry(),
+		CityIDInput:       envEnabled("CITY_ID_INPUT"),
+		GeocodeCacheKey:   envValue("GEOCODE_CACHE_KEY"),
+		StaticWeatherFile: os.Getenv("STATIC_WEATHER_FILE"),
+		IncludeForecast:   envEnabled("INCLUDE_FORECAST"),
+		IncludeAirQuality: envEnabled("INCLUDE_AQI"),
+		Language:          owmLanguage(),
+		HealthCheckCity:   selectHealthCheckCity(),
+		ArchivePrefix:     selectArchivePrefix(),
+		CacheTable:        envValue("CACHE_TABLE"),
+		IdempotencyTable:  envValue("IDEMPOTENCY_TABLE"),
+		WeatherTable:      envValue("WEATHER_TABLE"),
+		Strict:            envEnabled("STRICT"),
+		DeadLetterURL:     envValue("DLQ_URL"),
+		TopicArn:          envValue("SNS_TOPIC_ARN"),
+		WebhookStrict:     envEnabled("WEBHOOK_STRICT"),
+		PushgatewayURL:    envValue("PROM_PUSHGATEWAY_URL"),
+		PromJob:           selectPromJob(),
+		XRay:              envEnabled("XRAY_ENABLED"),
+	}
+
+	var err error
+
+	if config.Format, err = selectOutputFormat(); err != nil {
+		return Config{}, err
+	}
+
+	if config.Sink, err = selectOutputSink(); err != nil {
+		return Config{}, err
+	}
+
+	if config.FloatPrecision, err = selectFloatPrecision(); err != nil {
+		return Config{}, err
+	}
+
+	if config.ObjectACL, err = selectObjectACL(); err != nil {
+		return Config{}, err
+	}
+
+	if config.Partition, err = selectPartition(); err != nil {
+		return Config{}, err
+	}
+
+	if config.Metrics, err = selectMetrics(envEnabled("INCLUDE_PRECIPITATION"), config.IncludeAirQuality); err != nil {
+		return Config{}, err
+	}
+
+	if config.WriteHighest, config.WriteLowest, err = selectReportDirection(); err != nil {
+		return Config{}, err
+	}
+
+	if config.RankMode, err = selectRankMode(); err != nil {
+		return Config{}, err
+	}
+
+	if config.WindUnit, err = selectWindUnit(); err != nil {
+		return Config{}, err
+	}
+
+	if config.SortOrders, err = selectSortOrders(); err != nil {
+		return Config{}, err
+	}
+
+	if config.SortBy, err = selectSortBy(); err != nil {
+		return Config{}, err
+	}
+
+	if config.OutputAll, config.OutputAllOnly, err = selectOutputAll(); err != nil {
+		return Config{}, err
+	}
+
+	if config.MaxRowsPerFile, err = selectMaxRowsPerFile(); err != nil {
+		return Config{}, err
+	}
+
+	if config.FilterCountry, err = selectFilterCountry(); err != nil {
+		return Config{}, err
+	}
+
+	if config.MinTemp, config.MaxTemp, err = selectTemperatureRange(); err != nil {
+		return Config{}, err
+	}
+
+	if config.InputFormat, err = selectInputFormat(); err != nil {
+		return Config{}, err
+	}
+
+	if config.MaxInputBytes, err = selectByteLimit("MAX_INPUT_BYTES", defaultMaxInputBytes); err != nil {
+		return Config{}, err
+	}
+
+	if config.MaxCities, config.TruncateCities, err = selectMaxCities(); err != nil {
+		return Config{}, err
+	}
+
+	if config.Provider, err = selectProviderName(); err != nil {
+		return Config{}, err
+	}
+
+	// The api version only matters to OpenWeatherMap, the static provider ignores it
+	if config.Provider == "openweathermap" {
+		if config.APIVersion, err = selectAPIVersion(config.IncludeForecast); err != nil {
+			return Config{}, err
+		}
+	}
+
+	// City ids are looked up through the 2.5 group endpoint, which no other provider or version has
+	if config.CityIDInput && config.APIVersion != "2.5" {
+		return Config{}, fmt.Errorf("CITY_ID_INPUT requires WEATHER_PROVIDER openweathermap and OWM_API_VERSION 2.5")
+	}
+
+	if config.CleanupMode, err = selectCleanupMode(); err != nil {
+		return Config{}, err
+	}
+
+	if config.CityTimeout, err = selectCityTimeout(); err != nil {
+		return Config{}, err
+	}
+
+	if config.CacheTTL, err = selectCacheTTL(); err != nil {
+		return Config{}, err
+	}
+
+	if config.ProgressInterval, err = selectProgressInterval(); err != nil {
+		return Config{}, err
+	}
+
+	if config.MinCount, config.MinPercent, err = selectMinSuccess(); err != nil {
+		return Config{}, err
+	}
+
+	// Strict runs fail on the first city error, leaving no partial results for MIN_SUCCESS to judge
+	if config.Strict && (config.MinCount > 0 || config.MinPercent > 0) {
+		return Config{}, fmt.Errorf("STRICT and MIN_SUCCESS cannot be combined")
+	}
+
+	if config.WebhookURL, err = selectWebhookURL(); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// envValue reads an optional env var with surrounding whitespace removed
+// Inputs:
+//     name: name of the environment variable
+// Output:
+//     The trimmed value, empty when unset
+func envValue(name string) string {
+	return strings.TrimSpace(os.Getenv(name))
+}
@@ -0,0 +1,231 @@
+package weather
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// configEnv lists every env var loadConfig reads, cleared so each test starts from the defaults
+var configEnv = []string{
+	"OUTPUT_FORMAT", "OUTPUT_DELIMITER", "OUTPUT_SINK", "FLOAT_PRECISION", "S3_ACL", "METRICS", "INCLUDE_PRECIPITATION",
+	"INCLUDE_AQI", "REPORT_DIRECTION", "RANK_MODE", "WIND_UNIT", "SORT_ORDER", "SORT_BY", "OUTPUT_ALL",
+	"MAX_ROWS_PER_FILE", "FILTER_COUNTRY", "GROUP_BY_COUNTRY", "MIN_TEMP", "MAX_TEMP", "CLEANUP_MODE",
+	"CITY_TIMEOUT_MS", "CACHE_TTL_SECONDS", "PROGRESS_INTERVAL", "STRICT", "MIN_SUCCESS", "OUTPUT_PREFIX",
+	"PARTITION_OUTPUT", "USE_INPUT_NAME", "CITY_ALLOWLIST", "CITY_DENYLIST", "INPUT_FORMAT", "MAX_INPUT_BYTES",
+	"MAX_CITIES", "MAX_CITIES_BEHAVIOR", "WEATHER_PROVIDER", "OWM_API_VERSION", "INCLUDE_FORECAST", "ARCHIVE_PREFIX",
+	"WEBHOOK_URL", "DRY_RUN", "CITY_ID_INPUT",
+}
+
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+
+	for _, name := range configEnv {
+		t.Setenv(name, "")
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	clearConfigEnv(t)
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Format.Extension != "csv" || config.Sink != "s3" || config.FloatPrecision != defaultFloatPrecision {
+		t.Errorf("got format %s, sink %s and precision %d", config.Format.Extension, config.Sink, config.FloatPrecision)
+	}
+
+	if !reflect.DeepEqual(config.Metrics, defaultMetrics) || !config.WriteHighest || config.WriteLowest {
+		t.Errorf("got metrics %v, highest %v and lowest %v", config.Metrics, config.WriteHighest, config.WriteLowest)
+	}
+
+	if config.WindUnit != "m/s" || config.SortBy != "" || config.OutputAll || config.MaxRowsPerFile != 0 {
+		t.Errorf("got wind unit %s, sort by %q, output all %v and max rows %d",
+			config.WindUnit, config.SortBy, config.OutputAll, config.MaxRowsPerFile)
+	}
+
+	if config.MinTemp != nil || config.MaxTemp != nil || config.CleanupMode != "delete" {
+		t.Errorf("got temperature range %v-%v and cleanup %s", config.MinTemp, config.MaxTemp, config.CleanupMode)
+	}
+
+	if config.CityTimeout != 0 || config.CacheTTL != defaultCacheTTL || config.ProgressInterval != defaultProgressInterval {
+		t.Errorf("got city timeout %s, cache ttl %s and progress interval %d",
+			config.CityTimeout, config.CacheTTL, config.ProgressInterval)
+	}
+
+	if config.Strict || config.MinCount != 0 || config.MinPercent != 0 {
+		t.Errorf("got strict %v and min success %d %v%%", config.Strict, config.MinCount, config.MinPercent)
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	clearConfigEnv(t)
+
+	env := map[string]string{
+		"OUTPUT_FORMAT":     "json",
+		"OUTPUT_SINK":       "stdout",
+		"FLOAT_PRECISION":   "1",
+		"S3_ACL":            "private",
+		"METRICS":           "wind,humidity",
+		"REPORT_DIRECTION":  "both",
+		"WIND_UNIT":         "mph",
+		"SORT_ORDER_WIND":   "asc",
+		"SORT_BY":           "humidity",
+		"MAX_ROWS_PER_FILE": "500",
+		"FILTER_COUNTRY":    "gb",
+		"MIN_TEMP":          "-5",
+		"MAX_TEMP":          "30.5",
+		"CLEANUP_MODE":      "archive",
+		"CITY_TIMEOUT_MS":   "1500",
+		"CACHE_TTL_SECONDS": "60",
+		"PROGRESS_INTERVAL": "0",
+		"MIN_SUCCESS":       "80%",
+		"OUTPUT_PREFIX":     "/prod/reports/",
+		"PARTITION_OUTPUT":  "date",
+		"USE_INPUT_NAME":    "true",
+		"CITY_DENYLIST":     "Paris, Oslo",
+		"INPUT_FORMAT":      "json",
+		"MAX_CITIES":        "100",
+		"OWM_API_VERSION":   "3.0",
+		"ARCHIVE_PREFIX":    "old",
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Format.Extension != "json" || config.Sink != "stdout" || config.FloatPrecision != 1 ||
+		config.ObjectACL != types.ObjectCannedACLPrivate {
+		t.Errorf("got format %s, sink %s, precision %d and acl %s",
+			config.Format.Extension, config.Sink, config.FloatPrecision, config.ObjectACL)
+	}
+
+	if !reflect.DeepEqual(config.Metrics, []string{"wind", "humidity"}) || !config.WriteHighest || !config.WriteLowest {
+		t.Errorf("got metrics %v, highest %v and lowest %v", config.Metrics, config.WriteHighest, config.WriteLowest)
+	}
+
+	if config.WindUnit != "mph" || config.SortOrders["wind"] != "asc" || config.SortBy != "humidity" || config.MaxRowsPerFile != 500 {
+		t.Errorf("got wind unit %s, sort orders %v, sort by %s and max rows %d",
+			config.WindUnit, config.SortOrders, config.SortBy, config.MaxRowsPerFile)
+	}
+
+	if config.FilterCountry != "GB" || config.MinTemp == nil || *config.MinTemp != -5 || config.MaxTemp == nil || *config.MaxTemp != 30.5 {
+		t.Errorf("got country filter %q and temperature range %v-%v", config.FilterCountry, config.MinTemp, config.MaxTemp)
+	}
+
+	if config.CleanupMode != "archive" || config.CityTimeout != 1500*time.Millisecond || config.CacheTTL != time.Minute ||
+		config.ProgressInterval != 0 || config.MinPercent != 80 {
+		t.Errorf("got cleanup %s, city timeout %s, cache ttl %s, progress interval %d and min success %v%%",
+			config.CleanupMode, config.CityTimeout, config.CacheTTL, config.ProgressInterval, config.MinPercent)
+	}
+
+	if config.OutputPrefix != "prod/reports/" || config.Partition != "date" || !config.UseInputName ||
+		!reflect.DeepEqual(config.CityDenylist, map[string]bool{"paris": true, "oslo": true}) {
+		t.Errorf("got prefix %q, partition %q, input names %v and denylist %v",
+			config.OutputPrefix, config.Partition, config.UseInputName, config.CityDenylist)
+	}
+
+	if config.InputFormat != "json" || config.MaxCities != 100 || config.APIVersion != "3.0" || config.ArchivePrefix != "old/" {
+		t.Errorf("got input format %q, max cities %d, api version %q and archive prefix %q",
+			config.InputFormat, config.MaxCities, config.APIVersion, config.ArchivePrefix)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{"OUTPUT_FORMAT", "xml", "unsupported output format"},
+		{"OUTPUT_DELIMITER", "::", "output delimiter"},
+		{"OUTPUT_SINK", "ftp", "OUTPUT_SINK"},
+		{"FLOAT_PRECISION", "-1", "FLOAT_PRECISION"},
+		{"S3_ACL", "public", "S3_ACL"},
+		{"METRICS", "visibility", "visibility"},
+		{"REPORT_DIRECTION", "sideways", "report direction"},
+		{"RANK_MODE", "hail", "rank mode"},
+		{"WIND_UNIT", "knots", "WIND_UNIT"},
+		{"SORT_ORDER", "up", "sort order"},
+		{"SORT_BY", "visibility", "SORT_BY"},
+		{"OUTPUT_ALL", "sometimes", "OUTPUT_ALL"},
+		{"MAX_ROWS_PER_FILE", "0", "MAX_ROWS_PER_FILE"},
+		{"FILTER_COUNTRY", "GB,FR", "FILTER_COUNTRY"},
+		{"FILTER_COUNTRY", "GBR", "FILTER_COUNTRY"},
+		{"MIN_TEMP", "cold", "MIN_TEMP"},
+		{"CLEANUP_MODE", "shred", "cleanup mode"},
+		{"CITY_TIMEOUT_MS", "-10", "CITY_TIMEOUT_MS"},
+		{"CACHE_TTL_SECONDS", "forever", "CACHE_TTL_SECONDS"},
+		{"PROGRESS_INTERVAL", "often", "PROGRESS_INTERVAL"},
+		{"MIN_SUCCESS", "120%", "MIN_SUCCESS"},
+		{"PARTITION_OUTPUT", "hour", "partitioning"},
+		{"INPUT_FORMAT", "xml", "input format"},
+		{"MAX_INPUT_BYTES", "lots", "MAX_INPUT_BYTES"},
+		{"MAX_CITIES", "0", "MAX_CITIES"},
+		{"WEATHER_PROVIDER", "metoffice", "WEATHER_PROVIDER"},
+		{"OWM_API_VERSION", "4.0", "OWM_API_VERSION"},
+		{"WEBHOOK_URL", "hooks.internal/run", "WEBHOOK_URL"},
+	}
+
+	for _, tt := range tests {
+		clearConfigEnv(t)
+		t.Setenv(tt.name, tt.value)
+
+		_, err := loadConfig()
+		if err == nil {
+			t.Errorf("%s=%s: got no error", tt.name, tt.value)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s=%s: got error %q, want it to mention %q", tt.name, tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadConfigCityIDInput(t *testing.T) {
+	tests := []struct {
+		provider string
+		version  string
+		wantErr  bool
+	}{
+		{"", "", false},
+		{"openweathermap", "2.5", false},
+		{"openweathermap", "3.0", true},
+		{"static", "", true},
+	}
+
+	for _, tt := range tests {
+		clearConfigEnv(t)
+		t.Setenv("CITY_ID_INPUT", "true")
+		t.Setenv("WEATHER_PROVIDER", tt.provider)
+		t.Setenv("OWM_API_VERSION", tt.version)
+
+		// The group endpoint only exists in the 2.5 api, so other setups fail before any city is read
+		_, err := loadConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("WEATHER_PROVIDER %q OWM_API_VERSION %q: got error %v", tt.provider, tt.version, err)
+		}
+	}
+}
+
+// testConfig loads the Config from the env vars the test has set, failing the test on a configuration error
+func testConfig(t *testing.T) Config {
+	t.Helper()
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
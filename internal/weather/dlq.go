@@ -0,0 +1,78 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// SQSSendMessageAPI defines the interface for the SendMessage function.
+type SQSSendMessageAPI interface {
+	SendMessage(ctx context.Context,
+		params *sqs.SendMessageInput,
+		optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// DeadLetter defines the interface for the json message sent to the DLQ_URL queue for a failed file
+type DeadLetter struct {
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	ETag      string `json:"etag,omitempty"`
+	Error     string `json:"error"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// sendToDeadLetter records a file that could not be processed on the DLQ_URL queue for inspection or replay,
+//     skipped silently when the queue is not configured
+// Inputs:
+//     ctx: context of the invocation
+//     failure: error that stopped the run
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) sendToDeadLetter(ctx context.Context, failure error) error {
+	queueURL := p.config.DeadLetterURL
+	if queueURL == "" || p.sqsClient == nil || p.localInput {
+		return nil
+	}
+
+	body, err := json.Marshal(DeadLetter{
+		Bucket:    p.inputBucket,
+		Key:       p.uploadKey,
+		ETag:      p.uploadETag,
+		Error:     failure.Error(),
+		RequestID: p.requestID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter! %w", err)
+	}
+
+	if p.dryRun() {
+		p.logger.Printf("dry run: would send %s to %s", body, queueURL)
+		return nil
+	}
+
+	_, err = SendMessage(ctx, p.sqsClient, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("error sending dead letter! %w", err)
+	}
+
+	return nil
+}
+
+// SendMessage delivers a message to an Amazon Simple Queue Service (Amazon SQS) queue
+// Inputs:
+//     c is the context of the method call, which includes the AWS Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a SendMessageOutput object containing the result of the service call and nil
+//     Otherwise, nil and an error from the call to SendMessage
+func SendMessage(c context.Context, api SQSSendMessageAPI, input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	return api.SendMessage(c, input)
+}
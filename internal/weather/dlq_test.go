@@ -0,0 +1,88 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// fakeSQS records every message sent to it
+type fakeSQS struct {
+	sent []*sqs.SendMessageInput
+}
+
+func (f *fakeSQS) SendMessage(ctx context.Context, params *sqs.SendMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.sent = append(f.sent, params)
+
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func TestProcessSendsFailedFileToDeadLetter(t *testing.T) {
+	t.Setenv("DLQ_URL", "https://sqs.us-east-1.amazonaws.com/123456789012/weather-dlq")
+	t.Setenv("DRY_RUN", "")
+
+	client := newFakeS3()
+	queue := &fakeSQS{}
+	p := &Processor{s3Client: client, sqsClient: queue, weatherProvider: &countingProvider{calls: map[string]int{}},
+		inputBucket: "input", outputBucket: "output", requestID: "req-1", logger: log.New(io.Discard, "", 0)}
+
+	// The upload is missing from the bucket so reading it fails the run
+	p.config = testConfig(t)
+	_, runErr := p.Process(context.Background(), "cities.csv", "abc123")
+	if runErr == nil {
+		t.Fatal("expected the run to fail")
+	}
+
+	if len(queue.sent) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(queue.sent))
+	}
+
+	if url := aws.ToString(queue.sent[0].QueueUrl); url != "https://sqs.us-east-1.amazonaws.com/123456789012/weather-dlq" {
+		t.Errorf("got queue %s", url)
+	}
+
+	var letter DeadLetter
+	if err := json.Unmarshal([]byte(aws.ToString(queue.sent[0].MessageBody)), &letter); err != nil {
+		t.Fatal(err)
+	}
+
+	want := DeadLetter{Bucket: "input", Key: "cities.csv", ETag: "abc123", Error: runErr.Error(), RequestID: "req-1"}
+	if letter != want {
+		t.Errorf("got %+v, want %+v", letter, want)
+	}
+}
+
+func TestSendToDeadLetterSkipped(t *testing.T) {
+	tests := []struct {
+		name     string
+		queueURL string
+		dryRun   string
+	}{
+		{"no queue", "", ""},
+		{"dry run", "https://sqs.us-east-1.amazonaws.com/123456789012/weather-dlq", "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DLQ_URL", tt.queueURL)
+			t.Setenv("DRY_RUN", tt.dryRun)
+
+			queue := &fakeSQS{}
+			p := &Processor{sqsClient: queue, uploadKey: "cities.csv", logger: log.New(io.Discard, "", 0)}
+
+			if err := p.sendToDeadLetter(context.Background(), io.ErrUnexpectedEOF); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(queue.sent) != 0 {
+				t.Errorf("got %d dead letters, want none", len(queue.sent))
+			}
+		})
+	}
+}
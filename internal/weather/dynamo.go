@@ -0,0 +1,143 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoPutItemAPI defines the interface for the PutItem function.
+type DynamoPutItemAPI interface {
+	PutItem(ctx context.Context,
+		params *dynamodb.PutItemInput,
+		optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoBatchWriteItemAPI defines the interface for the BatchWriteItem function.
+type DynamoBatchWriteItemAPI interface {
+	BatchWriteItem(ctx context.Context,
+		params *dynamodb.BatchWriteItemInput,
+		optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+}
+
+// DynamoWriteAPI combines the batch and single item write functions used by writeToDynamo.
+type DynamoWriteAPI interface {
+	DynamoPutItemAPI
+	DynamoBatchWriteItemAPI
+}
+
+// WeatherRecord defines the interface for a city's weather item stored in DynamoDB,
+// keyed by city and ingestion timestamp
+type WeatherRecord struct {
+	City       string  `dynamodbav:"city"`
+	IngestedAt string  `dynamodbav:"ingested_at"`
+	SourceKey  string  `dynamodbav:"source_key"`
+	Weather    Weather `dynamodbav:"weather"`
+}
+
+// dynamoBatchSize is the maximum number of items DynamoDB accepts in one BatchWriteItem call
+const dynamoBatchSize = 25
+
+// writeToDynamo stores each city's full weather record in the WEATHER_TABLE table,
+//     skipped when the table is not configured
+// Inputs:
+//     ctx: context of the invocation
+//     weatherList: list of Weather structs to store
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) writeToDynamo(ctx context.Context, weatherList []Weather) error {
+	table := p.config.WeatherTable
+	if table == "" || p.dynamoClient == nil {
+		return nil
+	}
+
+	ingestedAt := time.Now().UTC().Format(time.RFC3339)
+	requests := make([]types.WriteRequest, 0, len(weatherList))
+
+	// Every record shares ingestedAt, so a city listed twice would repeat a key and
+	//     BatchWriteItem rejects the whole batch, the last lookup of a city wins instead
+	positions := make(map[string]int, len(weatherList))
+
+	for _, city := range weatherList {
+		item, err := attributevalue.MarshalMap(WeatherRecord{
+			City:       city.Name,
+			IngestedAt: ingestedAt,
+			SourceKey:  p.uploadKey,
+			Weather:    city,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal weather record for %s! %w", city.Name, err)
+		}
+
+		request := types.WriteRequest{PutRequest: &types.PutRequest{Item: item}}
+		if i, ok := positions[city.Name]; ok {
+			requests[i] = request
+			continue
+		}
+
+		positions[city.Name] = len(requests)
+		requests = append(requests, request)
+	}
+
+	for start := 0; start < len(requests); start += dynamoBatchSize {
+		end := start + dynamoBatchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		params := &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{table: requests[start:end]},
+		}
+
+		output, err := BatchWriteItem(ctx, p.dynamoClient, params)
+		if err != nil {
+			return fmt.Errorf("error writing weather records! %w", err)
+		}
+
+		// Fall back to single puts for anything the batch could not process
+		for _, unprocessed := range output.UnprocessedItems[table] {
+			if unprocessed.PutRequest == nil {
+				continue
+			}
+
+			_, err := PutItem(ctx, p.dynamoClient, &dynamodb.PutItemInput{
+				TableName: aws.String(table),
+				Item:      unprocessed.PutRequest.Item,
+			})
+			if err != nil {
+				return fmt.Errorf("error writing weather record! %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// BatchWriteItem puts multiple items into Amazon DynamoDB tables in a single call
+// Inputs:
+//     c is the context of the method call, which includes the AWS Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a BatchWriteItemOutput object containing the result of the service call and nil
+//     Otherwise, nil and an error from the call to BatchWriteItem
+func BatchWriteItem(c context.Context, api DynamoBatchWriteItemAPI, input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	return api.BatchWriteItem(c, input)
+}
+
+// PutItem puts a single item into an Amazon DynamoDB table
+// Inputs:
+//     c is the context of the method call, which includes the AWS Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a PutItemOutput object containing the result of the service call and nil
+//     Otherwise, nil and an error from the call to PutItem
+func PutItem(c context.Context, api DynamoPutItemAPI, input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return api.PutItem(c, input)
+}
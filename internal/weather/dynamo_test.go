@@ -0,0 +1,72 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// fakeDynamo records the size of every batch written to it
+type fakeDynamo struct {
+	batches []int
+	puts    int
+}
+
+func (f *fakeDynamo) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput,
+	optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	for _, requests := range params.RequestItems {
+		f.batches = append(f.batches, len(requests))
+	}
+
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func (f *fakeDynamo) PutItem(ctx context.Context, params *dynamodb.PutItemInput,
+	optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.puts++
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestWriteToDynamoDeduplicatesCities(t *testing.T) {
+	t.Setenv("WEATHER_TABLE", "weather")
+
+	var weatherList []Weather
+	for _, name := range []string{"London", "Paris", "London", "Oslo", "Paris"} {
+		weatherList = append(weatherList, Weather{Name: name})
+	}
+
+	client := &fakeDynamo{}
+	p := &Processor{config: testConfig(t), dynamoClient: client}
+
+	if err := p.writeToDynamo(context.Background(), weatherList); err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(client.batches) != "[3]" {
+		t.Errorf("got batch sizes %v, want one batch of 3 requests", client.batches)
+	}
+}
+
+func TestWriteToDynamoBatches(t *testing.T) {
+	t.Setenv("WEATHER_TABLE", "weather")
+
+	weatherList := make([]Weather, 2*dynamoBatchSize+1)
+	for i := range weatherList {
+		weatherList[i].Name = fmt.Sprintf("City %d", i)
+	}
+
+	client := &fakeDynamo{}
+	p := &Processor{config: testConfig(t), dynamoClient: client}
+
+	if err := p.writeToDynamo(context.Background(), weatherList); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{dynamoBatchSize, dynamoBatchSize, 1}
+	if fmt.Sprint(client.batches) != fmt.Sprint(want) {
+		t.Errorf("got batch sizes %v, want %v", client.batches, want)
+	}
+}
@@ -0,0 +1,44 @@
+package weather
+
+import "errors"
+
+// Sentinel errors identifying which stage of a run failed, match them with errors.Is
+var (
+	ErrInputRead   = errors.New("failed to read input file")
+	ErrAPICall     = errors.New("weather api call failed")
+	ErrOutputWrite = errors.New("failed to write output")
+	ErrSizeLimit   = errors.New("size limit exceeded")
+	ErrInvalidKey  = errors.New("invalid API key")
+)
+
+// kindError tags an error with one of the sentinel errors while keeping its message and wrapped chain
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e kindError) Unwrap() error {
+	return e.err
+}
+
+func (e kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// withKind tags err with a sentinel error so callers can match it using errors.Is
+// Inputs:
+//     kind: sentinel error describing the failed stage
+//     err: error to tag, nil is passed through
+// Output:
+//     The tagged error, or nil when err is nil
+func withKind(kind error, err error) error {
+	if err == nil || errors.Is(err, kind) {
+		return err
+	}
+
+	return kindError{kind: kind, err: err}
+}
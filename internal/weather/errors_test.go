@@ -0,0 +1,109 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestWithKind(t *testing.T) {
+	if err := withKind(ErrAPICall, nil); err != nil {
+		t.Errorf("withKind(nil) = %v, want nil", err)
+	}
+
+	cause := fmt.Errorf("request failed! %w", context.DeadlineExceeded)
+	err := withKind(ErrAPICall, cause)
+
+	if !errors.Is(err, ErrAPICall) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want it to match ErrAPICall and the wrapped cause", err)
+	}
+
+	if errors.Is(err, ErrInputRead) || errors.Is(err, ErrOutputWrite) {
+		t.Errorf("got %v matching another stage", err)
+	}
+
+	if err.Error() != cause.Error() {
+		t.Errorf("got message %q, want the original %q", err.Error(), cause.Error())
+	}
+
+	if withKind(ErrAPICall, err) != err {
+		t.Error("withKind tagged an error already matching its kind again")
+	}
+}
+
+// failingPutS3 serves reads from the wrapped fakeS3 and rejects every upload
+type failingPutS3 struct {
+	*fakeS3
+}
+
+func (f failingPutS3) PutObject(ctx context.Context, params *s3.PutObjectInput,
+	optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return nil, fmt.Errorf("access denied")
+}
+
+// failingProvider fails every lookup with err
+type failingProvider struct {
+	err error
+}
+
+func (f failingProvider) Fetch(ctx context.Context, location string) (Weather, error) {
+	return Weather{}, f.err
+}
+
+func TestProcessErrorKinds(t *testing.T) {
+	t.Run("input read", func(t *testing.T) {
+		p := &Processor{localInput: true, weatherProvider: failingProvider{}, logger: log.New(io.Discard, "", 0)}
+
+		p.config = testConfig(t)
+		_, err := p.Process(context.Background(), filepath.Join(t.TempDir(), "missing.csv"), "")
+		if !errors.Is(err, ErrInputRead) || !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want ErrInputRead wrapping fs.ErrNotExist", err)
+		}
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		t.Setenv("MAX_INPUT_BYTES", "8")
+
+		client := newFakeS3()
+		client.objects["input/cities.csv"] = []byte("London\nParis\nBerlin\n")
+
+		p := &Processor{s3Client: client, weatherProvider: &countingProvider{calls: map[string]int{}},
+			inputBucket: "input", outputBucket: "output", logger: log.New(io.Discard, "", 0)}
+
+		p.config = testConfig(t)
+		_, err := p.Process(context.Background(), "cities.csv", "")
+		if !errors.Is(err, ErrInputRead) || !errors.Is(err, ErrSizeLimit) {
+			t.Errorf("got %v, want ErrInputRead wrapping ErrSizeLimit", err)
+		}
+	})
+
+	t.Run("api call", func(t *testing.T) {
+		p := &Processor{weatherProvider: failingProvider{err: fmt.Errorf("connection refused")}}
+
+		_, err := p.fetchWeather(context.Background(), "London")
+		if !errors.Is(err, ErrAPICall) || errors.Is(err, ErrInvalidKey) {
+			t.Errorf("got %v, want ErrAPICall only", err)
+		}
+	})
+
+	t.Run("output write", func(t *testing.T) {
+		client := newFakeS3()
+		client.objects["input/cities.csv"] = []byte("London\n")
+
+		p := &Processor{s3Client: failingPutS3{client}, weatherProvider: &countingProvider{calls: map[string]int{}},
+			inputBucket: "input", outputBucket: "output", logger: log.New(io.Discard, "", 0)}
+
+		p.config = testConfig(t)
+		_, err := p.Process(context.Background(), "cities.csv", "")
+		if !errors.Is(err, ErrOutputWrite) {
+			t.Errorf("got %v, want ErrOutputWrite", err)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// GeocodeCache maps a normalized location to coordinates supplied ahead of time
+type GeocodeCache map[string]GeocodeLocation
+
+// loadGeocodeCache reads the json object of location name to coordinates named by GEOCODE_CACHE_KEY,
+//     such as {"London": {"lat": 51.51, "lon": -0.13}}, and hands it to the weather provider
+// Inputs:
+//     ctx: context of the invocation
+// Output:
+//     If success returns nil, otherwise an error matching ErrInputRead
+func (p *Processor) loadGeocodeCache(ctx context.Context) error {
+	key := p.config.GeocodeCacheKey
+	if key == "" {
+		return nil
+	}
+
+	body, err := p.openInput(ctx, key)
+	if err != nil {
+		return withKind(ErrInputRead, fmt.Errorf("failed to read geocode cache %s! %w", key, err))
+	}
+
+	defer body.Close()
+
+	contents, err := readLimited(body, defaultMaxInputBytes, "geocode cache")
+	if err != nil {
+		return withKind(ErrInputRead, fmt.Errorf("failed to read geocode cache %s! %w", key, err))
+	}
+
+	locations := make(map[string]GeocodeLocation)
+	if err := json.Unmarshal(contents, &locations); err != nil {
+		return withKind(ErrInputRead, fmt.Errorf("failed to load JSON into Struct! %w", err))
+	}
+
+	cache := make(GeocodeCache, len(locations))
+	for name, location := range locations {
+		if location.Name == "" {
+			location.Name = name
+		}
+		cache[normalizeCity(name)] = location
+	}
+
+	switch api := p.weatherProvider.(type) {
+	case CurrentWeatherAPI:
+		api.Locations = cache
+		p.weatherProvider = api
+	case OneCallAPI:
+		api.Locations = cache
+		p.weatherProvider = api
+	default:
+		return fmt.Errorf("GEOCODE_CACHE_KEY requires the openweathermap WEATHER_PROVIDER")
+	}
+
+	return nil
+}
+
+// coordinateQuery builds the api query parameters for a location with known coordinates
+func coordinateQuery(location GeocodeLocation) string {
+	return "lat=" + strconv.FormatFloat(location.Lat, 'f', -1, 64) + "&lon=" + strconv.FormatFloat(location.Lon, 'f', -1, 64)
+}
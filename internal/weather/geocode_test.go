@@ -0,0 +1,147 @@
+package weather
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadGeocodeCacheSkipsGeocoding(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "test")
+	t.Setenv("GEOCODE_CACHE_KEY", "geocode-cache.json")
+
+	geocode, err := os.ReadFile(filepath.Join("testdata", "geocode.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oneCall, err := os.ReadFile(filepath.Join("testdata", "onecall.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var geocoded []string
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/geo/1.0/direct":
+			geocoded = append(geocoded, r.URL.Query().Get("q"))
+			w.Write(geocode)
+		case "/data/3.0/onecall":
+			queries = append(queries, r.URL.Query())
+			w.Write(oneCall)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := newFakeS3()
+	client.objects["input/geocode-cache.json"] = []byte(`{"Paris": {"lat": 48.8566, "lon": 2.3522}}`)
+
+	p := &Processor{config: testConfig(t), s3Client: client, inputBucket: "input", weatherProvider: OneCallAPI{
+		Client:           server.Client(),
+		BaseURL:          server.URL + "/data/3.0/onecall",
+		GeocodeURL:       server.URL + "/geo/1.0",
+		MaxResponseBytes: defaultMaxResponseBytes,
+	}}
+
+	if err := p.loadGeocodeCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	paris, err := p.weatherProvider.Fetch(context.Background(), " paris ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if paris.Name != "Paris" || paris.Coord.Lat != 48.8566 || paris.Coord.Lon != 2.3522 {
+		t.Errorf("got cached city %s at %v, want Paris at its cached coordinates", paris.Name, paris.Coord)
+	}
+
+	if _, err := p.weatherProvider.Fetch(context.Background(), "London"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(geocoded) != 1 || geocoded[0] != "London" {
+		t.Errorf("got geocoding calls %v, want only the uncached London", geocoded)
+	}
+
+	if len(queries) != 2 || queries[0].Get("lat") != "48.8566" || queries[0].Get("lon") != "2.3522" {
+		t.Errorf("got One Call queries %v, want Paris queried by its cached coordinates", queries)
+	}
+}
+
+func TestLoadGeocodeCacheCurrentWeather(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "test")
+	t.Setenv("GEOCODE_CACHE_KEY", "geocode-cache.json")
+
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		w.Write([]byte(`{"name": "Paris", "cod": 200}`))
+	}))
+	defer server.Close()
+
+	client := newFakeS3()
+	client.objects["input/geocode-cache.json"] = []byte(`{"Paris": {"lat": 48.8566, "lon": 2.3522}}`)
+
+	p := &Processor{config: testConfig(t), s3Client: client, inputBucket: "input", weatherProvider: CurrentWeatherAPI{
+		Client:           server.Client(),
+		BaseURL:          server.URL,
+		MaxResponseBytes: defaultMaxResponseBytes,
+	}}
+
+	if err := p.loadGeocodeCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, city := range []string{"Paris", "Lyon"} {
+		if _, err := p.weatherProvider.Fetch(context.Background(), city); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(queries) != 2 {
+		t.Fatalf("got %d queries, want 2", len(queries))
+	}
+
+	if queries[0].Get("lat") != "48.8566" || queries[0].Get("lon") != "2.3522" || queries[0].Has("q") {
+		t.Errorf("got query %v for cached Paris, want its coordinates", queries[0])
+	}
+
+	if queries[1].Get("q") != "Lyon" || queries[1].Has("lat") {
+		t.Errorf("got query %v for uncached Lyon, want it queried by name", queries[1])
+	}
+}
+
+func TestLoadGeocodeCacheErrors(t *testing.T) {
+	client := newFakeS3()
+	client.objects["input/invalid.json"] = []byte(`["Paris"]`)
+	client.objects["input/valid.json"] = []byte(`{"Paris": {"lat": 48.8566, "lon": 2.3522}}`)
+
+	tests := []struct {
+		key      string
+		provider WeatherProvider
+		wantErr  bool
+	}{
+		{"", failingProvider{}, false},
+		{"missing.json", OneCallAPI{}, true},
+		{"invalid.json", OneCallAPI{}, true},
+		{"valid.json", failingProvider{}, true},
+		{"valid.json", CurrentWeatherAPI{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("GEOCODE_CACHE_KEY", tt.key)
+
+		p := &Processor{config: testConfig(t), s3Client: client, inputBucket: "input", weatherProvider: tt.provider}
+		if err := p.loadGeocodeCache(context.Background()); (err != nil) != tt.wantErr {
+			t.Errorf("GEOCODE_CACHE_KEY %q with %T: got error %v, want error %v", tt.key, tt.provider, err, tt.wantErr)
+		}
+	}
+}
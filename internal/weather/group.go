@@ -0,0 +1,218 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// groupBatchSize is the most city ids the group endpoint accepts in one request
+const groupBatchSize = 20
+
+// cityIDPattern matches numeric OpenWeatherMap city ids such as 2643743
+var cityIDPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// GroupResponse defines the interface for the json object returned from the group endpoint
+type GroupResponse struct {
+	Cod     json.Number `json:"cod"`
+	Message string      `json:"message"`
+	List    []Weather   `json:"list"`
+}
+
+// isCityID reports whether a location token should be looked up through the group endpoint,
+//     numeric tokens are only treated as city ids when CITY_ID_INPUT is set
+func (config Config) isCityID(city string) bool {
+	return config.CityIDInput && cityIDPattern.MatchString(city)
+}
+
+// groupURL derives the group endpoint from the configured current weather endpoint
+// Output:
+//     If success returns the group endpoint url and nil, otherwise an error
+func (api CurrentWeatherAPI) groupURL() (string, error) {
+	parsed, err := url.Parse(api.BaseURL)
+	if err != nil {
+		return "", err
+	}
+
+	parsed.Path = strings.TrimSuffix(path.Dir(parsed.Path), "/") + "/group"
+
+	return parsed.String(), nil
+}
+
+// fetchGroup calls the group endpoint for up to groupBatchSize city ids in one request
+// Inputs:
+//     ctx: context of the request
+//     ids: numeric city ids to look up
+// Output:
+//     If success returns the decoded Weather for each id found and nil, otherwise an error
+func (api CurrentWeatherAPI) fetchGroup(ctx context.Context, ids []string) ([]Weather, error) {
+	endpoint, err := api.groupURL()
+	if err != nil {
+		return nil, err
+	}
+
+	params := fmt.Sprintf("?id=%s&units=%s&appid=%s", strings.Join(ids, ","), weatherUnits, owmAPIKey())
+	if lang := owmLanguage(); lang != "" {
+		params += "&lang=" + lang
+	}
+
+	status, body, err := getBody(ctx, api.Client, api.Limiter, endpoint+params, api.MaxResponseBytes, api.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("group lookup failed for %s! %d %s", strings.Join(ids, ","), status, strings.TrimSpace(string(body)))
+	}
+
+	group := GroupResponse{}
+	if err := json.Unmarshal(body, &group); err != nil {
+		return nil, fmt.Errorf("failed to load JSON into Struct! %w", err)
+	}
+
+	// Like single lookups, the api may report a failure in the body of a 200 response
+	if group.Cod != "" && group.Cod != "200" {
+		return nil, fmt.Errorf("group lookup failed for %s! %s %s", strings.Join(ids, ","), group.Cod, group.Message)
+	}
+
+	return group.List, nil
+}
+
+// populateGroup looks up a batch of city ids with one group request and appends the results
+// Inputs:
+//     ctx: context of the invocation, carrying any active trace segment
+//     tokens: city id tokens in input order, duplicates allowed
+//     weatherCache: map of normalized city name to the index in weatherList of its Weather already fetched in this run
+//     config: Config of the run providing the city timeout, which bounds the group request
+//     weatherList: list of Weather struct pointers
+// Output:
+//     If success returns nil, otherwise an error matching ErrAPICall
+func (p *Processor) populateGroup(ctx context.Context, tokens []string, weatherCache map[string]int, config Config,
+	weatherList *[]Weather) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	// loadConfig only allows CITY_ID_INPUT with the 2.5 api, so this only fails for a provider built elsewhere
+	api, ok := p.weatherProvider.(CurrentWeatherAPI)
+	if !ok {
+		return fmt.Errorf("CITY_ID_INPUT requires OWM_API_VERSION 2.5")
+	}
+
+	ids := make([]string, 0, len(tokens))
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		if !seen[token] {
+			seen[token] = true
+			ids = append(ids, token)
+		}
+	}
+
+	var group []Weather
+
+	start := time.Now()
+	err := p.traceSegment(ctx, "weather:group", func(ctx context.Context) error {
+		if config.CityTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, config.CityTimeout)
+			defer cancel()
+		}
+
+		var err error
+		group, err = api.fetchGroup(ctx, ids)
+		return err
+	})
+	p.metrics.ObserveAPILatency(time.Since(start))
+
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("stopped fetching weather for city ids! %w", ctx.Err())
+	}
+
+	if err != nil {
+		for _, token := range tokens {
+			if err := p.cityFailed(token, withKind(ErrAPICall, err)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	byID := make(map[string]Weather, len(group))
+	for _, cityWeather := range group {
+		byID[strconv.Itoa(cityWeather.ID)] = cityWeather
+	}
+
+	// The group endpoint has no air quality, so each city's reading is looked up as Fetch does
+	airQualityErrs := make(map[string]error)
+	if api.IncludeAirQuality {
+		for id, cityWeather := range byID {
+			airQuality, err := p.groupAirQuality(ctx, api, cityWeather, config)
+			if err != nil && ctx.Err() != nil {
+				return fmt.Errorf("stopped fetching weather for city ids! %w", ctx.Err())
+			}
+
+			if err != nil {
+				airQualityErrs[id] = err
+				continue
+			}
+
+			cityWeather.AirQuality = airQuality
+			byID[id] = cityWeather
+		}
+	}
+
+	for _, token := range tokens {
+		cityWeather, ok := byID[token]
+		if !ok {
+			err := withKind(ErrAPICall, fmt.Errorf("weather lookup failed for %s! city id not found", token))
+			if err := p.cityFailed(token, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := airQualityErrs[token]; err != nil {
+			if err := p.cityFailed(token, withKind(ErrAPICall, err)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		p.metrics.CityProcessed()
+
+		cityWeather.InputName = config.reportedInputName(token)
+
+		weatherCache[normalizeCity(token)] = len(*weatherList)
+		*weatherList = append(*weatherList, cityWeather)
+	}
+
+	return nil
+}
+
+// groupAirQuality looks up the air quality of a city returned by the group endpoint
+// Inputs:
+//     ctx: context of the invocation
+//     api: CurrentWeatherAPI providing the air pollution endpoint
+//     cityWeather: Weather of the city, providing its coordinates
+//     config: Config of the run providing the city timeout
+// Output:
+//     If success returns the city's AirQuality and nil, otherwise an error
+func (p *Processor) groupAirQuality(ctx context.Context, api CurrentWeatherAPI, cityWeather Weather, config Config) (*AirQuality, error) {
+	if config.CityTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.CityTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	defer func() { p.metrics.ObserveAPILatency(time.Since(start)) }()
+
+	return fetchAirQuality(ctx, api.Client, api.Limiter, api.AirPollutionURL, cityWeather, api.MaxResponseBytes, api.MaxRetries)
+}
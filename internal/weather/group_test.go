@@ -0,0 +1,187 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// groupCities are the cities served by newGroupServer keyed by city id
+var groupCities = map[string]string{"2643743": "London", "2988507": "Paris", "2950159": "Berlin"}
+
+// newGroupServer serves the group endpoint for groupCities, ids it does not know are left out of the list,
+//     and the air pollution endpoint for every city except Berlin
+func newGroupServer(t *testing.T, requests *[]string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/air_pollution") {
+			if r.URL.Query().Get("lat") == "52.52" {
+				http.Error(w, "unavailable", http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprint(w, `{"list":[{"main":{"aqi":2},"components":{"pm2_5":5.1}}]}`)
+			return
+		}
+
+		ids := r.URL.Query().Get("id")
+		if requests != nil {
+			*requests = append(*requests, ids)
+		}
+
+		list := make([]string, 0)
+		for _, id := range strings.Split(ids, ",") {
+			if name, ok := groupCities[id]; ok {
+				lat := 51.5
+				if name == "Berlin" {
+					lat = 52.52
+				}
+				list = append(list, fmt.Sprintf(`{"id":%s,"name":%q,"coord":{"lat":%v,"lon":0}}`, id, name, lat))
+			}
+		}
+		fmt.Fprintf(w, `{"cod":"200","cnt":%d,"list":[%s]}`, len(list), strings.Join(list, ","))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// groupProcessor builds a Processor looking city ids up on server
+func groupProcessor(t *testing.T, server *httptest.Server, includeAirQuality bool) *Processor {
+	t.Helper()
+
+	return &Processor{
+		weatherProvider: CurrentWeatherAPI{
+			Client:            server.Client(),
+			BaseURL:           server.URL + "/data/2.5/weather",
+			MaxResponseBytes:  defaultMaxResponseBytes,
+			IncludeAirQuality: includeAirQuality,
+			AirPollutionURL:   server.URL + "/data/2.5/air_pollution",
+		},
+		metrics: multiRecorder{},
+		config:  testConfig(t),
+		logger:  log.New(io.Discard, "", 0),
+	}
+}
+
+func TestPopulateGroupAirQuality(t *testing.T) {
+	t.Setenv("CITY_ID_INPUT", "true")
+	t.Setenv("STRICT", "")
+
+	p := groupProcessor(t, newGroupServer(t, nil), true)
+
+	var weatherList []Weather
+	if err := p.populateGroup(context.Background(), []string{"2643743", "2950159"}, map[string]int{}, p.config,
+		&weatherList); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(weatherList) != 1 || weatherList[0].Name != "London" || weatherList[0].AirQuality == nil ||
+		weatherList[0].AirQuality.AQI != 2 {
+		t.Fatalf("got %+v, want London with its air quality", weatherList)
+	}
+
+	// Berlin's reading failed, so only Berlin is skipped
+	if len(p.failedCities) != 1 || p.failedCities[0] != "2950159" {
+		t.Errorf("got failed cities %v, want Berlin's id", p.failedCities)
+	}
+}
+
+// populateIDs runs the fetch stage over tokens with CITY_ID_INPUT set
+func populateIDs(t *testing.T, p *Processor, tokens ...string) []Weather {
+	t.Helper()
+
+	input := make(chan string, len(tokens))
+	for _, token := range tokens {
+		input <- token
+	}
+	close(input)
+
+	var weatherList []Weather
+	if err := p.populateWeatherList(context.Background(), input, map[string]int{}, p.config, &weatherList); err != nil {
+		t.Fatal(err)
+	}
+
+	return weatherList
+}
+
+func TestPopulateGroupBatches(t *testing.T) {
+	t.Setenv("CITY_ID_INPUT", "true")
+	t.Setenv("STRICT", "")
+
+	var requests []string
+	p := groupProcessor(t, newGroupServer(t, &requests), false)
+
+	tokens := make([]string, 45)
+	for i := range tokens {
+		tokens[i] = strconv.Itoa(1000 + i)
+	}
+	populateIDs(t, p, tokens...)
+
+	// 45 ids are split at groupBatchSize, the rest flushed once the input ends
+	if len(requests) != 3 {
+		t.Fatalf("got %d group requests, want 3", len(requests))
+	}
+
+	for i, want := range []int{groupBatchSize, groupBatchSize, 5} {
+		if got := len(strings.Split(requests[i], ",")); got != want {
+			t.Errorf("request %d: got %d ids, want %d", i, got, want)
+		}
+	}
+}
+
+func TestPopulateGroupMissingID(t *testing.T) {
+	t.Setenv("CITY_ID_INPUT", "true")
+	t.Setenv("STRICT", "")
+
+	p := groupProcessor(t, newGroupServer(t, nil), false)
+
+	weatherList := populateIDs(t, p, "2643743", "999", "2988507")
+
+	if len(weatherList) != 2 || weatherList[0].Name != "London" || weatherList[1].Name != "Paris" {
+		t.Errorf("got %+v, want London and Paris", weatherList)
+	}
+
+	if len(p.failedCities) != 1 || p.failedCities[0] != "999" {
+		t.Errorf("got failed cities %v, want only 999", p.failedCities)
+	}
+}
+
+func TestPopulateGroupDeduplicatesIDs(t *testing.T) {
+	t.Setenv("CITY_ID_INPUT", "true")
+	t.Setenv("STRICT", "")
+
+	var requests []string
+	p := groupProcessor(t, newGroupServer(t, &requests), false)
+
+	weatherList := populateIDs(t, p, "2643743", "2988507", "2643743")
+
+	// A repeated id is requested once but still reported for every occurrence
+	if len(requests) != 1 || requests[0] != "2643743,2988507" {
+		t.Errorf("got group requests %v, want each id once", requests)
+	}
+
+	if len(weatherList) != 3 || weatherList[2].Name != "London" {
+		t.Errorf("got %+v, want London, Paris and London", weatherList)
+	}
+}
+
+func TestFetchGroupBodyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"cod":"400","message":"bad id"}`)
+	}))
+	defer server.Close()
+
+	api := CurrentWeatherAPI{Client: server.Client(), BaseURL: server.URL + "/data/2.5/weather", MaxResponseBytes: defaultMaxResponseBytes}
+
+	_, err := api.fetchGroup(context.Background(), []string{"2643743"})
+	if err == nil || !strings.Contains(err.Error(), "400 bad id") {
+		t.Errorf("got %v, want the body's error", err)
+	}
+}
@@ -0,0 +1,103 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// HealthCheckEvent defines the interface for the synthetic canary payload, {"healthCheck": true}
+type HealthCheckEvent struct {
+	HealthCheck bool `json:"healthCheck"`
+}
+
+// IsHealthCheck reports whether the invocation payload is a health check rather than an S3 event
+// Inputs:
+//     payload: raw invocation payload
+// Output:
+//     true if the payload sets healthCheck, otherwise false
+func IsHealthCheck(payload json.RawMessage) bool {
+	event := HealthCheckEvent{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return false
+	}
+
+	return event.HealthCheck
+}
+
+// RunHealthCheck verifies the api key works and both buckets are reachable without processing a file
+// Inputs:
+//     ctx: context of the invocation
+// Output:
+//     Response with the status of each dependency, 200 when all are healthy otherwise 503
+func (p *Processor) RunHealthCheck(ctx context.Context) Response {
+	dependencies := map[string]string{
+		"weatherApi":   p.checkWeatherAPI(ctx),
+		"inputBucket":  p.checkBucket(ctx, p.inputBucket),
+		"outputBucket": p.checkBucket(ctx, p.outputBucket),
+	}
+
+	for _, status := range dependencies {
+		if status != "ok" {
+			return Response{StatusCode: "503", StatusMessage: "Unhealthy", Dependencies: dependencies, RequestID: p.requestID}
+		}
+	}
+
+	return Response{StatusCode: "200", StatusMessage: "Healthy", Dependencies: dependencies, RequestID: p.requestID}
+}
+
+// selectHealthCheckCity reads the HEALTH_CHECK_CITY env var naming the city a health check looks up, defaulting to London
+func selectHealthCheckCity() string {
+	city := strings.TrimSpace(os.Getenv("HEALTH_CHECK_CITY"))
+	if city == "" {
+		return "London"
+	}
+
+	return city
+}
+
+// checkWeatherAPI looks up a single known city to confirm the api key is accepted
+// Inputs:
+//     ctx: context of the invocation
+// Output:
+//     "ok" if the lookup succeeded, otherwise the error message
+func (p *Processor) checkWeatherAPI(ctx context.Context) string {
+	if _, err := p.fetchWeather(ctx, p.config.HealthCheckCity); err != nil {
+		return err.Error()
+	}
+
+	return "ok"
+}
+
+// checkBucket confirms a bucket exists and the lambda role can access it
+// Inputs:
+//     ctx: context of the invocation
+//     bucket: name of the bucket to check
+// Output:
+//     "ok" if the bucket is reachable, otherwise the error message
+func (p *Processor) checkBucket(ctx context.Context, bucket string) string {
+	_, err := HeadBucket(ctx, p.s3Client, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return err.Error()
+	}
+
+	return "ok"
+}
+
+// HeadBucket checks an Amazon Simple Storage Service (Amazon S3) bucket exists and is accessible
+// Inputs:
+//     c is the context of the method call, which includes the AWS Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a HeadBucketOutput object containing the result of the service call and nil
+//     Otherwise, nil and an error from the call to HeadBucket
+func HeadBucket(c context.Context, api S3HeadBucketAPI, input *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	return api.HeadBucket(c, input)
+}
@@ -0,0 +1,85 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// missingBucketS3 serves the wrapped fakeS3 but reports one bucket as missing
+type missingBucketS3 struct {
+	*fakeS3
+	bucket string
+}
+
+func (f missingBucketS3) HeadBucket(ctx context.Context, params *s3.HeadBucketInput,
+	optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	if *params.Bucket == f.bucket {
+		return nil, fmt.Errorf("not found")
+	}
+
+	return f.fakeS3.HeadBucket(ctx, params, optFns...)
+}
+
+func TestIsHealthCheck(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    bool
+	}{
+		{`{"healthCheck":true}`, true},
+		{`{"healthCheck":false}`, false},
+		{`{"bucket":"input","key":"cities.csv"}`, false},
+		{`{"Records":[]}`, false},
+		{`not json`, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsHealthCheck(json.RawMessage(tt.payload)); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestRunHealthCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		provider   WeatherProvider
+		missing    string
+		wantStatus string
+		wantFailed string
+	}{
+		{"healthy", &countingProvider{calls: map[string]int{}}, "", "200", ""},
+		{"api key rejected", failingProvider{err: fmt.Errorf("401 invalid api key")}, "", "503", "weatherApi"},
+		{"output bucket missing", &countingProvider{calls: map[string]int{}}, "output", "503", "outputBucket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HEALTH_CHECK_CITY", "Oslo")
+
+			p := &Processor{s3Client: missingBucketS3{newFakeS3(), tt.missing}, weatherProvider: tt.provider,
+				inputBucket: "input", outputBucket: "output", config: testConfig(t), logger: log.New(io.Discard, "", 0)}
+
+			response := p.RunHealthCheck(context.Background())
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("got status %s, want %s", response.StatusCode, tt.wantStatus)
+			}
+
+			for dependency, status := range response.Dependencies {
+				if (dependency == tt.wantFailed) == (status == "ok") {
+					t.Errorf("got %s %q", dependency, status)
+				}
+			}
+
+			// The health check looks up HEALTH_CHECK_CITY rather than any input file
+			if provider, ok := tt.provider.(*countingProvider); ok && provider.calls["Oslo"] != 1 {
+				t.Errorf("got api calls %v, want Oslo", provider.calls)
+			}
+		})
+	}
+}
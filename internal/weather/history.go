@@ -0,0 +1,126 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/jszwec/csvutil"
+)
+
+// historyName is the file name of the time series accumulated across runs
+const historyName = "history.csv"
+
+// HistoryRow defines the interface for one run recorded in the csv history
+type HistoryRow struct {
+	Timestamp          string  `csv:"Timestamp"`
+	InputKey           string  `csv:"Input Key"`
+	TopTemperatureCity string  `csv:"Top Temperature City"`
+	TopTemperature     float64 `csv:"Top Temperature"`
+	TopWindCity        string  `csv:"Top Wind City"`
+	TopWindSpeed       float64 `csv:"Top Wind Speed"`
+}
+
+// historyKey is the output key of history.csv under the configured OUTPUT_PREFIX
+func (config Config) historyKey() string {
+	return config.OutputPrefix + historyName
+}
+
+// appendHistory adds this run's top cities to history.csv in the s3 output bucket,
+//     skipped unless ENABLE_HISTORY is set
+// Inputs:
+//     ctx: context of the invocation
+//     weatherList: list of Weather structs processed in the run
+//     windUnit: WIND_UNIT the top wind speed is recorded in
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) appendHistory(ctx context.Context, weatherList []Weather, windUnit string) error {
+	if !p.config.History {
+		return nil
+	}
+
+	key := p.config.historyKey()
+
+	history, err := p.readHistory(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	row := HistoryRow{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		InputKey:  p.uploadKey,
+	}
+
+	temperatures, winds := extractWeatherInfo(weatherList, false, windUnit, false)
+	if len(temperatures) > 0 {
+		row.TopTemperatureCity = temperatures[0].City
+		row.TopTemperature = temperatures[0].Temperature
+	}
+	if len(winds) > 0 {
+		row.TopWindCity = winds[0].City
+		row.TopWindSpeed = winds[0].WindSpeed
+	}
+
+	// Concurrent runs may race on the read and write back, the last writer wins
+	body, err := csvutil.Marshal(append(history, row))
+	if err != nil {
+		return withKind(ErrOutputWrite, fmt.Errorf("failed to marshal history! %w", err))
+	}
+
+	err = p.uploadOutput(ctx, p.outputBucket, key, body, outputFormats["csv"])
+	if err != nil {
+		return withKind(ErrOutputWrite, fmt.Errorf("error uploading %s! %w", key, err))
+	}
+
+	return nil
+}
+
+// readHistory loads the rows already recorded in the history file
+// Inputs:
+//     ctx: context of the invocation
+//     key: output key of the history file
+// Output:
+//     If success returns the existing rows, empty on the first run, and nil, otherwise an error
+func (p *Processor) readHistory(ctx context.Context, key string) ([]HistoryRow, error) {
+	history := make([]HistoryRow, 0)
+
+	// Local runs have no output bucket to read from
+	if p.localInput {
+		return history, nil
+	}
+
+	response, err := p.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(p.outputBucket),
+		Key:    aws.String(key),
+	})
+
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return history, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s! %w", key, err)
+	}
+
+	defer response.Body.Close()
+
+	body, err := readLimited(response.Body, p.config.MaxInputBytes, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s! %w", key, err)
+	}
+
+	if len(body) == 0 {
+		return history, nil
+	}
+
+	if err := csvutil.Unmarshal(body, &history); err != nil {
+		return nil, fmt.Errorf("failed to parse %s! %w", key, err)
+	}
+
+	return history, nil
+}
@@ -0,0 +1,110 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoGetItemAPI defines the interface for the GetItem function.
+type DynamoGetItemAPI interface {
+	GetItem(ctx context.Context,
+		params *dynamodb.GetItemInput,
+		optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// DynamoIdempotencyAPI combines the read and write functions used by the idempotency guard.
+type DynamoIdempotencyAPI interface {
+	DynamoGetItemAPI
+	DynamoPutItemAPI
+}
+
+// idempotencyID builds the key recorded for a processed upload, the table's partition key is a string "id"
+// Output:
+//     bucket/key:etag of the current upload
+func (p *Processor) idempotencyID() string {
+	return p.inputBucket + "/" + p.uploadKey + ":" + p.uploadETag
+}
+
+// alreadyProcessed checks whether the current upload was recorded by a previous run
+// Inputs:
+//     ctx: context of the invocation
+// Output:
+//     If success returns true when the upload was already processed and nil, otherwise an error
+func (p *Processor) alreadyProcessed(ctx context.Context) (bool, error) {
+	table := p.config.IdempotencyTable
+
+	// Direct invocations carry no ETag, recording bucket/key: would skip every later run of the key
+	if table == "" || p.idempotencyClient == nil || p.uploadETag == "" {
+		return false, nil
+	}
+
+	output, err := GetItem(ctx, p.idempotencyClient, &dynamodb.GetItemInput{
+		TableName:      aws.String(table),
+		Key:            map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: p.idempotencyID()}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return false, fmt.Errorf("error checking idempotency record! %w", err)
+	}
+
+	return len(output.Item) > 0, nil
+}
+
+// markProcessed records the current upload so redelivered events are skipped
+// Inputs:
+//     ctx: context of the invocation
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) markProcessed(ctx context.Context) error {
+	table := p.config.IdempotencyTable
+	if table == "" || p.idempotencyClient == nil || p.uploadETag == "" {
+		return nil
+	}
+
+	// A dry run must not stop the real run from processing the upload
+	if p.dryRun() {
+		p.logger.Printf("dry run: would record %s in %s", p.idempotencyID(), table)
+		return nil
+	}
+
+	_, err := PutItem(ctx, p.idempotencyClient, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"id":           &types.AttributeValueMemberS{Value: p.idempotencyID()},
+			"object_key":   &types.AttributeValueMemberS{Value: p.uploadKey},
+			"etag":         &types.AttributeValueMemberS{Value: p.uploadETag},
+			"processed_at": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+
+	// A concurrent delivery recording the same upload first is not a failure
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("error recording idempotency record! %w", err)
+	}
+
+	return nil
+}
+
+// GetItem reads a single item from an Amazon DynamoDB table
+// Inputs:
+//     c is the context of the method call, which includes the AWS Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a GetItemOutput object containing the result of the service call and nil
+//     Otherwise, nil and an error from the call to GetItem
+func GetItem(c context.Context, api DynamoGetItemAPI, input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return api.GetItem(c, input)
+}
@@ -0,0 +1,90 @@
+package weather
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeIdempotency stores recorded ids in memory
+type fakeIdempotency struct {
+	ids   map[string]bool
+	calls int
+}
+
+func (f *fakeIdempotency) GetItem(ctx context.Context, params *dynamodb.GetItemInput,
+	optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.calls++
+
+	id := params.Key["id"].(*types.AttributeValueMemberS).Value
+	if !f.ids[id] {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+
+	return &dynamodb.GetItemOutput{Item: params.Key}, nil
+}
+
+func (f *fakeIdempotency) PutItem(ctx context.Context, params *dynamodb.PutItemInput,
+	optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.calls++
+
+	f.ids[params.Item["id"].(*types.AttributeValueMemberS).Value] = true
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestIdempotencyGuard(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TABLE", "processed")
+	t.Setenv("DRY_RUN", "")
+
+	ctx := context.Background()
+	client := &fakeIdempotency{ids: make(map[string]bool)}
+	p := &Processor{config: testConfig(t), idempotencyClient: client, inputBucket: "input", uploadKey: "cities.csv", uploadETag: "abc123"}
+
+	if done, err := p.alreadyProcessed(ctx); err != nil || done {
+		t.Fatalf("first delivery: got %v, %v", done, err)
+	}
+
+	if err := p.markProcessed(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !client.ids["input/cities.csv:abc123"] {
+		t.Errorf("got recorded ids %v", client.ids)
+	}
+
+	if done, err := p.alreadyProcessed(ctx); err != nil || !done {
+		t.Errorf("redelivery: got %v, %v, want true", done, err)
+	}
+
+	// A new version of the same key carries a new ETag
+	p.uploadETag = "def456"
+	if done, err := p.alreadyProcessed(ctx); err != nil || done {
+		t.Errorf("new version: got %v, %v, want false", done, err)
+	}
+}
+
+func TestIdempotencyGuardSkipsEmptyETag(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TABLE", "processed")
+	t.Setenv("DRY_RUN", "")
+
+	ctx := context.Background()
+	client := &fakeIdempotency{ids: make(map[string]bool)}
+	p := &Processor{config: testConfig(t), idempotencyClient: client, inputBucket: "input", uploadKey: "cities.csv"}
+
+	for run := 0; run < 2; run++ {
+		if done, err := p.alreadyProcessed(ctx); err != nil || done {
+			t.Fatalf("run %d: got %v, %v, want false", run, done, err)
+		}
+
+		if err := p.markProcessed(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if client.calls != 0 {
+		t.Errorf("got %d table calls, want none without an ETag", client.calls)
+	}
+}
@@ -0,0 +1,126 @@
+package weather
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxInputBytes bounds the input file when MAX_INPUT_BYTES is unset
+const defaultMaxInputBytes = 10 << 20
+
+// defaultMaxResponseBytes bounds each api response when MAX_RESPONSE_BYTES is unset
+const defaultMaxResponseBytes = 1 << 20
+
+// selectByteLimit reads a byte limit from the environment
+// Inputs:
+//     name: name of the environment variable
+//     defaultLimit: limit used when the variable is unset
+// Output:
+//     If success returns the limit and nil, otherwise an error
+func selectByteLimit(name string, defaultLimit int64) (int64, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return defaultLimit, nil
+	}
+
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid %s! %s must be a positive integer", name, value)
+	}
+
+	return limit, nil
+}
+
+// errCityLimit stops reading the input once MAX_CITIES cities have been read in truncate mode
+var errCityLimit = errors.New("city limit reached")
+
+// selectMaxCities reads the MAX_CITIES env var bounding how many cities one run queries,
+//     and MAX_CITIES_BEHAVIOR choosing whether a larger input is rejected or truncated
+// Output:
+//     If success returns the limit, zero when unset, whether to truncate instead of failing and nil,
+//     otherwise an error
+func selectMaxCities() (int, bool, error) {
+	value := strings.TrimSpace(os.Getenv("MAX_CITIES"))
+	if value == "" {
+		return 0, false, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, false, fmt.Errorf("invalid MAX_CITIES! %s must be a positive integer", value)
+	}
+
+	behavior := strings.ToLower(strings.TrimSpace(os.Getenv("MAX_CITIES_BEHAVIOR")))
+
+	switch behavior {
+	case "", "error":
+		return limit, false, nil
+	case "truncate":
+		return limit, true, nil
+	default:
+		return 0, false, fmt.Errorf("unsupported MAX_CITIES_BEHAVIOR! %s", behavior)
+	}
+}
+
+// selectMaxRowsPerFile reads the MAX_ROWS_PER_FILE env var splitting larger reports into numbered part files
+// Output:
+//     If success returns the limit, zero when unset, and nil, otherwise an error
+func selectMaxRowsPerFile() (int, error) {
+	value := strings.TrimSpace(os.Getenv("MAX_ROWS_PER_FILE"))
+	if value == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid MAX_ROWS_PER_FILE! %s must be a positive integer", value)
+	}
+
+	return limit, nil
+}
+
+// limitedReader fails with ErrSizeLimit once more than limit bytes have been read
+type limitedReader struct {
+	reader io.Reader
+	limit  int64
+	read   int64
+	name   string
+}
+
+// newLimitedReader wraps a body so reading past limit bytes returns an error instead of silently truncating
+// Inputs:
+//     r: body to read
+//     limit: maximum number of bytes allowed
+//     name: description of the body used in the error
+// Output:
+//     reader over at most limit bytes of r
+func newLimitedReader(r io.Reader, limit int64, name string) io.Reader {
+	// One byte past the limit is read so an exactly full body is not mistaken for an oversized one
+	return &limitedReader{reader: io.LimitReader(r, limit+1), limit: limit, name: name}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	l.read += int64(n)
+
+	if l.read > l.limit {
+		return 0, fmt.Errorf("%s exceeds %d bytes! %w", l.name, l.limit, ErrSizeLimit)
+	}
+
+	return n, err
+}
+
+// readLimited reads a whole body, failing with ErrSizeLimit when it is larger than limit bytes
+// Inputs:
+//     r: body to read
+//     limit: maximum number of bytes allowed
+//     name: description of the body used in the error
+// Output:
+//     If success returns the body and nil, otherwise an error
+func readLimited(r io.Reader, limit int64, name string) ([]byte, error) {
+	return io.ReadAll(newLimitedReader(r, limit, name))
+}
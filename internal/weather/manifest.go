@@ -0,0 +1,86 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestName is the directory holding the run metadata written by each invocation
+const manifestName = "run_metadata"
+
+// RunMetadata defines the interface for the json run manifest written after each invocation
+type RunMetadata struct {
+	InputKey  string `json:"inputKey"`
+	Timestamp string `json:"timestamp"`
+	// CityCount is the number of reported cities, after FILTER_COUNTRY and MIN_TEMP/MAX_TEMP
+	CityCount int    `json:"cityCount"`
+	Units     string `json:"units"`
+	// WindUnit is the WIND_UNIT of the reported and summarized wind speeds
+	WindUnit   string   `json:"windUnit"`
+	OutputKeys []string `json:"outputKeys"`
+	// Stats covers every city fetched, before FILTER_COUNTRY and MIN_TEMP/MAX_TEMP drop any
+	Stats WeatherStats `json:"stats"`
+}
+
+// manifestKey names the run metadata of this invocation so runs never overwrite each other's,
+//     keyed by the request id or, when there is none, the input file's name
+// Inputs:
+//     partition: date partition from Processor.partition, empty when unpartitioned
+// Output:
+//     The object key, e.g. prod/reports/run_metadata/<request id>.json, or with a partition
+//     prod/reports/run_metadata/dt=2024-06-01/<request id>.json
+func (p *Processor) manifestKey(partition string) string {
+	run := p.requestID
+	if run == "" {
+		run = path.Base(filepath.ToSlash(p.uploadKey))
+		run = strings.TrimSuffix(run, path.Ext(run))
+	}
+
+	key := p.config.OutputPrefix + manifestName + "/"
+	if partition != "" {
+		key += "dt=" + partition + "/"
+	}
+
+	return key + run + ".json"
+}
+
+// writeManifest records the run metadata alongside the data files in the s3 output bucket
+// Inputs:
+//     ctx: context of the invocation
+//     key: object key from manifestKey
+//     weatherList: list of Weather structs included in the reports
+//     stats: WeatherStats of every city fetched, from summarizeWeather
+//     keys: output keys written during this invocation
+// Output:
+//     If success returns nil, otherwise an error matching ErrOutputWrite
+func (p *Processor) writeManifest(ctx context.Context, key string, weatherList []Weather, stats WeatherStats, keys []string) error {
+	metadata := RunMetadata{
+		InputKey:   p.uploadKey,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		CityCount:  len(weatherList),
+		Units:      weatherUnits,
+		WindUnit:   p.config.WindUnit,
+		OutputKeys: keys,
+		Stats:      stats,
+	}
+
+	p.logger.Printf("temperature mean %.2f median %.2f, wind speed mean %.2f median %.2f",
+		metadata.Stats.MeanTemperature, metadata.Stats.MedianTemperature, metadata.Stats.MeanWindSpeed, metadata.Stats.MedianWindSpeed)
+
+	body, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return withKind(ErrOutputWrite, fmt.Errorf("failed to marshal run metadata! %w", err))
+	}
+
+	err = p.uploadOutput(ctx, p.outputBucket, key, body, outputFormats["json"])
+	if err != nil {
+		return withKind(ErrOutputWrite, fmt.Errorf("error uploading %s! %w", key, err))
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestManifestKey(t *testing.T) {
+	tests := []struct {
+		prefix    string
+		requestID string
+		uploadKey string
+		partition string
+		want      string
+	}{
+		{"", "c0ffee", "cities.csv", "", "run_metadata/c0ffee.json"},
+		{"prod/reports", "c0ffee", "cities.csv", "2021-09-01", "prod/reports/run_metadata/dt=2021-09-01/c0ffee.json"},
+		{"", "", "uploads/europe.csv", "", "run_metadata/europe.json"},
+		{"", "", "/tmp/input/cities.json", "2021-09-01", "run_metadata/dt=2021-09-01/cities.json"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("OUTPUT_PREFIX", tt.prefix)
+
+		p := &Processor{requestID: tt.requestID, uploadKey: tt.uploadKey, config: testConfig(t)}
+		if got := p.manifestKey(tt.partition); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestProcessManifestPerRun(t *testing.T) {
+	t.Setenv("PARTITION_OUTPUT", "date")
+
+	client := newFakeS3()
+
+	// Two invocations on the same day each keep their own run metadata
+	for _, requestID := range []string{"first", "second"} {
+		client.objects["input/cities.csv"] = []byte("London\nParis\n")
+
+		p := &Processor{s3Client: client, weatherProvider: &countingProvider{calls: map[string]int{}}, inputBucket: "input",
+			outputBucket: "output", requestID: requestID, eventTime: time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC),
+			logger: log.New(io.Discard, "", 0)}
+
+		p.config = testConfig(t)
+		if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, requestID := range []string{"first", "second"} {
+		body, ok := client.objects["output/run_metadata/dt=2021-09-01/"+requestID+".json"]
+		if !ok {
+			t.Fatalf("got no run metadata for %s", requestID)
+		}
+
+		metadata := RunMetadata{}
+		if err := json.Unmarshal(body, &metadata); err != nil {
+			t.Fatal(err)
+		}
+
+		if metadata.InputKey != "cities.csv" || metadata.CityCount != 2 {
+			t.Errorf("%s: got %+v", requestID, metadata)
+		}
+	}
+}
+
+func TestProcessManifestStatsBeforeFilters(t *testing.T) {
+	t.Setenv("MIN_TEMP", "5")
+	t.Setenv("WIND_UNIT", "mph")
+
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("Lima\nBerlin\n")
+
+	// countingProvider reports the length of the city name as its temperature, so MIN_TEMP drops Lima
+	p := &Processor{s3Client: client, weatherProvider: &countingProvider{calls: map[string]int{}}, inputBucket: "input",
+		outputBucket: "output", requestID: "c0ffee", logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := RunMetadata{}
+	if err := json.Unmarshal(client.objects["output/run_metadata/c0ffee.json"], &metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.CityCount != 1 || metadata.Stats.MeanTemperature != 5 || metadata.WindUnit != "mph" {
+		t.Errorf("got %+v, want Berlin reported, stats over Lima and Berlin and the mph wind unit", metadata)
+	}
+}
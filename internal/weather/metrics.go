@@ -0,0 +1,141 @@
+package weather
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// MetricsRecorder defines the interface for recording run metrics, implemented once per metrics backend
+type MetricsRecorder interface {
+	CityProcessed()
+	CityFailed()
+	ObserveAPILatency(latency time.Duration)
+	Flush() error
+}
+
+// multiRecorder fans each metric out to every configured backend
+type multiRecorder []MetricsRecorder
+
+func (m multiRecorder) CityProcessed() {
+	for _, recorder := range m {
+		recorder.CityProcessed()
+	}
+}
+
+func (m multiRecorder) CityFailed() {
+	for _, recorder := range m {
+		recorder.CityFailed()
+	}
+}
+
+func (m multiRecorder) ObserveAPILatency(latency time.Duration) {
+	for _, recorder := range m {
+		recorder.ObserveAPILatency(latency)
+	}
+}
+
+func (m multiRecorder) Flush() error {
+	for _, recorder := range m {
+		if err := recorder.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prometheusRecorder pushes run metrics to a Prometheus Pushgateway
+type prometheusRecorder struct {
+	pusher          *push.Pusher
+	citiesProcessed prometheus.Counter
+	citiesFailed    prometheus.Counter
+	apiLatency      prometheus.Histogram
+}
+
+// newPrometheusRecorder builds a recorder pushing to the given Pushgateway under the job label
+// Inputs:
+//     gatewayURL: url of the Pushgateway
+//     job: job label the metrics are grouped under
+// Output:
+//     The prometheus recorder
+func newPrometheusRecorder(gatewayURL string, job string) *prometheusRecorder {
+	recorder := &prometheusRecorder{
+		citiesProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "weather_cities_processed_total",
+			Help: "Number of cities fetched successfully.",
+		}),
+		citiesFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "weather_cities_failed_total",
+			Help: "Number of cities that failed to fetch.",
+		}),
+		apiLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "weather_api_latency_seconds",
+			Help:    "Latency of weather api calls.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+
+	recorder.pusher = push.New(gatewayURL, job).
+		Collector(recorder.citiesProcessed).
+		Collector(recorder.citiesFailed).
+		Collector(recorder.apiLatency)
+
+	return recorder
+}
+
+func (p *prometheusRecorder) CityProcessed() {
+	p.citiesProcessed.Inc()
+}
+
+func (p *prometheusRecorder) CityFailed() {
+	p.citiesFailed.Inc()
+}
+
+func (p *prometheusRecorder) ObserveAPILatency(latency time.Duration) {
+	p.apiLatency.Observe(latency.Seconds())
+}
+
+func (p *prometheusRecorder) Flush() error {
+	return p.pusher.Push()
+}
+
+// newMetricsRecorder builds a recorder for every configured metrics backend,
+//     inert when none are configured
+// Inputs:
+//     config: Config of the run providing PROM_PUSHGATEWAY_URL and PROM_JOB
+// Output:
+//     The metrics recorder for the invocation
+func newMetricsRecorder(config Config) MetricsRecorder {
+	recorders := multiRecorder{}
+
+	if config.PushgatewayURL != "" {
+		recorders = append(recorders, newPrometheusRecorder(config.PushgatewayURL, config.PromJob))
+	}
+
+	return recorders
+}
+
+// selectPromJob reads the PROM_JOB env var naming the job metrics are pushed under, defaulting to go_weather_lambda
+func selectPromJob() string {
+	job := strings.TrimSpace(os.Getenv("PROM_JOB"))
+	if job == "" {
+		return "go_weather_lambda"
+	}
+
+	return job
+}
+
+// flushMetrics pushes recorded metrics, logging rather than failing the run on errors
+func (p *Processor) flushMetrics() {
+	if p.metrics == nil {
+		return
+	}
+
+	if err := p.metrics.Flush(); err != nil {
+		p.logger.Printf("failed to push metrics! %s", err)
+	}
+}
@@ -0,0 +1,65 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// SNSPublishAPI defines the interface for the Publish function.
+type SNSPublishAPI interface {
+	Publish(ctx context.Context,
+		params *sns.PublishInput,
+		optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// notifyCompletion publishes a summary of the run to the SNS_TOPIC_ARN topic,
+//     skipped silently when the topic is not configured
+// Inputs:
+//     ctx: context of the invocation
+//     weatherList: list of Weather structs processed in the run
+//     keys: list of output keys written in the run
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) notifyCompletion(ctx context.Context, weatherList []Weather, keys []string) error {
+	topicArn := p.config.TopicArn
+	if topicArn == "" || p.snsClient == nil {
+		return nil
+	}
+
+	topCity := "none"
+	if temperatureList, _ := extractWeatherInfo(weatherList, false, "", false); len(temperatureList) > 0 {
+		topCity = fmt.Sprintf("%s (%.2f)", temperatureList[0].City, temperatureList[0].Temperature)
+	}
+
+	message := fmt.Sprintf("Processed %d cities from %s\nTop city by temperature: %s\nOutput keys: %s",
+		len(weatherList), p.uploadKey, topCity, strings.Join(keys, ", "))
+
+	params := &sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String("Weather processing complete"),
+		Message:  aws.String(message),
+	}
+
+	_, err := Publish(ctx, p.snsClient, params)
+	if err != nil {
+		return fmt.Errorf("error publishing completion notification! %w", err)
+	}
+
+	return nil
+}
+
+// Publish sends a message to an Amazon Simple Notification Service (Amazon SNS) topic
+// Inputs:
+//     c is the context of the method call, which includes the AWS Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a PublishOutput object containing the result of the service call and nil
+//     Otherwise, nil and an error from the call to Publish
+func Publish(c context.Context, api SNSPublishAPI, input *sns.PublishInput) (*sns.PublishOutput, error) {
+	return api.Publish(c, input)
+}
@@ -0,0 +1,69 @@
+package weather
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// fakeSNS records every message published to it
+type fakeSNS struct {
+	published []*sns.PublishInput
+}
+
+func (f *fakeSNS) Publish(ctx context.Context, params *sns.PublishInput,
+	optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.published = append(f.published, params)
+
+	return &sns.PublishOutput{}, nil
+}
+
+func TestNotifyCompletion(t *testing.T) {
+	t.Setenv("SNS_TOPIC_ARN", "arn:aws:sns:us-east-1:123456789012:weather")
+
+	client := &fakeSNS{}
+	p := &Processor{config: testConfig(t), snsClient: client, uploadKey: "cities.txt"}
+
+	weatherList := []Weather{{Name: "Oslo"}, {Name: "Cairo"}}
+	weatherList[0].Main.Temp = 4.5
+	weatherList[1].Main.Temp = 31.25
+
+	keys := []string{"highest_temperatures.csv", "highest_wind.csv"}
+	if err := p.notifyCompletion(context.Background(), weatherList, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.published) != 1 {
+		t.Fatalf("got %d messages published, want 1", len(client.published))
+	}
+
+	params := client.published[0]
+	if aws.ToString(params.TopicArn) != "arn:aws:sns:us-east-1:123456789012:weather" {
+		t.Errorf("got topic %q", aws.ToString(params.TopicArn))
+	}
+
+	message := aws.ToString(params.Message)
+	for _, want := range []string{"Processed 2 cities from cities.txt", "Cairo (31.25)", "highest_temperatures.csv, highest_wind.csv"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message %q does not contain %q", message, want)
+		}
+	}
+}
+
+func TestNotifyCompletionWithoutTopic(t *testing.T) {
+	t.Setenv("SNS_TOPIC_ARN", "")
+
+	client := &fakeSNS{}
+	p := &Processor{config: testConfig(t), snsClient: client}
+
+	if err := p.notifyCompletion(context.Background(), []Weather{{Name: "Oslo"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.published) != 0 {
+		t.Errorf("got %d messages published without a topic, want 0", len(client.published))
+	}
+}
@@ -0,0 +1,45 @@
+package weather
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// marshalParquet serializes a slice of report rows into a Parquet file using the parquet struct tags
+// Inputs:
+//     v: slice of output structs such as []TemperatureOutput
+// Output:
+//     If success returns the Parquet file contents and nil, otherwise an error
+func marshalParquet(v interface{}) ([]byte, error) {
+	rows := reflect.ValueOf(v)
+	if rows.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("parquet output requires a slice, got %T", v)
+	}
+
+	// The schema is read from the row type so every report gets its own columns
+	schema := reflect.New(rows.Type().Elem()).Interface()
+
+	var buffer bytes.Buffer
+	parquetWriter, err := writer.NewParquetWriterFromWriter(&buffer, schema, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	for i := 0; i < rows.Len(); i++ {
+		if err := parquetWriter.Write(rows.Index(i).Interface()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := parquetWriter.WriteStop(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
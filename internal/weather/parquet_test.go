@@ -0,0 +1,60 @@
+package weather
+
+import (
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestMarshalParquetReadBack(t *testing.T) {
+	forecast := 21.5
+	rows := []TemperatureOutput{
+		{Rank: 1, City: "Cairo", Lat: 30.04, Lon: 31.24, Temperature: 31.25, Description: "clear sky", ForecastTemp: &forecast},
+		{Rank: 2, City: "São Paulo", Lat: -23.55, Lon: -46.63, Temperature: 24.5},
+	}
+
+	body, err := marshalParquet(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := buffer.NewBufferFile(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parquetReader, err := reader.NewParquetReader(file, new(TemperatureOutput), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parquetReader.ReadStop()
+
+	if count := parquetReader.GetNumRows(); count != int64(len(rows)) {
+		t.Fatalf("got %d rows, want %d", count, len(rows))
+	}
+
+	got := make([]TemperatureOutput, len(rows))
+	if err := parquetReader.Read(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	first := got[0]
+	if first.Rank != 1 || first.City != "Cairo" || first.Temperature != 31.25 || first.Description != "clear sky" {
+		t.Errorf("got first row %+v, want %+v", first, rows[0])
+	}
+
+	if first.ForecastTemp == nil || *first.ForecastTemp != forecast {
+		t.Errorf("got forecast %v, want %v", first.ForecastTemp, forecast)
+	}
+
+	if got[1].City != "São Paulo" || got[1].ForecastTemp != nil {
+		t.Errorf("got second row %+v, want %+v", got[1], rows[1])
+	}
+}
+
+func TestMarshalParquetRequiresSlice(t *testing.T) {
+	if _, err := marshalParquet(TemperatureOutput{City: "Cairo"}); err == nil {
+		t.Error("marshalParquet accepted a single row")
+	}
+}
@@ -0,0 +1,577 @@
+package weather
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reportSize is the number of ranked cities written to each report
+const reportSize = 3
+
+// MetricReport defines a ranked report produced for a metric named in the METRICS env var
+type MetricReport struct {
+	// Value extracts the field cities are ranked by
+	Value func(city Weather) float64
+	// Ranks reports whether a city has the metric at all, nil when every city does
+	Ranks func(city Weather) bool
+	// Rows converts ranked cities into the report rows to serialize
+	Rows func(ranked []Weather) interface{}
+	// Highest is the base key of the descending report
+	Highest string
+	// Lowest is the base key of the ascending report, empty when the metric has none
+	Lowest string
+	// BucketEnv names the env var overriding OUTPUT_BUCKET for this metric's reports
+	BucketEnv string
+}
+
+// ReportFile defines a single report planned for upload
+type ReportFile struct {
+	// Bucket is the bucket the report is written to, empty for OUTPUT_BUCKET
+	Bucket string
+	Key    string
+	Rows   interface{}
+}
+
+// defaultMetrics are the reports written when METRICS is unset
+var defaultMetrics = []string{"temp", "wind"}
+
+// metricReports builds the table of supported metrics keyed by their METRICS name
+// Inputs:
+//     config: Config of the run providing RANK_MODE, WIND_UNIT and WIND_VECTOR, empty when only the names are needed
+// Output:
+//     map of metric name to its MetricReport
+func metricReports(config Config) map[string]MetricReport {
+	return map[string]MetricReport{
+		"temp": {
+			Value:     temperatureValue,
+			Rows:      temperatureRows,
+			Highest:   "highest_temperatures",
+			Lowest:    "lowest_temperatures",
+			BucketEnv: "TEMPERATURE_OUTPUT_BUCKET",
+		},
+		"wind": {
+			Value:     windValue,
+			Rows:      windRows(config.WindUnit, config.WindVector),
+			Highest:   "highest_wind",
+			BucketEnv: "WIND_OUTPUT_BUCKET",
+		},
+		"humidity": {
+			Value:     func(city Weather) float64 { return float64(city.Main.Humidity) },
+			Rows:      humidityRows,
+			Highest:   "highest_humidity",
+			BucketEnv: "HUMIDITY_OUTPUT_BUCKET",
+		},
+		"pressure": {
+			Value:     func(city Weather) float64 { return float64(city.Main.Pressure) },
+			Rows:      pressureRows,
+			Highest:   "highest_pressure",
+			BucketEnv: "PRESSURE_OUTPUT_BUCKET",
+		},
+		"precipitation": {
+			Value:     precipitationValue(config.RankMode),
+			Rows:      precipitationRows,
+			Highest:   "highest_precipitation",
+			BucketEnv: "PRECIPITATION_OUTPUT_BUCKET",
+		},
+		"aqi": {
+			Value:     airQualityValue,
+			Ranks:     hasAirQuality,
+			Rows:      airQualityRows,
+			Highest:   "worst_air_quality",
+			BucketEnv: "AIR_QUALITY_OUTPUT_BUCKET",
+		},
+	}
+}
+
+// selectMetrics reads the comma separated METRICS env var naming the reports to produce
+// Inputs:
+//     includePrecipitation: add the precipitation report even when not listed
+//     includeAirQuality: add the air quality report even when not listed, it may only be listed when set
+// Output:
+//     If success returns the metric names in order without duplicates and nil, otherwise an error
+func selectMetrics(includePrecipitation bool, includeAirQuality bool) ([]string, error) {
+	names := defaultMetrics
+	if value := strings.TrimSpace(os.Getenv("METRICS")); value != "" {
+		names = strings.Split(value, ",")
+	}
+
+	supported := metricReports(Config{})
+	seen := make(map[string]bool)
+	metricNames := make([]string, 0, len(names)+2)
+
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+
+		if _, ok := supported[name]; !ok {
+			return nil, fmt.Errorf("unsupported metric! %s", name)
+		}
+
+		// Air quality is only fetched alongside the weather when INCLUDE_AQI is set
+		if name == "aqi" && !includeAirQuality {
+			return nil, fmt.Errorf("metric aqi requires INCLUDE_AQI")
+		}
+
+		seen[name] = true
+		metricNames = append(metricNames, name)
+	}
+
+	if includePrecipitation && !seen["precipitation"] {
+		metricNames = append(metricNames, "precipitation")
+	}
+
+	if includeAirQuality && !seen["aqi"] {
+		metricNames = append(metricNames, "aqi")
+	}
+
+	return metricNames, nil
+}
+
+// planReports ranks every group by every requested metric and names the resulting report files
+// Inputs:
+//     groups: map of group name to the Weather structs in that group
+//     reports: table of MetricReport keyed by metric name
+//     config: Config of the run providing the metrics, report directions, sort orders, buckets and key format
+//     partition: date partition of the report keys, empty when unpartitioned
+// Output:
+//     list of ReportFile in a stable order
+func planReports(groups map[string][]Weather, reports map[string]MetricReport, config Config, partition string) []ReportFile {
+	groupNames := make([]string, 0, len(groups))
+	for name := range groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	reportFiles := make([]ReportFile, 0)
+
+	for _, group := range groupNames {
+		for _, metric := range config.Metrics {
+			report := reports[metric]
+
+			if report.Lowest == "" || config.WriteHighest {
+				rows := report.Rows(report.rank(groups[group], false))
+				reportFiles = append(reportFiles, ReportFile{
+					Bucket: config.ReportBuckets[metric],
+					Key:    config.outputKey(report.Highest, group, partition),
+					Rows:   applySortOrder(rows, false, config.SortOrders[metric]),
+				})
+			}
+
+			if report.Lowest != "" && config.WriteLowest {
+				rows := report.Rows(report.rank(groups[group], true))
+				reportFiles = append(reportFiles, ReportFile{
+					Bucket: config.ReportBuckets[metric],
+					Key:    config.outputKey(report.Lowest, group, partition),
+					Rows:   applySortOrder(rows, true, config.SortOrders[metric]),
+				})
+			}
+		}
+	}
+
+	return reportFiles
+}
+
+// paginateReports splits reports holding more than maxRows rows into part files numbered from 1,
+//     highest_temperatures.csv becomes highest_temperatures/part-0001.csv and a partitioned
+//     .../part.csv becomes .../part-0001.csv, smaller reports keep their key
+// Inputs:
+//     reportFiles: list of ReportFile whose Rows are slices
+//     maxRows: most rows written to one file, zero for no limit
+//     format: OutputFormat providing the file extension
+// Output:
+//     list of ReportFile in the same order with every part in sequence
+func paginateReports(reportFiles []ReportFile, maxRows int, format OutputFormat) []ReportFile {
+	if maxRows <= 0 {
+		return reportFiles
+	}
+
+	paged := make([]ReportFile, 0, len(reportFiles))
+
+	for _, report := range reportFiles {
+		rows := reflect.ValueOf(report.Rows)
+		if rows.Kind() != reflect.Slice || rows.Len() <= maxRows {
+			paged = append(paged, report)
+			continue
+		}
+
+		base := strings.TrimSuffix(report.Key, "."+format.Extension)
+		if strings.HasSuffix(base, "/part") {
+			base = strings.TrimSuffix(base, "part")
+		} else {
+			base += "/"
+		}
+
+		for start, part := 0, 1; start < rows.Len(); start, part = start+maxRows, part+1 {
+			end := start + maxRows
+			if end > rows.Len() {
+				end = rows.Len()
+			}
+
+			paged = append(paged, ReportFile{
+				Bucket: report.Bucket,
+				Key:    fmt.Sprintf("%spart-%04d.%s", base, part, format.Extension),
+				Rows:   rows.Slice(start, end).Interface(),
+			})
+		}
+	}
+
+	return paged
+}
+
+// isOutputKey reports whether a key has the shape of a file this function writes, such as
+//     highest_temperatures.csv, highest_wind.GB.json, summary/part-0001.csv or summary/dt=2021-09-01/part.csv
+// Inputs:
+//     key: object key to check
+//     prefix: OUTPUT_PREFIX every output key starts with
+// Output:
+//     true if the key is a report, summary, dump, history, manifest or temporary upload key
+func isOutputKey(key string, prefix string) bool {
+	// S3Sink stages uploads under _tmp/ ahead of the prefix, e.g. _tmp/<prefix>/<key>
+	if strings.HasPrefix(key, "_tmp/") {
+		return true
+	}
+
+	if !strings.HasPrefix(key, prefix) {
+		return false
+	}
+
+	name := strings.TrimPrefix(key, prefix)
+	if name == historyName || strings.HasPrefix(name, manifestName+"/") {
+		return true
+	}
+
+	bases := []string{summaryName, allWeatherName}
+	for _, report := range metricReports(Config{}) {
+		bases = append(bases, report.Highest)
+		if report.Lowest != "" {
+			bases = append(bases, report.Lowest)
+		}
+	}
+
+	for _, base := range bases {
+		if strings.HasPrefix(name, base+".") || strings.HasPrefix(name, base+"/dt=") || strings.HasPrefix(name, base+"/part-") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writesToBucket reports whether any report of this function may be written to the bucket
+func (p *Processor) writesToBucket(bucket string) bool {
+	if bucket == "" {
+		return false
+	}
+
+	if bucket == p.outputBucket || bucket == p.config.BackupBucket {
+		return true
+	}
+
+	for _, reportBucket := range p.config.ReportBuckets {
+		if bucket == reportBucket {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectReportBuckets reads the <METRIC>_OUTPUT_BUCKET env vars named by each MetricReport's BucketEnv
+// Output:
+//     map of metric name to the bucket its reports are written to, metrics without an override are left out
+func selectReportBuckets() map[string]string {
+	buckets := make(map[string]string)
+
+	for metric, report := range metricReports(Config{}) {
+		if bucket := strings.TrimSpace(os.Getenv(report.BucketEnv)); bucket != "" {
+			buckets[metric] = bucket
+		}
+	}
+
+	return buckets
+}
+
+// selectSortOrders reads the SORT_ORDER env var, overridden per metric by SORT_ORDER_<METRIC> such as SORT_ORDER_WIND
+// Output:
+//     If success returns a map of metric name, or "city" for a summary sorted by SORT_BY=city, to "asc", "desc"
+//     or empty to keep the ranking order, and nil, otherwise an error
+func selectSortOrders() (map[string]string, error) {
+	sortOrders := make(map[string]string)
+
+	columns := []string{"city"}
+	for metric := range metricReports(Config{}) {
+		columns = append(columns, metric)
+	}
+
+	for _, metric := range columns {
+		for _, name := range []string{"SORT_ORDER_" + strings.ToUpper(metric), "SORT_ORDER"} {
+			order := strings.ToLower(strings.TrimSpace(os.Getenv(name)))
+			if order == "" {
+				continue
+			}
+
+			if order != "asc" && order != "desc" {
+				return nil, fmt.Errorf("unsupported sort order! %s=%s", name, order)
+			}
+
+			sortOrders[metric] = order
+			break
+		}
+	}
+
+	return sortOrders, nil
+}
+
+// applySortOrder puts ranked report rows into the requested value order, rows keep their Rank
+// Inputs:
+//     rows: slice of report rows in ranking order
+//     ascending: whether the ranking put the lowest values first
+//     order: "asc" or "desc", empty to keep the ranking order
+// Output:
+//     rows, reversed in place when the ranking runs the other way
+func applySortOrder(rows interface{}, ascending bool, order string) interface{} {
+	if order == "" || (order == "asc") == ascending {
+		return rows
+	}
+
+	value := reflect.ValueOf(rows)
+	swap := reflect.Swapper(rows)
+	for i, j := 0, value.Len()-1; i < j; i, j = i+1, j-1 {
+		swap(i, j)
+	}
+
+	return rows
+}
+
+// defaultFloatPrecision is how many decimals report values are rounded to when FLOAT_PRECISION is unset
+const defaultFloatPrecision = 2
+
+// selectFloatPrecision reads the FLOAT_PRECISION env var giving how many decimals report values are rounded to
+// Output:
+//     If success returns the precision, defaulting to defaultFloatPrecision, and nil, otherwise an error
+func selectFloatPrecision() (int, error) {
+	value := strings.TrimSpace(os.Getenv("FLOAT_PRECISION"))
+	if value == "" {
+		return defaultFloatPrecision, nil
+	}
+
+	precision, err := strconv.Atoi(value)
+	if err != nil || precision < 0 {
+		return 0, fmt.Errorf("invalid FLOAT_PRECISION! %s must be a non-negative integer", value)
+	}
+
+	return precision, nil
+}
+
+// roundRows rounds the float fields of report rows in place so reports keep a fixed number of decimals,
+//     e.g. 18.1234 becomes 18.12
+// Inputs:
+//     rows: slice of report row structs
+//     precision: number of decimals to keep
+func roundRows(rows interface{}, precision int) {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice {
+		return
+	}
+
+	scale := math.Pow(10, float64(precision))
+
+	for i := 0; i < value.Len(); i++ {
+		row := value.Index(i)
+		if row.Kind() != reflect.Struct {
+			continue
+		}
+
+		for j := 0; j < row.NumField(); j++ {
+			field := row.Field(j)
+
+			if field.Kind() == reflect.Ptr && !field.IsNil() {
+				field = field.Elem()
+			}
+
+			if field.Kind() == reflect.Float64 && field.CanSet() {
+				field.SetFloat(math.Round(field.Float()*scale) / scale)
+			}
+		}
+	}
+}
+
+// rankWeather orders cities by a metric and keeps the top of the ranking
+// Inputs:
+//     weatherList: list of Weather structs to rank
+//     value: function extracting the metric to rank by
+//     ascending: sort lowest values first instead of highest
+// Output:
+//     list of up to reportSize Weather structs, ties broken alphabetically by city name
+func rankWeather(weatherList []Weather, value func(city Weather) float64, ascending bool) []Weather {
+	ranked := make([]Weather, len(weatherList))
+	copy(ranked, weatherList)
+
+	// Ties are broken alphabetically so repeated runs produce identical reports
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := value(ranked[i]), value(ranked[j])
+		if a != b {
+			if ascending {
+				return a < b
+			}
+			return a > b
+		}
+		return cityName(ranked[i]) < cityName(ranked[j])
+	})
+
+	if len(ranked) > reportSize {
+		ranked = ranked[:reportSize]
+	}
+
+	return ranked
+}
+
+// rank orders the cities having the report's metric and keeps the top of the ranking
+// Inputs:
+//     weatherList: list of Weather structs to rank
+//     ascending: sort lowest values first instead of highest
+// Output:
+//     list of up to reportSize Weather structs, cities the metric does not rank left out
+func (report MetricReport) rank(weatherList []Weather, ascending bool) []Weather {
+	if report.Ranks == nil {
+		return rankWeather(weatherList, report.Value, ascending)
+	}
+
+	ranked := make([]Weather, 0, len(weatherList))
+	for _, city := range weatherList {
+		if report.Ranks(city) {
+			ranked = append(ranked, city)
+		}
+	}
+
+	return rankWeather(ranked, report.Value, ascending)
+}
+
+// temperatureValue ranks cities by temperature
+func temperatureValue(city Weather) float64 {
+	return city.Main.Temp
+}
+
+// windValue ranks cities by wind speed
+func windValue(city Weather) float64 {
+	return city.Wind.Speed
+}
+
+// precipitationValue ranks cities by precipitation volume
+// Inputs:
+//     rankMode: "rain" or "snow" to rank by a single volume, otherwise rain and snow combined
+// Output:
+//     function extracting the volume to rank by, dry cities count as zero
+func precipitationValue(rankMode string) func(city Weather) float64 {
+	return func(city Weather) float64 {
+		total := 0.0
+		if city.Rain != nil && rankMode != "snow" {
+			total += city.Rain.OneHour
+		}
+		if city.Snow != nil && rankMode != "rain" {
+			total += city.Snow.OneHour
+		}
+		return total
+	}
+}
+
+// temperatureRows converts ranked cities into temperature report rows numbered from 1 in ranked order
+func temperatureRows(ranked []Weather) interface{} {
+	rows := make([]TemperatureOutput, len(ranked))
+
+	for i, city := range ranked {
+		rows[i] = TemperatureOutput{
+			Rank:        i + 1,
+			City:        cityName(city),
+			Lat:         city.Coord.Lat,
+			Lon:         city.Coord.Lon,
+			Temperature: city.Main.Temp,
+			FeelsLike:   city.Main.FeelsLike,
+			TempMin:     city.Main.TempMin,
+			TempMax:     city.Main.TempMax,
+			Description: primaryDescription(city),
+		}
+
+		if city.ForecastTemp != nil {
+			forecast := *city.ForecastTemp
+			rows[i].ForecastTemp = &forecast
+		}
+	}
+
+	return rows
+}
+
+// windRows converts ranked cities into wind report rows numbered from 1 in ranked order,
+//     with u/v vector components when WIND_VECTOR is set
+// Inputs:
+//     windUnit: unit the wind speeds are converted into from metres per second
+//     vector: WIND_VECTOR, adding the u/v components
+// Output:
+//     function converting ranked cities into wind report rows
+func windRows(windUnit string, vector bool) func(ranked []Weather) interface{} {
+	return func(ranked []Weather) interface{} {
+		rows := make([]WindOutput, len(ranked))
+
+		for i, city := range ranked {
+			rows[i] = WindOutput{
+				Rank:      i + 1,
+				City:      cityName(city),
+				Lat:       city.Coord.Lat,
+				Lon:       city.Coord.Lon,
+				WindSpeed: convertWindSpeed(city.Wind.Speed, windUnit),
+				Direction: degreesToCompass(city.Wind.Degrees),
+			}
+
+			if vector {
+				u, v := windVector(rows[i].WindSpeed, city.Wind.Degrees)
+				rows[i].WindU, rows[i].WindV = &u, &v
+			}
+		}
+
+		return rows
+	}
+}
+
+// humidityRows converts ranked cities into humidity report rows
+func humidityRows(ranked []Weather) interface{} {
+	rows := make([]HumidityOutput, len(ranked))
+
+	for i, city := range ranked {
+		rows[i] = HumidityOutput{City: cityName(city), Humidity: int32(city.Main.Humidity)}
+	}
+
+	return rows
+}
+
+// pressureRows converts ranked cities into pressure report rows
+func pressureRows(ranked []Weather) interface{} {
+	rows := make([]PressureOutput, len(ranked))
+
+	for i, city := range ranked {
+		rows[i] = PressureOutput{City: cityName(city), Pressure: int32(city.Main.Pressure)}
+	}
+
+	return rows
+}
+
+// precipitationRows converts ranked cities into precipitation report rows
+func precipitationRows(ranked []Weather) interface{} {
+	rows := make([]PrecipitationOutput, len(ranked))
+
+	for i, city := range ranked {
+		rows[i] = PrecipitationOutput{
+			City: cityName(city),
+			Rain: precipitationVolume(city.Rain),
+			Snow: precipitationVolume(city.Snow),
+		}
+	}
+
+	return rows
+}
@@ -0,0 +1,554 @@
+package weather
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// update rewrites the golden files from the current output, run with go test -update
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// goldenWeather is a fixed set of cities with values that exercise rounding, ties and empty descriptions
+func goldenWeather() []Weather {
+	cities := []struct {
+		name        string
+		lat, lon    float64
+		temp        float64
+		feelsLike   float64
+		speed       float64
+		degrees     int
+		description string
+	}{
+		{"Lisbon", 38.7167, -9.1333, 18.1234, 17.9951, 4.126, 348, "clear sky"},
+		{"Cairo", 30.0626, 31.2497, 31.005, 30.4449, 2.574, 22, "few clouds"},
+		{"Oslo", 59.9127, 10.7461, -3.456, -8.1, 7.2, 200, ""},
+		{"Bergen", 60.393, 5.3242, 31.005, 29.99, 7.2, 90, "light rain"},
+	}
+
+	weatherList := make([]Weather, len(cities))
+	for i, city := range cities {
+		weatherList[i].Name = city.name
+		weatherList[i].Coord.Lat = city.lat
+		weatherList[i].Coord.Lon = city.lon
+		weatherList[i].Main.Temp = city.temp
+		weatherList[i].Main.FeelsLike = city.feelsLike
+		weatherList[i].Main.TempMin = city.temp - 1.25
+		weatherList[i].Main.TempMax = city.temp + 1.25
+		weatherList[i].Wind.Speed = city.speed
+		weatherList[i].Wind.Degrees = city.degrees
+
+		if city.description != "" {
+			weatherList[i].Weather = append(weatherList[i].Weather, struct {
+				Description string `json:"description"`
+			}{city.description})
+		}
+	}
+
+	return weatherList
+}
+
+// assertGolden compares output with testdata/name, rewriting the file instead when -update is set
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestGoldenOutput(t *testing.T) {
+	t.Setenv("USE_INPUT_NAME", "")
+	t.Setenv("WIND_VECTOR", "")
+
+	tests := []struct {
+		golden string
+		value  func(city Weather) float64
+		rows   func(ranked []Weather) interface{}
+	}{
+		{"highest_temperatures.csv.golden", temperatureValue, temperatureRows},
+		{"highest_wind.csv.golden", windValue, windRows("m/s", false)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			rows := tt.rows(rankWeather(goldenWeather(), tt.value, false))
+			roundRows(rows, defaultFloatPrecision)
+
+			got, err := outputFormats["csv"].Marshal(rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertGolden(t, tt.golden, got)
+		})
+	}
+}
+
+func TestIsOutputKey(t *testing.T) {
+	tests := []struct {
+		prefix string
+		key    string
+		want   bool
+	}{
+		{"", "highest_temperatures.csv", true},
+		{"", "lowest_temperatures.json", true},
+		{"", "highest_wind.GB.json", true},
+		{"", "summary/part-0001.csv", true},
+		{"", "all_weather/dt=2021-09-01/part.csv", true},
+		{"", "history.csv", true},
+		{"", "run_metadata/cities.json", true},
+		{"", "run_metadata/dt=2021-09-01/c0ffee.json", true},
+		{"", "run_metadata.json", false},
+		{"", "_tmp/highest_wind.csv.1630454400000000000", true},
+		{"", "cities.csv", false},
+		{"", "highest_temperatures_backup.csv", false},
+		{"prod/reports", "prod/reports/highest_wind.csv", true},
+		{"prod/reports", "_tmp/prod/reports/highest_wind.csv.1630454400000000000", true},
+		{"prod/reports", "highest_wind.csv", false},
+		{"prod/reports", "prod/reports/cities.csv", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix+"|"+tt.key, func(t *testing.T) {
+			t.Setenv("OUTPUT_PREFIX", tt.prefix)
+
+			if got := isOutputKey(tt.key, testConfig(t).OutputPrefix); got != tt.want {
+				t.Errorf("isOutputKey(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrecipitationValue(t *testing.T) {
+	rainy := Weather{Name: "Bergen", Rain: &Precipitation{OneHour: 2.5}}
+	snowy := Weather{Name: "Tromso", Snow: &Precipitation{OneHour: 1.25}}
+	sleety := Weather{Name: "Oslo", Rain: &Precipitation{OneHour: 0.5}, Snow: &Precipitation{OneHour: 0.75}}
+	dry := Weather{Name: "Cairo"}
+
+	tests := []struct {
+		rankMode string
+		want     map[string]float64
+	}{
+		{"", map[string]float64{"Bergen": 2.5, "Tromso": 1.25, "Oslo": 1.25, "Cairo": 0}},
+		{"rain", map[string]float64{"Bergen": 2.5, "Tromso": 0, "Oslo": 0.5, "Cairo": 0}},
+		{"snow", map[string]float64{"Bergen": 0, "Tromso": 1.25, "Oslo": 0.75, "Cairo": 0}},
+	}
+
+	for _, tt := range tests {
+		value := precipitationValue(tt.rankMode)
+		for _, city := range []Weather{rainy, snowy, sleety, dry} {
+			if got := value(city); got != tt.want[city.Name] {
+				t.Errorf("precipitationValue(%q)(%s) = %v, want %v", tt.rankMode, city.Name, got, tt.want[city.Name])
+			}
+		}
+	}
+}
+
+func TestPrecipitationRows(t *testing.T) {
+	ranked := []Weather{
+		{Name: "Bergen", Rain: &Precipitation{OneHour: 2.5}},
+		{Name: "Tromso", Snow: &Precipitation{OneHour: 1.25}},
+		{Name: "Cairo"},
+	}
+
+	rows := precipitationRows(ranked).([]PrecipitationOutput)
+
+	if rows[0].Rain == nil || *rows[0].Rain != 2.5 || rows[0].Snow != nil {
+		t.Errorf("rain only row = %+v", rows[0])
+	}
+	if rows[1].Snow == nil || *rows[1].Snow != 1.25 || rows[1].Rain != nil {
+		t.Errorf("snow only row = %+v", rows[1])
+	}
+	if rows[2].Rain != nil || rows[2].Snow != nil {
+		t.Errorf("dry row = %+v, want both volumes empty", rows[2])
+	}
+}
+
+func TestSelectRankMode(t *testing.T) {
+	for value, want := range map[string]string{"": "", "rain": "rain", " Snow ": "snow"} {
+		t.Setenv("RANK_MODE", value)
+		if got, err := selectRankMode(); err != nil || got != want {
+			t.Errorf("selectRankMode(%q) = %q, %v, want %q", value, got, err, want)
+		}
+	}
+
+	t.Setenv("RANK_MODE", "hail")
+	if _, err := selectRankMode(); err == nil {
+		t.Error("selectRankMode(hail) returned no error")
+	}
+}
+
+func TestOutputKeyPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", "highest_temperatures.csv"},
+		{"prod/reports", "prod/reports/highest_temperatures.csv"},
+		{"prod/reports/", "prod/reports/highest_temperatures.csv"},
+		{" /prod/reports// ", "prod/reports/highest_temperatures.csv"},
+		{"/", "highest_temperatures.csv"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("OUTPUT_PREFIX", tt.prefix)
+
+		if got := testConfig(t).outputKey("highest_temperatures", "", ""); got != tt.want {
+			t.Errorf("OUTPUT_PREFIX %q: got %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestProcessWritesUnderPrefix(t *testing.T) {
+	for _, prefix := range []string{"prod/reports", "prod/reports/"} {
+		t.Setenv("OUTPUT_PREFIX", prefix)
+
+		client := newFakeS3()
+		client.objects["input/cities.csv"] = []byte("London\nParis\n")
+
+		p := &Processor{s3Client: client, weatherProvider: &countingProvider{calls: map[string]int{}},
+			inputBucket: "input", outputBucket: "output", logger: log.New(io.Discard, "", 0)}
+
+		p.config = testConfig(t)
+		if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range []string{"output/prod/reports/highest_temperatures.csv", "output/prod/reports/highest_wind.csv"} {
+			if _, ok := client.objects[key]; !ok {
+				t.Errorf("OUTPUT_PREFIX %q: missing %s in %v", prefix, key, client.calls)
+			}
+		}
+	}
+}
+
+func TestRankWeatherBreaksTiesByName(t *testing.T) {
+	tied := func(names ...string) []Weather {
+		weatherList := make([]Weather, len(names))
+		for i, name := range names {
+			weatherList[i] = Weather{Name: name}
+			weatherList[i].Main.Temp = 20
+			weatherList[i].Wind.Speed = 5
+		}
+		weatherList[0].Main.Temp = 25
+		return weatherList
+	}
+
+	orders := [][]Weather{
+		tied("Cairo", "Oslo", "Bergen", "Lisbon", "Athens"),
+		tied("Cairo", "Athens", "Lisbon", "Bergen", "Oslo"),
+	}
+
+	tests := []struct {
+		ascending   bool
+		temperature []string
+		wind        []string
+	}{
+		{false, []string{"Cairo", "Athens", "Bergen"}, []string{"Athens", "Bergen", "Cairo"}},
+		{true, []string{"Athens", "Bergen", "Lisbon"}, []string{"Athens", "Bergen", "Cairo"}},
+	}
+
+	for _, tt := range tests {
+		for _, weatherList := range orders {
+			temperatureList, windList := extractWeatherInfo(weatherList, tt.ascending, "", false)
+
+			var temperature, wind []string
+			for i := range temperatureList {
+				temperature = append(temperature, temperatureList[i].City)
+				wind = append(wind, windList[i].City)
+			}
+
+			if !reflect.DeepEqual(temperature, tt.temperature) || !reflect.DeepEqual(wind, tt.wind) {
+				t.Errorf("ascending %v: got temperatures %v and winds %v, want %v and %v",
+					tt.ascending, temperature, wind, tt.temperature, tt.wind)
+			}
+		}
+	}
+}
+
+func TestSelectPartition(t *testing.T) {
+	eventTime := time.Date(2024, 6, 1, 23, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+	p := &Processor{eventTime: eventTime}
+
+	t.Setenv("PARTITION_OUTPUT", "")
+	p.config = testConfig(t)
+	if got := p.partition(); got != "" {
+		t.Errorf("unset: got %q, want no partition", got)
+	}
+
+	t.Setenv("PARTITION_OUTPUT", " Date ")
+	p.config = testConfig(t)
+	if got := p.partition(); got != "2024-06-02" {
+		t.Errorf("date: got %q, want the UTC date 2024-06-02", got)
+	}
+
+	p.eventTime = time.Time{}
+	if got := p.partition(); got != time.Now().UTC().Format("2006-01-02") {
+		t.Errorf("no event time: got %q, want today's UTC date", got)
+	}
+
+	t.Setenv("PARTITION_OUTPUT", "hour")
+	if _, err := loadConfig(); err == nil {
+		t.Error("hour: got no error")
+	}
+}
+
+func TestOutputKeyPartitionLayout(t *testing.T) {
+	tests := []struct {
+		prefix string
+		group  string
+		format string
+		want   string
+	}{
+		{"", "", "csv", "highest_temperatures/dt=2024-06-01/part.csv"},
+		{"", "GB", "csv", "highest_temperatures/dt=2024-06-01/country=GB/part.csv"},
+		{"prod/reports/", "", "parquet", "prod/reports/highest_temperatures/dt=2024-06-01/part.parquet"},
+		{"prod/reports", "GB", "json", "prod/reports/highest_temperatures/dt=2024-06-01/country=GB/part.json"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("OUTPUT_PREFIX", tt.prefix)
+		t.Setenv("OUTPUT_FORMAT", tt.format)
+
+		if got := testConfig(t).outputKey("highest_temperatures", tt.group, "2024-06-01"); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestPlanReportsSortOrders(t *testing.T) {
+	t.Setenv("OUTPUT_PREFIX", "")
+	t.Setenv("WIND_VECTOR", "")
+
+	groups := map[string][]Weather{"": goldenWeather()}
+
+	tests := []struct {
+		order string
+		want  map[string][]string
+	}{
+		{"", map[string][]string{
+			"highest_temperatures.csv": {"Bergen", "Cairo", "Lisbon"},
+			"lowest_temperatures.csv":  {"Oslo", "Lisbon", "Bergen"},
+		}},
+		{"desc", map[string][]string{
+			"highest_temperatures.csv": {"Bergen", "Cairo", "Lisbon"},
+			"lowest_temperatures.csv":  {"Bergen", "Lisbon", "Oslo"},
+		}},
+		{"asc", map[string][]string{
+			"highest_temperatures.csv": {"Lisbon", "Cairo", "Bergen"},
+			"lowest_temperatures.csv":  {"Oslo", "Lisbon", "Bergen"},
+		}},
+	}
+
+	for _, tt := range tests {
+		sortOrders := map[string]string{"temp": tt.order}
+		config := Config{Metrics: []string{"temp"}, WriteHighest: true, WriteLowest: true, SortOrders: sortOrders, Format: outputFormats["csv"]}
+		reportFiles := planReports(groups, metricReports(Config{WindUnit: "m/s"}), config, "")
+
+		got := make(map[string][]string)
+		for _, report := range reportFiles {
+			rows := report.Rows.([]TemperatureOutput)
+			for i, row := range rows {
+				got[report.Key] = append(got[report.Key], row.City)
+
+				if i > 0 && ((tt.order == "asc" && row.Temperature < rows[i-1].Temperature) ||
+					(tt.order == "desc" && row.Temperature > rows[i-1].Temperature)) {
+					t.Errorf("order %q: %s row %d is out of order", tt.order, report.Key, i)
+				}
+			}
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("order %q: got %v, want %v", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestSelectSortOrders(t *testing.T) {
+	t.Setenv("SORT_ORDER", " ASC ")
+	t.Setenv("SORT_ORDER_WIND", "desc")
+
+	sortOrders, err := selectSortOrders()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sortOrders["temp"] != "asc" || sortOrders["wind"] != "desc" {
+		t.Errorf("got %v, want temp asc with the wind override desc", sortOrders)
+	}
+
+	t.Setenv("SORT_ORDER_WIND", "up")
+	if _, err := selectSortOrders(); err == nil {
+		t.Error("got no error for SORT_ORDER_WIND=up")
+	}
+}
+
+func TestRoundRows(t *testing.T) {
+	// A float32 widened to float64 carries noise such as 18.1200008392334
+	noisy := float64(float32(18.12))
+	u, v := float64(float32(-3.07)), float64(float32(4.556))
+
+	rows := []WindOutput{{City: "Oslo", WindSpeed: noisy, WindU: &u, WindV: &v}}
+	roundRows(rows, defaultFloatPrecision)
+
+	if rows[0].WindSpeed != 18.12 || *rows[0].WindU != -3.07 || *rows[0].WindV != 4.56 {
+		t.Errorf("got %v, %v, %v, want 18.12, -3.07, 4.56", rows[0].WindSpeed, *rows[0].WindU, *rows[0].WindV)
+	}
+
+	body, err := outputFormats["csv"].Marshal(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), ",18.12,") || strings.Contains(string(body), "18.120001") {
+		t.Errorf("got csv %q, want 18.12 written without float32 noise", body)
+	}
+
+	tests := []struct {
+		precision int
+		want      float64
+	}{
+		{0, 18},
+		{1, 18.1},
+		{3, 18.12},
+		{6, 18.120001},
+	}
+
+	for _, tt := range tests {
+		rows := []TemperatureOutput{{City: "Oslo", Temperature: noisy}}
+		roundRows(rows, tt.precision)
+
+		if rows[0].Temperature != tt.want {
+			t.Errorf("precision %d: got %v, want %v", tt.precision, rows[0].Temperature, tt.want)
+		}
+	}
+}
+
+func TestSelectFloatPrecision(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{"", defaultFloatPrecision, false},
+		{"0", 0, false},
+		{" 4 ", 4, false},
+		{"-1", 0, true},
+		{"two", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("FLOAT_PRECISION", tt.env)
+
+		got, err := selectFloatPrecision()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("FLOAT_PRECISION %q: got error %v, want error %v", tt.env, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("FLOAT_PRECISION %q: got %d, want %d", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestPaginateReports(t *testing.T) {
+	rows := make([]SummaryOutput, 5)
+	for i := range rows {
+		rows[i].City = fmt.Sprintf("City %d", i+1)
+	}
+
+	reportFiles := []ReportFile{
+		{Key: "summary.csv", Bucket: "reports", Rows: rows},
+		{Key: "highest_wind.csv", Rows: rows[:3]},
+		{Key: "all_weather/dt=2024-06-01/part.csv", Rows: rows},
+	}
+
+	paged := paginateReports(reportFiles, 3, outputFormats["csv"])
+
+	want := []struct {
+		key    string
+		bucket string
+		cities []string
+	}{
+		{"summary/part-0001.csv", "reports", []string{"City 1", "City 2", "City 3"}},
+		{"summary/part-0002.csv", "reports", []string{"City 4", "City 5"}},
+		{"highest_wind.csv", "", []string{"City 1", "City 2", "City 3"}},
+		{"all_weather/dt=2024-06-01/part-0001.csv", "", []string{"City 1", "City 2", "City 3"}},
+		{"all_weather/dt=2024-06-01/part-0002.csv", "", []string{"City 4", "City 5"}},
+	}
+
+	if len(paged) != len(want) {
+		t.Fatalf("got %d report files, want %d", len(paged), len(want))
+	}
+
+	for i, report := range paged {
+		cities := make([]string, 0)
+		for _, row := range report.Rows.([]SummaryOutput) {
+			cities = append(cities, row.City)
+		}
+
+		if report.Key != want[i].key || report.Bucket != want[i].bucket || !reflect.DeepEqual(cities, want[i].cities) {
+			t.Errorf("part %d: got %s in %q with %v, want %s in %q with %v", i, report.Key, report.Bucket, cities,
+				want[i].key, want[i].bucket, want[i].cities)
+		}
+	}
+
+	if unpaged := paginateReports(reportFiles, 0, outputFormats["csv"]); !reflect.DeepEqual(unpaged, reportFiles) {
+		t.Errorf("got %v without MAX_ROWS_PER_FILE, want the reports unchanged", unpaged)
+	}
+}
+
+func TestProcessPaginatesSummary(t *testing.T) {
+	t.Setenv("SORT_BY", "city")
+	t.Setenv("MAX_ROWS_PER_FILE", "3")
+
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("Oslo\nCairo\nLima\nParis\nTokyo\n")
+
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+		logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := string(client.objects["output/summary/part-0001.csv"]), string(client.objects["output/summary/part-0002.csv"])
+
+	if !strings.Contains(first, "Cairo") || !strings.Contains(first, "Oslo") || strings.Contains(first, "Tokyo") {
+		t.Errorf("got first part %q, want Cairo, Lima and Oslo", first)
+	}
+	if !strings.Contains(second, "Paris") || !strings.Contains(second, "Tokyo") || strings.Contains(second, "Cairo") {
+		t.Errorf("got second part %q, want Paris and Tokyo", second)
+	}
+
+	if _, ok := client.objects["output/summary/part-0003.csv"]; ok {
+		t.Error("got a third part for five rows of three")
+	}
+	if _, ok := client.objects["output/summary.csv"]; ok {
+		t.Error("got the unpaginated summary alongside its parts")
+	}
+}
@@ -0,0 +1,109 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryBaseDelay is the backoff ceiling before the first retry of an api request
+const retryBaseDelay = 500 * time.Millisecond
+
+// maxRetryDelay caps the computed backoff between api retries
+const maxRetryDelay = 30 * time.Second
+
+// selectMaxRetries reads the API_MAX_RETRIES env var bounding retries of throttled or failed api requests
+// Output:
+//     If success returns the number of retries, zero when unset, and nil, otherwise an error
+func selectMaxRetries() (int, error) {
+	value := strings.TrimSpace(os.Getenv("API_MAX_RETRIES"))
+	if value == "" {
+		return 0, nil
+	}
+
+	retries, err := strconv.Atoi(value)
+	if err != nil || retries < 0 {
+		return 0, fmt.Errorf("invalid API_MAX_RETRIES! %s must be a non-negative integer", value)
+	}
+
+	return retries, nil
+}
+
+// selectS3MaxRetries reads the S3_MAX_RETRIES env var bounding the SDK's retries of S3 operations,
+//     separately from the weather api retries
+// Output:
+//     If success returns the number of retries, -1 when unset to keep the SDK default, and nil, otherwise an error
+func selectS3MaxRetries() (int, error) {
+	value := strings.TrimSpace(os.Getenv("S3_MAX_RETRIES"))
+	if value == "" {
+		return -1, nil
+	}
+
+	retries, err := strconv.Atoi(value)
+	if err != nil || retries < 0 {
+		return 0, fmt.Errorf("invalid S3_MAX_RETRIES! %s must be a non-negative integer", value)
+	}
+
+	return retries, nil
+}
+
+// retryableStatus reports whether an api response is worth retrying
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// retryDelay computes how long to wait before retrying an api request
+// Inputs:
+//     attempt: number of retries already made, starting from zero
+//     retryAfter: Retry-After header of the response, in seconds or as an HTTP date, empty when absent
+//     now: current time used to resolve an HTTP date
+// Output:
+//     the delay requested by Retry-After when present, otherwise exponential backoff with full jitter
+func retryDelay(attempt int, retryAfter string, now time.Time) time.Duration {
+	retryAfter = strings.TrimSpace(retryAfter)
+
+	// The provider's rate policy takes precedence over our own backoff
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay
+		}
+		return 0
+	}
+
+	ceiling := maxRetryDelay
+	if attempt < 16 && retryBaseDelay<<attempt < maxRetryDelay {
+		ceiling = retryBaseDelay << attempt
+	}
+
+	// Seeded per call so concurrent invocations do not retry in lockstep
+	jitter := rand.New(rand.NewSource(now.UnixNano()))
+
+	return time.Duration(jitter.Int63n(int64(ceiling)))
+}
+
+// sleepContext waits for the delay, returning early when the context is cancelled
+// Inputs:
+//     ctx: context of the request
+//     delay: time to wait
+// Output:
+//     If the delay elapsed returns nil, otherwise the context's error
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,157 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestRetryDelay(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter string
+		want       time.Duration
+	}{
+		{"seconds", 0, "7", 7 * time.Second},
+		{"seconds with spaces", 3, " 2 ", 2 * time.Second},
+		{"zero seconds", 0, "0", 0},
+		{"http date", 0, "Sat, 01 Jun 2024 12:00:30 GMT", 30 * time.Second},
+		{"http date overrides backoff", 10, "Sat, 01 Jun 2024 12:01:00 GMT", time.Minute},
+		{"http date in the past", 0, "Sat, 01 Jun 2024 11:59:00 GMT", 0},
+	}
+
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempt, tt.retryAfter, now); got != tt.want {
+			t.Errorf("%s: retryDelay(%d, %q) = %v, want %v", tt.name, tt.attempt, tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelayBackoff(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		attempt    int
+		retryAfter string
+		ceiling    time.Duration
+	}{
+		{0, "", retryBaseDelay},
+		{1, "", 2 * retryBaseDelay},
+		{3, "soon", 8 * retryBaseDelay},
+		{6, "", maxRetryDelay},
+		{40, "-5", maxRetryDelay},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			got := retryDelay(tt.attempt, tt.retryAfter, now.Add(time.Duration(i)))
+			if got < 0 || got >= tt.ceiling {
+				t.Errorf("retryDelay(%d, %q) = %v, want jitter below %v", tt.attempt, tt.retryAfter, got, tt.ceiling)
+			}
+		}
+	}
+}
+
+func TestGetBodyHonoursRetryAfter(t *testing.T) {
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, time.Now())
+		if len(requests) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		fmt.Fprint(w, `{"name":"London"}`)
+	}))
+	defer server.Close()
+
+	status, _, err := getBody(context.Background(), server.Client(), nil, server.URL, defaultMaxResponseBytes, 2)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("got status %d, %v, want the retry to succeed", status, err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+
+	if gap := requests[1].Sub(requests[0]); gap < 900*time.Millisecond {
+		t.Errorf("retried after %v, want the 1s Retry-After honoured", gap)
+	}
+}
+
+func TestSelectS3MaxRetries(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{"", -1, false},
+		{"0", 0, false},
+		{" 5 ", 5, false},
+		{"-1", 0, true},
+		{"many", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("S3_MAX_RETRIES", tt.env)
+
+		got, err := selectS3MaxRetries()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("S3_MAX_RETRIES %q: got error %v, want error %v", tt.env, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("S3_MAX_RETRIES %q: got %d, want %d", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestS3ClientOptionsRetries(t *testing.T) {
+	tests := []struct {
+		retries      int
+		wantAttempts int
+	}{
+		{0, 1},
+		{1, 2},
+		{4, 5},
+	}
+
+	for _, tt := range tests {
+		var options s3.Options
+		s3ClientOptions(tt.retries, "")(&options)
+
+		if options.Retryer == nil {
+			t.Errorf("S3_MAX_RETRIES %d: got no retryer", tt.retries)
+			continue
+		}
+		if got := options.Retryer.MaxAttempts(); got != tt.wantAttempts {
+			t.Errorf("S3_MAX_RETRIES %d: got %d attempts, want %d", tt.retries, got, tt.wantAttempts)
+		}
+	}
+
+	var options s3.Options
+	s3ClientOptions(-1, "")(&options)
+
+	if options.Retryer != nil || options.UsePathStyle {
+		t.Errorf("got retryer %v and path style %v without S3_MAX_RETRIES or AWS_S3_ENDPOINT", options.Retryer, options.UsePathStyle)
+	}
+}
+
+func TestS3ClientOptionsEndpoint(t *testing.T) {
+	var options s3.Options
+	s3ClientOptions(-1, "http://localhost:4566")(&options)
+
+	if !options.UsePathStyle || options.EndpointResolver == nil {
+		t.Errorf("got path style %v and resolver %v, want path style requests to the local endpoint",
+			options.UsePathStyle, options.EndpointResolver)
+	}
+}
@@ -0,0 +1,267 @@
+package weather
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// OutputSink defines the interface for the destination report files are written to
+type OutputSink interface {
+	Write(ctx context.Context, key string, body []byte, contentType string) error
+}
+
+// S3OutputAPI combines the functions used to publish a report to S3.
+type S3OutputAPI interface {
+	S3PutObjectAPI
+	S3CopyObjectAPI
+	S3DeleteObjectAPI
+}
+
+// S3Sink writes reports to an S3 bucket
+type S3Sink struct {
+	Client S3OutputAPI
+	Bucket string
+	// KMSKeyID encrypts reports with SSE-KMS under this key when set
+	KMSKeyID string
+	// ACL is the canned ACL applied to reports, empty for the bucket default
+	ACL types.ObjectCannedACL
+}
+
+// StdoutSink prints reports, each preceded by its key
+type StdoutSink struct {
+	Writer io.Writer
+}
+
+// FileSink writes reports under a local directory, keys become relative paths
+type FileSink struct {
+	Dir string
+}
+
+// dryRunSink logs the S3 writes a run would make without making them
+type dryRunSink struct {
+	logger *log.Logger
+	bucket string
+}
+
+// selectOutputSink reads the OUTPUT_SINK env var naming where reports are written, defaulting to s3
+// Output:
+//     If success returns "s3", "stdout" or "file" and nil, otherwise an error
+func selectOutputSink() (string, error) {
+	sink := strings.ToLower(strings.TrimSpace(os.Getenv("OUTPUT_SINK")))
+
+	switch sink {
+	case "":
+		return "s3", nil
+	case "s3", "stdout", "file":
+		return sink, nil
+	default:
+		return "", fmt.Errorf("unsupported OUTPUT_SINK! %s", sink)
+	}
+}
+
+// selectObjectACL reads the S3_ACL env var naming the canned ACL applied to uploaded reports
+// Output:
+//     If success returns the ACL, empty for the bucket default, and nil, otherwise an error
+func selectObjectACL() (types.ObjectCannedACL, error) {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv("S3_ACL")))
+	if value == "" {
+		return "", nil
+	}
+
+	for _, acl := range types.ObjectCannedACL("").Values() {
+		if string(acl) == value {
+			return acl, nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported S3_ACL! %s", value)
+}
+
+// selectOutputDir reads the OUTPUT_DIR env var used by the file sink, defaulting to "output"
+func selectOutputDir() string {
+	dir := strings.TrimSpace(os.Getenv("OUTPUT_DIR"))
+	if dir == "" {
+		return "output"
+	}
+
+	return dir
+}
+
+// outputSink builds the sink selected for this run
+// Inputs:
+//     bucket: bucket written to by the s3 sink
+// Output:
+//     OutputSink writing to the selected destination, S3 writes are only logged during a dry run
+func (p *Processor) outputSink(bucket string) OutputSink {
+	switch p.config.Sink {
+	case "stdout":
+		// Reports are buffered and written out by flushOutput once the run ends
+		if p.stdout == nil {
+			p.stdout = bufio.NewWriter(os.Stdout)
+		}
+		return StdoutSink{Writer: p.stdout}
+	case "file":
+		return FileSink{Dir: p.config.OutputDir}
+	}
+
+	if p.dryRun() {
+		return dryRunSink{logger: p.logger, bucket: bucket}
+	}
+
+	return S3Sink{
+		Client:   p.s3Client,
+		Bucket:   bucket,
+		KMSKeyID: p.config.KMSKeyID,
+		ACL:      p.config.ObjectACL,
+	}
+}
+
+// backupOutput mirrors a report written to S3 into the backup bucket, a failed mirror is only logged
+//     unless BACKUP_STRICT is enabled
+// Inputs:
+//     ctx: context of the invocation
+//     bucket: bucket the report was written to
+//     key: output key of the report
+//     body: serialized report
+//     format: OutputFormat providing the content type
+// Output:
+//     If success or the failure is tolerated returns nil, otherwise an error
+func (p *Processor) backupOutput(ctx context.Context, bucket string, key string, body []byte, format OutputFormat) error {
+	backup := p.config.BackupBucket
+	if backup == "" || backup == bucket || p.config.Sink != "s3" {
+		return nil
+	}
+
+	err := p.outputSink(backup).Write(ctx, key, body, format.ContentType)
+	if err == nil {
+		return nil
+	}
+
+	if p.config.BackupStrict {
+		return fmt.Errorf("failed to write %s to backup bucket %s! %w", key, backup, err)
+	}
+
+	p.logger.Printf("failed to write %s to backup bucket %s! %s", key, backup, err)
+	return nil
+}
+
+// flushOutput writes out any reports still buffered by the sink, called even when the run fails
+//     so reports produced before the error are not lost
+func (p *Processor) flushOutput() {
+	if p.stdout == nil {
+		return
+	}
+
+	if err := p.stdout.Flush(); err != nil {
+		p.logger.Printf("failed to flush buffered output! %s", err)
+	}
+}
+
+// Write puts a report to a temporary key and copies it to its final key once fully
+//		uploaded, so readers only ever see a complete file, the copy is encrypted and given the ACL again
+//		as S3 does not carry them over from the source
+// Inputs:
+//     ctx: context of the invocation
+//     key: final output key of the report
+//     body: serialized report
+//     contentType: content type stored with the object
+// Output:
+//     If success returns nil, otherwise an error
+func (sink S3Sink) Write(ctx context.Context, key string, body []byte, contentType string) error {
+	tempKey := fmt.Sprintf("_tmp/%s.%d", key, time.Now().UnixNano())
+
+	put := &s3.PutObjectInput{
+		Bucket:      aws.String(sink.Bucket),
+		Key:         aws.String(tempKey),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+		ACL:         sink.ACL,
+	}
+
+	copyInput := &s3.CopyObjectInput{
+		Bucket:     aws.String(sink.Bucket),
+		Key:        aws.String(key),
+		CopySource: aws.String(sink.Bucket + "/" + url.PathEscape(tempKey)),
+		ACL:        sink.ACL,
+	}
+
+	if sink.KMSKeyID != "" {
+		put.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		put.SSEKMSKeyId = aws.String(sink.KMSKeyID)
+		copyInput.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		copyInput.SSEKMSKeyId = aws.String(sink.KMSKeyID)
+	}
+
+	_, err := PutObject(ctx, sink.Client, put)
+	if err != nil {
+		return err
+	}
+
+	_, copyErr := CopyObject(ctx, sink.Client, copyInput)
+
+	// Always remove the temporary object, even if the copy failed
+	_, err = DeleteObject(ctx, sink.Client, &s3.DeleteObjectInput{
+		Bucket: aws.String(sink.Bucket),
+		Key:    aws.String(tempKey),
+	})
+
+	if copyErr != nil {
+		return copyErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to remove temporary object %s! %w", tempKey, err)
+	}
+
+	return nil
+}
+
+// Write prints a report under a header naming its key
+// Inputs:
+//     ctx: context of the invocation
+//     key: output key of the report
+//     body: serialized report
+//     contentType: unused
+// Output:
+//     If success returns nil, otherwise an error
+func (sink StdoutSink) Write(ctx context.Context, key string, body []byte, contentType string) error {
+	_, err := fmt.Fprintf(sink.Writer, "==> %s <==\n%s\n", key, body)
+	return err
+}
+
+// Write saves a report to the sink directory, creating any directories in its key
+// Inputs:
+//     ctx: context of the invocation
+//     key: output key of the report, used as a path relative to the sink directory
+//     body: serialized report
+//     contentType: unused
+// Output:
+//     If success returns nil, otherwise an error
+func (sink FileSink) Write(ctx context.Context, key string, body []byte, contentType string) error {
+	path := filepath.Join(sink.Dir, filepath.FromSlash(key))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, body, 0644)
+}
+
+// Write logs the upload a real run would make
+func (sink dryRunSink) Write(ctx context.Context, key string, body []byte, contentType string) error {
+	sink.logger.Printf("dry run: would upload %d bytes to s3://%s/%s", len(body), sink.bucket, key)
+	return nil
+}
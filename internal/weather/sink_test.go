@@ -0,0 +1,495 @@
+package weather
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 keeps objects in memory and records every call made to it
+type fakeS3 struct {
+	objects      map[string][]byte
+	contentTypes map[string]string
+	calls        []string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte), contentTypes: make(map[string]string)}
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput,
+	optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	object := *params.Bucket + "/" + *params.Key
+	f.calls = append(f.calls, "put "+object)
+
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.objects[object] = body
+	f.contentTypes[object] = aws.ToString(params.ContentType)
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) CopyObject(ctx context.Context, params *s3.CopyObjectInput,
+	optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	object := *params.Bucket + "/" + *params.Key
+	f.calls = append(f.calls, "copy "+object)
+
+	source, err := url.PathUnescape(*params.CopySource)
+	if err != nil {
+		return nil, err
+	}
+
+	body, ok := f.objects[source]
+	if !ok {
+		return nil, fmt.Errorf("no such key %s", source)
+	}
+
+	f.objects[object] = body
+	f.contentTypes[object] = f.contentTypes[source]
+
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput,
+	optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	object := *params.Bucket + "/" + *params.Key
+	f.calls = append(f.calls, "delete "+object)
+
+	delete(f.objects, object)
+
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput,
+	optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	object := *params.Bucket + "/" + *params.Key
+	f.calls = append(f.calls, "get "+object)
+
+	// Missing objects fail as S3 does, so first runs of history.csv see an empty history
+	body, ok := f.objects[object]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("no such key " + object)}
+	}
+
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (f *fakeS3) HeadBucket(ctx context.Context, params *s3.HeadBucketInput,
+	optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	f.calls = append(f.calls, "head "+*params.Bucket)
+
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func TestS3SinkContentType(t *testing.T) {
+	for name, format := range outputFormats {
+		t.Run(name, func(t *testing.T) {
+			client := newFakeS3()
+			sink := S3Sink{Client: client, Bucket: "output"}
+
+			key := "highest_temperatures." + format.Extension
+			if err := sink.Write(context.Background(), key, []byte("report"), format.ContentType); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := client.contentTypes["output/"+key]; got != format.ContentType {
+				t.Errorf("got content type %q, want %q", got, format.ContentType)
+			}
+		})
+	}
+}
+
+func TestSelectOutputSink(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"", "s3", false},
+		{"S3", "s3", false},
+		{" stdout ", "stdout", false},
+		{"file", "file", false},
+		{"ftp", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("OUTPUT_SINK", tt.env)
+
+		got, err := selectOutputSink()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("OUTPUT_SINK %q: got error %v, want error %v", tt.env, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("OUTPUT_SINK %q: got %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestStdoutSinkWrite(t *testing.T) {
+	var buf bytes.Buffer
+	sink := StdoutSink{Writer: &buf}
+
+	if err := sink.Write(context.Background(), "highest_wind.csv", []byte("Rank,City\n1,Oslo\n"), "text/csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "==> highest_wind.csv <==\nRank,City\n1,Oslo\n\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestFileSinkWrite(t *testing.T) {
+	dir := t.TempDir()
+	sink := FileSink{Dir: dir}
+
+	if err := sink.Write(context.Background(), "2024/06/highest_wind.csv", []byte("report"), "text/csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "2024", "06", "highest_wind.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "report" {
+		t.Errorf("got %q, want the report body", got)
+	}
+}
+
+func TestProcessStdoutSink(t *testing.T) {
+	t.Setenv("OUTPUT_SINK", "stdout")
+
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("London\nParis\n")
+
+	var buf bytes.Buffer
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+		logger: log.New(io.Discard, "", 0), stdout: bufio.NewWriter(&buf)}
+
+	p.config = testConfig(t)
+	if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"==> highest_temperatures.csv <==", "==> highest_wind.csv <==", "London", "Paris"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got output %q, want it to contain %q", buf.String(), want)
+		}
+	}
+
+	for object := range client.objects {
+		if strings.HasPrefix(object, "output/") {
+			t.Errorf("got %s written to S3 by the stdout sink", object)
+		}
+	}
+}
+
+func TestProcessFileSink(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("OUTPUT_SINK", "file")
+	t.Setenv("OUTPUT_DIR", dir)
+
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("London\nParis\n")
+
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+		logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := os.ReadFile(filepath.Join(dir, "highest_temperatures.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(report), "London") {
+		t.Errorf("got report %q, want London ranked", report)
+	}
+
+	for object := range client.objects {
+		if strings.HasPrefix(object, "output/") {
+			t.Errorf("got %s written to S3 by the file sink", object)
+		}
+	}
+}
+
+// failingDeleteS3 serves the wrapped fakeS3 but rejects every delete, failing the run at cleanup
+type failingDeleteS3 struct {
+	*fakeS3
+}
+
+func (f failingDeleteS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput,
+	optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	return nil, fmt.Errorf("access denied")
+}
+
+func TestProcessFlushesOutputAfterError(t *testing.T) {
+	t.Setenv("OUTPUT_SINK", "stdout")
+
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("London\nParis\n")
+
+	var buf bytes.Buffer
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{s3Client: failingDeleteS3{client}, weatherProvider: provider, inputBucket: "input",
+		outputBucket: "output", logger: log.New(io.Discard, "", 0), stdout: bufio.NewWriterSize(&buf, 64*1024)}
+
+	p.config = testConfig(t)
+	if _, err := p.Process(context.Background(), "cities.csv", ""); err == nil {
+		t.Fatal("expected the failed cleanup to fail the run")
+	}
+
+	if p.stdout.Buffered() != 0 {
+		t.Errorf("got %d bytes still buffered after the failed run", p.stdout.Buffered())
+	}
+
+	for _, want := range []string{"==> highest_temperatures.csv <==", "==> highest_wind.csv <==", "London"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got output %q, want the reports written before the error to contain %q", buf.String(), want)
+		}
+	}
+}
+
+// rejectingBucketS3 serves the wrapped fakeS3 but rejects uploads to one bucket
+type rejectingBucketS3 struct {
+	*fakeS3
+	bucket string
+}
+
+func (f rejectingBucketS3) PutObject(ctx context.Context, params *s3.PutObjectInput,
+	optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if *params.Bucket == f.bucket {
+		return nil, fmt.Errorf("access denied")
+	}
+
+	return f.fakeS3.PutObject(ctx, params, optFns...)
+}
+
+func TestProcessBackupBucket(t *testing.T) {
+	t.Setenv("BACKUP_OUTPUT_BUCKET", "backup")
+
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("London\nParis\n")
+
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+		logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"highest_temperatures.csv", "highest_wind.csv"} {
+		primary, ok := client.objects["output/"+key]
+		if !ok {
+			t.Errorf("got no %s in the output bucket", key)
+			continue
+		}
+
+		backup, ok := client.objects["backup/"+key]
+		if !ok {
+			t.Errorf("got no %s in the backup bucket", key)
+			continue
+		}
+
+		if !bytes.Equal(primary, backup) {
+			t.Errorf("got backup %s %q, want it to match %q", key, backup, primary)
+		}
+	}
+}
+
+func TestBackupOutput(t *testing.T) {
+	tests := []struct {
+		backup       string
+		strict       string
+		rejected     string
+		wantErr      bool
+		wantBackedUp bool
+	}{
+		{"", "", "", false, false},
+		{"backup", "", "", false, true},
+		{"backup", "", "backup", false, false},
+		{"backup", "true", "backup", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("BACKUP_OUTPUT_BUCKET", tt.backup)
+		t.Setenv("BACKUP_STRICT", tt.strict)
+
+		client := newFakeS3()
+		p := &Processor{s3Client: rejectingBucketS3{client, tt.rejected}, config: testConfig(t),
+			logger: log.New(io.Discard, "", 0)}
+
+		err := p.uploadOutput(context.Background(), "output", "highest_wind.csv", []byte("report"), outputFormats["csv"])
+		if (err != nil) != tt.wantErr {
+			t.Errorf("backup %q strict %q: got error %v, want error %v", tt.backup, tt.strict, err, tt.wantErr)
+		}
+
+		// The primary report is written whatever happens to its backup
+		if _, ok := client.objects["output/highest_wind.csv"]; !ok {
+			t.Errorf("backup %q strict %q: got no primary report", tt.backup, tt.strict)
+		}
+
+		if _, ok := client.objects["backup/highest_wind.csv"]; ok != tt.wantBackedUp {
+			t.Errorf("backup %q strict %q: got backed up %v, want %v", tt.backup, tt.strict, ok, tt.wantBackedUp)
+		}
+	}
+}
+
+func TestBackupOutputSameBucket(t *testing.T) {
+	t.Setenv("BACKUP_OUTPUT_BUCKET", "output")
+
+	client := newFakeS3()
+	p := &Processor{s3Client: client, config: testConfig(t), logger: log.New(io.Discard, "", 0)}
+
+	if err := p.uploadOutput(context.Background(), "output", "highest_wind.csv", []byte("report"), outputFormats["csv"]); err != nil {
+		t.Fatal(err)
+	}
+
+	copies := 0
+	for _, call := range client.calls {
+		if call == "copy output/highest_wind.csv" {
+			copies++
+		}
+	}
+
+	if copies != 1 {
+		t.Errorf("got calls %v, want the report written once when the backup is the output bucket", client.calls)
+	}
+}
+
+// inputRecordingS3 keeps the put and copy inputs sent to the wrapped fakeS3
+type inputRecordingS3 struct {
+	*fakeS3
+	puts   []*s3.PutObjectInput
+	copies []*s3.CopyObjectInput
+}
+
+func (f *inputRecordingS3) PutObject(ctx context.Context, params *s3.PutObjectInput,
+	optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.puts = append(f.puts, params)
+	return f.fakeS3.PutObject(ctx, params, optFns...)
+}
+
+func (f *inputRecordingS3) CopyObject(ctx context.Context, params *s3.CopyObjectInput,
+	optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	f.copies = append(f.copies, params)
+	return f.fakeS3.CopyObject(ctx, params, optFns...)
+}
+
+func TestS3SinkEncryptionAndACL(t *testing.T) {
+	tests := []struct {
+		kmsKeyID string
+		acl      types.ObjectCannedACL
+		wantSSE  types.ServerSideEncryption
+	}{
+		{"", "", ""},
+		{"arn:aws:kms:us-east-1:111122223333:key/1234abcd", "", types.ServerSideEncryptionAwsKms},
+		{"", types.ObjectCannedACLBucketOwnerFullControl, ""},
+		{"alias/reports", types.ObjectCannedACLPrivate, types.ServerSideEncryptionAwsKms},
+	}
+
+	for _, tt := range tests {
+		client := &inputRecordingS3{fakeS3: newFakeS3()}
+		sink := S3Sink{Client: client, Bucket: "output", KMSKeyID: tt.kmsKeyID, ACL: tt.acl}
+
+		if err := sink.Write(context.Background(), "highest_wind.csv", []byte("report"), "text/csv"); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(client.puts) != 1 || len(client.copies) != 1 {
+			t.Fatalf("got %d puts and %d copies, want one of each", len(client.puts), len(client.copies))
+		}
+
+		put, copied := client.puts[0], client.copies[0]
+
+		if put.ServerSideEncryption != tt.wantSSE || aws.ToString(put.SSEKMSKeyId) != tt.kmsKeyID || put.ACL != tt.acl {
+			t.Errorf("key %q acl %q: got put encryption %q key %q acl %q", tt.kmsKeyID, tt.acl,
+				put.ServerSideEncryption, aws.ToString(put.SSEKMSKeyId), put.ACL)
+		}
+
+		// S3 does not carry encryption or the ACL over to a copy
+		if copied.ServerSideEncryption != tt.wantSSE || aws.ToString(copied.SSEKMSKeyId) != tt.kmsKeyID || copied.ACL != tt.acl {
+			t.Errorf("key %q acl %q: got copy encryption %q key %q acl %q", tt.kmsKeyID, tt.acl,
+				copied.ServerSideEncryption, aws.ToString(copied.SSEKMSKeyId), copied.ACL)
+		}
+	}
+}
+
+func TestSelectObjectACL(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    types.ObjectCannedACL
+		wantErr bool
+	}{
+		{"", "", false},
+		{"private", types.ObjectCannedACLPrivate, false},
+		{" Bucket-Owner-Full-Control ", types.ObjectCannedACLBucketOwnerFullControl, false},
+		{"public", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("S3_ACL", tt.env)
+
+		got, err := selectObjectACL()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("S3_ACL %q: got error %v, want error %v", tt.env, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("S3_ACL %q: got %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestProcessEncryptedReports(t *testing.T) {
+	t.Setenv("SSE_KMS_KEY_ID", "alias/reports")
+	t.Setenv("S3_ACL", "bucket-owner-full-control")
+
+	client := &inputRecordingS3{fakeS3: newFakeS3()}
+	client.objects["input/cities.csv"] = []byte("London\nParis\n")
+
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+		logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.puts) == 0 {
+		t.Fatal("got no reports written")
+	}
+
+	for _, put := range client.puts {
+		if put.ServerSideEncryption != types.ServerSideEncryptionAwsKms || aws.ToString(put.SSEKMSKeyId) != "alias/reports" ||
+			put.ACL != types.ObjectCannedACLBucketOwnerFullControl {
+			t.Errorf("%s: got encryption %q key %q acl %q", aws.ToString(put.Key),
+				put.ServerSideEncryption, aws.ToString(put.SSEKMSKeyId), put.ACL)
+		}
+	}
+}
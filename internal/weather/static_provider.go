@@ -0,0 +1,63 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// StaticProvider serves fixed weather from memory, for offline runs and repeatable reports
+type StaticProvider struct {
+	// Weather maps a normalized location to the Weather returned for it
+	Weather map[string]Weather
+}
+
+// loadStaticProvider reads the STATIC_WEATHER_FILE used by the static provider
+// Inputs:
+//     path: json file holding an object of location name to OpenWeatherMap style weather,
+//         such as {"London": {"name": "London", "main": {"temp": 12.5}}}
+// Output:
+//     If success returns the StaticProvider and nil, otherwise an error
+func loadStaticProvider(path string) (StaticProvider, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return StaticProvider{}, fmt.Errorf("WEATHER_PROVIDER static requires STATIC_WEATHER_FILE")
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return StaticProvider{}, fmt.Errorf("failed to read STATIC_WEATHER_FILE! %w", err)
+	}
+
+	locations := make(map[string]Weather)
+	if err := json.Unmarshal(body, &locations); err != nil {
+		return StaticProvider{}, fmt.Errorf("failed to load JSON into Struct! %w", err)
+	}
+
+	provider := StaticProvider{Weather: make(map[string]Weather, len(locations))}
+	for location, cityWeather := range locations {
+		if cityWeather.Name == "" {
+			cityWeather.Name = location
+		}
+		provider.Weather[normalizeCity(location)] = cityWeather
+	}
+
+	return provider, nil
+}
+
+// Fetch returns the fixed weather of a single location
+// Inputs:
+//     ctx: context of the request
+//     location: city name to look up
+// Output:
+//     If success returns the Weather and nil, otherwise an error
+func (provider StaticProvider) Fetch(ctx context.Context, location string) (Weather, error) {
+	cityWeather, ok := provider.Weather[normalizeCity(location)]
+	if !ok {
+		return Weather{}, fmt.Errorf("weather lookup failed for %s! no static weather", location)
+	}
+
+	return cityWeather, nil
+}
@@ -0,0 +1,66 @@
+package weather
+
+import "sort"
+
+// WeatherStats defines the aggregate statistics across every city queried in a run
+type WeatherStats struct {
+	MeanTemperature   float64 `json:"meanTemperature"`
+	MedianTemperature float64 `json:"medianTemperature"`
+	MeanWindSpeed     float64 `json:"meanWindSpeed"`
+	MedianWindSpeed   float64 `json:"medianWindSpeed"`
+}
+
+// summarizeWeather computes the aggregate statistics over the full list of cities, not just the reported top
+// Inputs:
+//     weatherList: list of Weather structs to summarize
+//     windUnit: WIND_UNIT the wind speeds are converted into
+// Output:
+//     WeatherStats of the list, zero when it is empty
+func summarizeWeather(weatherList []Weather, windUnit string) WeatherStats {
+	temperatures := make([]float64, len(weatherList))
+	winds := make([]float64, len(weatherList))
+
+	for i, city := range weatherList {
+		temperatures[i] = temperatureValue(city)
+		winds[i] = convertWindSpeed(windValue(city), windUnit)
+	}
+
+	return WeatherStats{
+		MeanTemperature:   mean(temperatures),
+		MedianTemperature: median(temperatures),
+		MeanWindSpeed:     mean(winds),
+		MedianWindSpeed:   median(winds),
+	}
+}
+
+// mean returns the arithmetic mean of values, zero when empty
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, value := range values {
+		total += value
+	}
+
+	return total / float64(len(values))
+}
+
+// median returns the middle of values, the mean of the two middle values for an even count, zero when empty
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+
+	return sorted[middle]
+}
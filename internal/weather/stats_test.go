@@ -0,0 +1,78 @@
+package weather
+
+import "testing"
+
+func TestMeanMedian(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     []float64
+		wantMean   float64
+		wantMedian float64
+	}{
+		{"empty", nil, 0, 0},
+		{"single", []float64{4}, 4, 4},
+		{"odd count", []float64{9, 1, 5}, 5, 5},
+		{"even count", []float64{10, 2, 4, 8}, 6, 6},
+		{"skewed", []float64{1, 2, 3, 50}, 14, 2.5},
+		{"negatives", []float64{-4, -1, 2}, -1, -1},
+	}
+
+	for _, tt := range tests {
+		if got := mean(tt.values); got != tt.wantMean {
+			t.Errorf("%s: mean = %v, want %v", tt.name, got, tt.wantMean)
+		}
+		if got := median(tt.values); got != tt.wantMedian {
+			t.Errorf("%s: median = %v, want %v", tt.name, got, tt.wantMedian)
+		}
+	}
+}
+
+func TestMedianLeavesInputUnsorted(t *testing.T) {
+	values := []float64{3, 1, 2}
+
+	median(values)
+
+	if values[0] != 3 || values[1] != 1 || values[2] != 2 {
+		t.Errorf("median reordered its input: %v", values)
+	}
+}
+
+func TestSummarizeWeather(t *testing.T) {
+	readings := []struct {
+		temp float64
+		wind float64
+	}{
+		{12, 3},
+		{-2, 9},
+		{20, 1},
+		{6, 4},
+		{30, 8},
+	}
+
+	weatherList := make([]Weather, len(readings))
+	for i, reading := range readings {
+		weatherList[i].Main.Temp = reading.temp
+		weatherList[i].Wind.Speed = reading.wind
+	}
+
+	want := WeatherStats{
+		MeanTemperature:   13.2,
+		MedianTemperature: 12,
+		MeanWindSpeed:     5,
+		MedianWindSpeed:   4,
+	}
+
+	if got := summarizeWeather(weatherList, "m/s"); got != want {
+		t.Errorf("summarizeWeather = %+v, want %+v", got, want)
+	}
+
+	// Wind speeds follow WIND_UNIT, temperatures are unchanged
+	if got := summarizeWeather(weatherList, "km/h"); got.MeanWindSpeed != 18 || got.MedianWindSpeed != 14.4 ||
+		got.MeanTemperature != want.MeanTemperature {
+		t.Errorf("km/h: summarizeWeather = %+v", got)
+	}
+
+	if got := summarizeWeather(nil, "m/s"); got != (WeatherStats{}) {
+		t.Errorf("summarizeWeather(nil) = %+v, want zero", got)
+	}
+}
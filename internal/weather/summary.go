@@ -0,0 +1,126 @@
+package weather
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// summaryName is the base key of the summary report written when SORT_BY is set
+const summaryName = "summary"
+
+// allWeatherName is the base key of the full dump of every city written when OUTPUT_ALL is set
+const allWeatherName = "all_weather"
+
+// SummaryOutput defines the interface for the csv summary data, one row per city with every metric
+type SummaryOutput struct {
+	City          string  `csv:"City" json:"city" msgpack:"city" parquet:"name=city, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Lat           float64 `csv:"Lat" json:"lat" msgpack:"lat" parquet:"name=lat, type=DOUBLE"`
+	Lon           float64 `csv:"Lon" json:"lon" msgpack:"lon" parquet:"name=lon, type=DOUBLE"`
+	Temperature   float64 `csv:"Temperature" json:"temperature" msgpack:"temperature" parquet:"name=temperature, type=DOUBLE"`
+	WindSpeed     float64 `csv:"Wind Speed" json:"wind_speed" msgpack:"wind_speed" parquet:"name=wind_speed, type=DOUBLE"`
+	Humidity      int32   `csv:"Humidity" json:"humidity" msgpack:"humidity" parquet:"name=humidity, type=INT32"`
+	Pressure      int32   `csv:"Pressure" json:"pressure" msgpack:"pressure" parquet:"name=pressure, type=INT32"`
+	Precipitation float64 `csv:"Precipitation" json:"precipitation" msgpack:"precipitation" parquet:"name=precipitation, type=DOUBLE"`
+}
+
+// selectSortBy reads the SORT_BY env var naming the column the summary report is ordered by,
+//     the summary report is only written when it is set
+// Output:
+//     If success returns "city", a metric name such as "temp" or empty, and nil, otherwise an error
+func selectSortBy() (string, error) {
+	column := strings.ToLower(strings.TrimSpace(os.Getenv("SORT_BY")))
+	if column == "" || column == "city" {
+		return column, nil
+	}
+
+	reports := metricReports(Config{})
+	if _, ok := reports[column]; ok {
+		return column, nil
+	}
+
+	columns := []string{"city"}
+	for name := range reports {
+		columns = append(columns, name)
+	}
+	sort.Strings(columns)
+
+	return "", fmt.Errorf("unsupported SORT_BY! %s, expected one of %s", column, strings.Join(columns, ", "))
+}
+
+// selectOutputAll reads the OUTPUT_ALL env var adding a dump of every city to the reports,
+//     "only" writes the dump instead of the ranked reports
+// Output:
+//     If success returns whether to write the dump, whether to skip the ranked reports and nil, otherwise an error
+func selectOutputAll() (bool, bool, error) {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv("OUTPUT_ALL")))
+	if value == "" {
+		return false, false, nil
+	}
+
+	if value == "only" {
+		return true, true, nil
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, false, fmt.Errorf("unsupported OUTPUT_ALL! %s", value)
+	}
+
+	return enabled, false, nil
+}
+
+// summaryRows lists every city with all of its metrics ordered by the SORT_BY column
+// Inputs:
+//     weatherList: list of Weather structs to summarize
+//     sortBy: "city" or the metric name to order by
+//     reports: table of MetricReport providing the metric values
+//     windUnit: WIND_UNIT the wind speeds are converted into
+//     order: "asc" or "desc" from selectSortOrders, empty for descending metrics and alphabetical cities
+// Output:
+//     list of SummaryOutput, ties broken alphabetically by city name
+func summaryRows(weatherList []Weather, sortBy string, reports map[string]MetricReport, windUnit string, order string) []SummaryOutput {
+	sorted := make([]Weather, len(weatherList))
+	copy(sorted, weatherList)
+
+	if sortBy == "city" {
+		sort.SliceStable(sorted, func(i, j int) bool {
+			if order == "desc" {
+				return cityName(sorted[i]) > cityName(sorted[j])
+			}
+			return cityName(sorted[i]) < cityName(sorted[j])
+		})
+	} else {
+		value := reports[sortBy].Value
+		sort.SliceStable(sorted, func(i, j int) bool {
+			a, b := value(sorted[i]), value(sorted[j])
+			if a != b {
+				if order == "asc" {
+					return a < b
+				}
+				return a > b
+			}
+			return cityName(sorted[i]) < cityName(sorted[j])
+		})
+	}
+
+	precipitation := reports["precipitation"].Value
+
+	rows := make([]SummaryOutput, len(sorted))
+	for i, city := range sorted {
+		rows[i] = SummaryOutput{
+			City:          cityName(city),
+			Lat:           city.Coord.Lat,
+			Lon:           city.Coord.Lon,
+			Temperature:   city.Main.Temp,
+			WindSpeed:     convertWindSpeed(city.Wind.Speed, windUnit),
+			Humidity:      int32(city.Main.Humidity),
+			Pressure:      int32(city.Main.Pressure),
+			Precipitation: precipitation(city),
+		}
+	}
+
+	return rows
+}
@@ -0,0 +1,237 @@
+package weather
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// summaryWeather builds cities whose metrics each rank them in a different order
+func summaryWeather() []Weather {
+	readings := []struct {
+		name     string
+		temp     float64
+		wind     float64
+		humidity int
+		pressure int
+	}{
+		{"Cairo", 31, 3, 20, 1009},
+		{"Oslo", 8, 11, 85, 1021},
+		{"Lima", 19, 6, 85, 1013},
+		{"Reykjavik", 4, 15, 70, 998},
+	}
+
+	weatherList := make([]Weather, len(readings))
+	for i, reading := range readings {
+		weatherList[i].Name = reading.name
+		weatherList[i].Main.Temp = reading.temp
+		weatherList[i].Wind.Speed = reading.wind
+		weatherList[i].Main.Humidity = reading.humidity
+		weatherList[i].Main.Pressure = reading.pressure
+	}
+
+	return weatherList
+}
+
+func TestSummaryRowsSortColumns(t *testing.T) {
+	tests := []struct {
+		sortBy string
+		order  string
+		want   []string
+	}{
+		{"temp", "", []string{"Cairo", "Lima", "Oslo", "Reykjavik"}},
+		{"temp", "asc", []string{"Reykjavik", "Oslo", "Lima", "Cairo"}},
+		{"wind", "", []string{"Reykjavik", "Oslo", "Lima", "Cairo"}},
+		{"pressure", "", []string{"Oslo", "Lima", "Cairo", "Reykjavik"}},
+		// Lima and Oslo tie on humidity and stay alphabetical either way
+		{"humidity", "", []string{"Lima", "Oslo", "Reykjavik", "Cairo"}},
+		{"humidity", "asc", []string{"Cairo", "Reykjavik", "Lima", "Oslo"}},
+		{"city", "", []string{"Cairo", "Lima", "Oslo", "Reykjavik"}},
+		{"city", "desc", []string{"Reykjavik", "Oslo", "Lima", "Cairo"}},
+	}
+
+	for _, tt := range tests {
+		rows := summaryRows(summaryWeather(), tt.sortBy, metricReports(Config{WindUnit: "m/s"}), "m/s", tt.order)
+
+		got := make([]string, len(rows))
+		for i, row := range rows {
+			got[i] = row.City
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SORT_BY %s %s: got %v, want %v", tt.sortBy, tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestSelectSortBy(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"city", "city", false},
+		{"TEMP", "temp", false},
+		{" wind ", "wind", false},
+		{"humidity", "humidity", false},
+		{"precipitation", "precipitation", false},
+		{"visibility", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("SORT_BY", tt.env)
+
+		got, err := selectSortBy()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SORT_BY %q: got error %v, want error %v", tt.env, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("SORT_BY %q: got %q, want %q", tt.env, got, tt.want)
+		}
+	}
+
+	t.Setenv("SORT_BY", "visibility")
+	if _, err := selectSortBy(); err == nil || !strings.Contains(err.Error(), "city, humidity") {
+		t.Errorf("got error %v, want it to list the supported columns", err)
+	}
+}
+
+func TestProcessSummarySortBy(t *testing.T) {
+	tests := []struct {
+		sortBy    string
+		sortOrder string
+		want      []string
+	}{
+		{"humidity", "", nil},
+		{"city", "", []string{"Cairo", "Lima", "Oslo"}},
+		{"city", "desc", []string{"Oslo", "Lima", "Cairo"}},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("SORT_BY", tt.sortBy)
+		t.Setenv("SORT_ORDER", tt.sortOrder)
+
+		client := newFakeS3()
+		client.objects["input/cities.csv"] = []byte("Lima\nCairo\nOslo\n")
+
+		provider := &countingProvider{calls: map[string]int{}}
+		p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+			logger: log.New(io.Discard, "", 0)}
+
+		p.config = testConfig(t)
+		if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		summary, ok := client.objects["output/summary.csv"]
+		if !ok {
+			t.Fatalf("SORT_BY %s: got no summary report", tt.sortBy)
+		}
+
+		records, err := csv.NewReader(bytes.NewReader(summary)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(records) != 4 || records[0][0] != "City" {
+			t.Fatalf("SORT_BY %s: got summary %q, want a header and a row per city", tt.sortBy, summary)
+		}
+
+		if tt.want == nil {
+			continue
+		}
+
+		for i, city := range tt.want {
+			if records[i+1][0] != city {
+				t.Errorf("SORT_BY %s SORT_ORDER %q: got row %d %s, want %s", tt.sortBy, tt.sortOrder, i+1, records[i+1][0], city)
+			}
+		}
+	}
+}
+
+func TestProcessOutputAll(t *testing.T) {
+	tests := []struct {
+		outputAll  string
+		wantRanked bool
+	}{
+		{"true", true},
+		{"only", false},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("OUTPUT_ALL", tt.outputAll)
+
+		client := newFakeS3()
+		client.objects["input/cities.csv"] = []byte("Lima\nAuckland\nCairo\nBarcelona\nSydney\nBeijing\n")
+
+		// countingProvider reports the length of the city name as its temperature
+		provider := &countingProvider{calls: map[string]int{}}
+		p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+			logger: log.New(io.Discard, "", 0)}
+
+		p.config = testConfig(t)
+		if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		records, err := csv.NewReader(bytes.NewReader(client.objects["output/all_weather.csv"])).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(records) == 0 {
+			t.Fatalf("OUTPUT_ALL %s: got no all_weather.csv", tt.outputAll)
+		}
+
+		got := make([]string, 0, len(records))
+		for _, record := range records[1:] {
+			got = append(got, record[0])
+		}
+
+		// Every city is dumped, not just the top of the ranking, hottest first
+		want := []string{"Barcelona", "Auckland", "Beijing", "Sydney", "Cairo", "Lima"}
+		if records[0][0] != "City" || !reflect.DeepEqual(got, want) {
+			t.Errorf("OUTPUT_ALL %s: got header %v and cities %v, want %v", tt.outputAll, records[0], got, want)
+		}
+
+		if _, ok := client.objects["output/highest_temperatures.csv"]; ok != tt.wantRanked {
+			t.Errorf("OUTPUT_ALL %s: got ranked report written %v, want %v", tt.outputAll, ok, tt.wantRanked)
+		}
+	}
+}
+
+func TestSelectOutputAll(t *testing.T) {
+	tests := []struct {
+		env      string
+		wantAll  bool
+		wantOnly bool
+		wantErr  bool
+	}{
+		{"", false, false, false},
+		{"true", true, false, false},
+		{"FALSE", false, false, false},
+		{"1", true, false, false},
+		{" Only ", true, true, false},
+		{"everything", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("OUTPUT_ALL", tt.env)
+
+		all, only, err := selectOutputAll()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("OUTPUT_ALL %q: got error %v, want error %v", tt.env, err, tt.wantErr)
+			continue
+		}
+		if all != tt.wantAll || only != tt.wantOnly {
+			t.Errorf("OUTPUT_ALL %q: got all %v only %v, want all %v only %v", tt.env, all, only, tt.wantAll, tt.wantOnly)
+		}
+	}
+}
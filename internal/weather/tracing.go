@@ -0,0 +1,52 @@
+package weather
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// instrumentAWSConfig registers X-Ray middleware on the AWS config so every
+//     client built from it records its operations as subsegments
+// Inputs:
+//     cfg: AWS config to instrument
+//     enabled: XRAY_ENABLED, the config is left untouched when false
+func instrumentAWSConfig(cfg *aws.Config, enabled bool) {
+	if !enabled {
+		return
+	}
+
+	awsv2.AWSV2Instrumentor(&cfg.APIOptions)
+}
+
+// instrumentHTTPClient wraps the client transport with X-Ray tracing when enabled
+// Inputs:
+//     client: http client to wrap
+//     enabled: XRAY_ENABLED
+// Output:
+//     The traced client if enabled, otherwise the client unchanged
+func instrumentHTTPClient(client *http.Client, enabled bool) *http.Client {
+	if !enabled {
+		return client
+	}
+
+	return xray.Client(client)
+}
+
+// traceSegment runs fn inside a named X-Ray subsegment when tracing is enabled
+// Inputs:
+//     ctx: context carrying the parent segment
+//     name: name of the subsegment shown in the trace map
+//     fn: function to run, receiving the subsegment context
+// Output:
+//     The error returned by fn
+func (p *Processor) traceSegment(ctx context.Context, name string, fn func(context.Context) error) error {
+	if !p.config.XRay {
+		return fn(ctx)
+	}
+
+	return xray.Capture(ctx, name, fn)
+}
@@ -0,0 +1,52 @@
+package weather
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// selectWindUnit reads the WIND_UNIT env var naming the unit wind speeds are reported in,
+//     the api always returns metres per second in metric units
+// Output:
+//     If success returns "m/s", "km/h" or "mph" and nil, otherwise an error
+func selectWindUnit() (string, error) {
+	unit := strings.ToLower(strings.TrimSpace(os.Getenv("WIND_UNIT")))
+
+	switch unit {
+	case "", "m/s":
+		return "m/s", nil
+	case "km/h", "mph":
+		return unit, nil
+	default:
+		return "", fmt.Errorf("unsupported WIND_UNIT! %s", unit)
+	}
+}
+
+// convertWindSpeed converts a wind speed from metres per second into the given unit
+// Inputs:
+//     speed: wind speed in metres per second
+//     unit: "km/h" or "mph", any other unit leaves the speed in metres per second
+// Output:
+//     the converted wind speed
+func convertWindSpeed(speed float64, unit string) float64 {
+	switch unit {
+	case "km/h":
+		return metresPerSecondToKilometresPerHour(speed)
+	case "mph":
+		return metresPerSecondToMilesPerHour(speed)
+	default:
+		return speed
+	}
+}
+
+// metresPerSecondToKilometresPerHour converts a speed in metres per second into kilometres per hour
+func metresPerSecondToKilometresPerHour(speed float64) float64 {
+	return speed * 3.6
+}
+
+// metresPerSecondToMilesPerHour converts a speed in metres per second into miles per hour
+func metresPerSecondToMilesPerHour(speed float64) float64 {
+	// A mile is exactly 1609.344 metres
+	return speed * 3600 / 1609.344
+}
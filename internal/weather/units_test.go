@@ -0,0 +1,71 @@
+package weather
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConvertWindSpeed(t *testing.T) {
+	tests := []struct {
+		speed float64
+		unit  string
+		want  float64
+	}{
+		{10, "m/s", 10},
+		{10, "", 10},
+		{10, "km/h", 36},
+		{10, "mph", 22.369363},
+		{0, "mph", 0},
+		{1609.344, "mph", 3600},
+		{2.5, "km/h", 9},
+	}
+
+	for _, tt := range tests {
+		if got := convertWindSpeed(tt.speed, tt.unit); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("convertWindSpeed(%v, %q) = %v, want %v", tt.speed, tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestSelectWindUnit(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"", "m/s", false},
+		{"m/s", "m/s", false},
+		{"KM/H", "km/h", false},
+		{" mph ", "mph", false},
+		{"knots", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("WIND_UNIT", tt.env)
+
+		got, err := selectWindUnit()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("selectWindUnit(%q) error = %v, wantErr %v", tt.env, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("selectWindUnit(%q) = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestExtractWeatherInfoConvertsWind(t *testing.T) {
+	var city Weather
+	city.Name = "Oslo"
+	city.Main.Temp = 4
+	city.Wind.Speed = 5
+
+	temperatures, winds := extractWeatherInfo([]Weather{city}, false, "km/h", false)
+
+	if len(winds) != 1 || winds[0].WindSpeed != 18 {
+		t.Fatalf("winds = %+v, want Oslo at 18 km/h", winds)
+	}
+	if len(temperatures) != 1 || temperatures[0].Temperature != 4 {
+		t.Errorf("temperatures = %+v, want Oslo unconverted at 4", temperatures)
+	}
+}
@@ -0,0 +1,2274 @@
+package weather
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/joho/godotenv"
+	"github.com/jszwec/csvutil"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/time/rate"
+)
+
+// S3PutObjectAPI defines the interface for the PutObject function.
+type S3PutObjectAPI interface {
+	PutObject(ctx context.Context,
+		params *s3.PutObjectInput,
+		optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// S3CopyObjectAPI defines the interface for the CopyObject function.
+type S3CopyObjectAPI interface {
+	CopyObject(ctx context.Context,
+		params *s3.CopyObjectInput,
+		optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+}
+
+// S3HeadBucketAPI defines the interface for the HeadBucket function.
+type S3HeadBucketAPI interface {
+	HeadBucket(ctx context.Context,
+		params *s3.HeadBucketInput,
+		optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+// S3DeleteObjectAPI defines the interface for the DeleteObject function.
+type S3DeleteObjectAPI interface {
+	DeleteObject(ctx context.Context,
+		params *s3.DeleteObjectInput,
+		optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// S3GetObjectAPI defines the interface for the GetObject function.
+type S3GetObjectAPI interface {
+	GetObject(ctx context.Context,
+		params *s3.GetObjectInput,
+		optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// S3API combines the S3 functions a Processor uses to read uploads, write reports and clean up.
+type S3API interface {
+	S3GetObjectAPI
+	S3OutputAPI
+	S3HeadBucketAPI
+}
+
+// Response defines the interface for the lambda response code and a message
+type Response struct {
+	StatusCode      string              `json:"statusCode"`
+	StatusMessage   string              `json:"statusMessage"`
+	TopTemperatures []TemperatureOutput `json:"topTemperatures,omitempty"`
+	TopWinds        []WindOutput        `json:"topWinds,omitempty"`
+	CountryFiltered []string            `json:"countryFilteredCities,omitempty"`
+	OutOfRange      []string            `json:"outOfRangeCities,omitempty"`
+	Filtered        []string            `json:"filtered,omitempty"`
+	RequestID       string              `json:"requestId,omitempty"`
+	Dependencies    map[string]string   `json:"dependencies,omitempty"`
+	DurationMs      int64               `json:"durationMs"`
+
+	// Errors maps each skipped city to why its lookup failed
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Weather defines the interface for the json object returned from the api
+type Weather struct {
+	ID      int         `json:"id"`
+	Name    string      `json:"name"`
+	Cod     json.Number `json:"cod"`
+	Message string      `json:"message"`
+	Coord   struct {
+		Lat float64 `json:"lat"`
+		Lon float64 `json:"lon"`
+	} `json:"coord"`
+	Main struct {
+		Temp      float64 `json:"temp"`
+		FeelsLike float64 `json:"feels_like"`
+		TempMin   float64 `json:"temp_min"`
+		TempMax   float64 `json:"temp_max"`
+		Pressure  int     `json:"pressure"`
+		Humidity  int     `json:"humidity"`
+	} `json:"main"`
+	Wind struct {
+		Speed   float64 `json:"speed"`
+		Degrees int     `json:"deg"`
+	} `json:"wind"`
+	Sys struct {
+		Country string `json:"country"`
+	} `json:"sys"`
+	Rain    *Precipitation `json:"rain"`
+	Snow    *Precipitation `json:"snow"`
+	Weather []struct {
+		Description string `json:"description"`
+	} `json:"weather"`
+
+	// InputName is the location as written in the input file when USE_INPUT_NAME is enabled, not returned by the api
+	InputName string `json:"-"`
+
+	// ForecastTemp is the next hour's forecast temperature from the One Call api, nil unless INCLUDE_FORECAST is set
+	ForecastTemp *float64 `json:"-"`
+
+	// AirQuality is looked up from the air pollution api, nil unless INCLUDE_AQI is set
+	AirQuality *AirQuality `json:"air_quality,omitempty"`
+}
+
+// Precipitation defines the interface for the rain and snow volumes returned from the api,
+// the api omits the object entirely when there was no precipitation
+type Precipitation struct {
+	OneHour float64 `json:"1h"`
+}
+
+// TemperatureOutput defines the interface for the csv temperature data
+type TemperatureOutput struct {
+	Rank        int     `csv:"Rank" json:"rank" msgpack:"rank" parquet:"name=rank, type=INT32"`
+	City        string  `csv:"City" json:"city" msgpack:"city" parquet:"name=city, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Lat         float64 `csv:"Lat" json:"lat" msgpack:"lat" parquet:"name=lat, type=DOUBLE"`
+	Lon         float64 `csv:"Lon" json:"lon" msgpack:"lon" parquet:"name=lon, type=DOUBLE"`
+	Temperature float64 `csv:"Temperature" json:"temperature" msgpack:"temperature" parquet:"name=temperature, type=DOUBLE"`
+	FeelsLike   float64 `csv:"Feels Like" json:"feels_like" msgpack:"feels_like" parquet:"name=feels_like, type=DOUBLE"`
+	TempMin     float64 `csv:"Temp Min" json:"temp_min" msgpack:"temp_min" parquet:"name=temp_min, type=DOUBLE"`
+	TempMax     float64 `csv:"Temp Max" json:"temp_max" msgpack:"temp_max" parquet:"name=temp_max, type=DOUBLE"`
+	Description string  `csv:"Description" json:"description" msgpack:"description" parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	// ForecastTemp is left empty unless INCLUDE_FORECAST is set
+	ForecastTemp *float64 `csv:"Forecast Temp,omitempty" json:"forecast_temp,omitempty" msgpack:"forecast_temp,omitempty" parquet:"name=forecast_temp, type=DOUBLE, repetitiontype=OPTIONAL"`
+}
+
+// WindOutput defines the interface for the csv wind speed data
+type WindOutput struct {
+	Rank      int     `csv:"Rank" json:"rank" msgpack:"rank" parquet:"name=rank, type=INT32"`
+	City      string  `csv:"City" json:"city" msgpack:"city" parquet:"name=city, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Lat       float64 `csv:"Lat" json:"lat" msgpack:"lat" parquet:"name=lat, type=DOUBLE"`
+	Lon       float64 `csv:"Lon" json:"lon" msgpack:"lon" parquet:"name=lon, type=DOUBLE"`
+	WindSpeed float64 `csv:"Wind Speed" json:"wind_speed" msgpack:"wind_speed" parquet:"name=wind_speed, type=DOUBLE"`
+	Direction string  `csv:"Direction" json:"direction" msgpack:"direction" parquet:"name=direction, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	// WindU and WindV are left empty unless WIND_VECTOR is set
+	WindU *float64 `csv:"Wind U,omitempty" json:"wind_u,omitempty" msgpack:"wind_u,omitempty" parquet:"name=wind_u, type=DOUBLE, repetitiontype=OPTIONAL"`
+	WindV *float64 `csv:"Wind V,omitempty" json:"wind_v,omitempty" msgpack:"wind_v,omitempty" parquet:"name=wind_v, type=DOUBLE, repetitiontype=OPTIONAL"`
+}
+
+// PrecipitationOutput defines the interface for the csv precipitation data,
+// nil volumes mean the api reported no precipitation rather than zero
+type PrecipitationOutput struct {
+	City string   `csv:"City" json:"city" msgpack:"city" parquet:"name=city, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Rain *float64 `csv:"Rain (mm/h)" json:"rain" msgpack:"rain" parquet:"name=rain, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Snow *float64 `csv:"Snow (mm/h)" json:"snow" msgpack:"snow" parquet:"name=snow, type=DOUBLE, repetitiontype=OPTIONAL"`
+}
+
+// HumidityOutput defines the interface for the csv humidity data
+type HumidityOutput struct {
+	City     string `csv:"City" json:"city" msgpack:"city" parquet:"name=city, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Humidity int32  `csv:"Humidity" json:"humidity" msgpack:"humidity" parquet:"name=humidity, type=INT32"`
+}
+
+// PressureOutput defines the interface for the csv pressure data
+type PressureOutput struct {
+	City     string `csv:"City" json:"city" msgpack:"city" parquet:"name=city, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Pressure int32  `csv:"Pressure" json:"pressure" msgpack:"pressure" parquet:"name=pressure, type=INT32"`
+}
+
+// OutputFormat defines how the ranked results are serialized before upload
+// and the ContentType stored on the uploaded S3 object
+type OutputFormat struct {
+	Extension   string
+	ContentType string
+	Marshal     func(v interface{}) ([]byte, error)
+	// Binary formats are not echoed to the logs
+	Binary bool
+}
+
+// outputFormats maps supported OUTPUT_FORMAT values to their serializers
+var outputFormats = map[string]OutputFormat{
+	"csv": {
+		Extension:   "csv",
+		ContentType: "text/csv",
+		Marshal:     csvutil.Marshal,
+	},
+	"json": {
+		Extension:   "json",
+		ContentType: "application/json",
+		Marshal:     json.Marshal,
+	},
+	"msgpack": {
+		Extension:   "msgpack",
+		ContentType: "application/x-msgpack",
+		Marshal:     msgpack.Marshal,
+		Binary:      true,
+	},
+	"parquet": {
+		Extension:   "parquet",
+		ContentType: "application/vnd.apache.parquet",
+		Marshal:     marshalParquet,
+		Binary:      true,
+	},
+	"ndjson": {
+		Extension:   "ndjson",
+		ContentType: "application/x-ndjson",
+		Marshal:     marshalNDJSON,
+	},
+}
+
+// zipCodePattern matches numeric-looking input rows such as 94040 or 94040-1234
+var zipCodePattern = regexp.MustCompile(`^[0-9]+(-[0-9]+)?$`)
+
+// countryCodePattern matches an ISO 3166 alpha-2 country code such as GB
+var countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// cityBufferSize bounds how many cities are read ahead of the fetch stage
+const cityBufferSize = 64
+
+// gzipMagic is the header every gzip stream starts with
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// utf8BOM is the byte order mark some editors write at the start of UTF-8 files
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// maxCityLength is the longest city token, in characters, sent to the api
+const maxCityLength = 100
+
+// weatherUnits is the unit system requested from the api
+const weatherUnits = "metric"
+
+// Processor holds the clients and configuration used to process a single upload file,
+//     one is constructed per invocation so concurrent runs never share state
+type Processor struct {
+	s3Client          S3API
+	snsClient         SNSPublishAPI
+	sqsClient         SQSSendMessageAPI
+	dynamoClient      DynamoWriteAPI
+	idempotencyClient DynamoIdempotencyAPI
+	cacheClient       DynamoCacheAPI
+	weatherProvider   WeatherProvider
+	metrics           MetricsRecorder
+	inputBucket       string
+	outputBucket      string
+	uploadKey         string
+	uploadETag        string
+	localInput        bool
+	requestID         string
+	eventTime         time.Time
+	startTime         time.Time
+	failedCities      []string
+	cityErrors        map[string]string
+	config            Config
+	stdout            *bufio.Writer
+	logger            *log.Logger
+}
+
+// LoadDotEnv loads the optional .env file named by DOTENV_PATH for local development,
+//     variables already set in the environment are never overridden
+// Output:
+//     If success or no file exists returns nil, otherwise an error
+func LoadDotEnv() error {
+	path := strings.TrimSpace(os.Getenv("DOTENV_PATH"))
+	if path == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := godotenv.Load(path); err != nil {
+		return fmt.Errorf("failed to load env file %s! %w", path, err)
+	}
+
+	return nil
+}
+
+// NewProcessor validates the bucket configuration and every tunable, and creates the api and aws clients
+//     used to process S3 uploads
+// Inputs:
+//     ctx: context of the invocation
+// Output:
+//     If success returns the Processor and nil, otherwise an error
+func NewProcessor(ctx context.Context) (*Processor, error) {
+	// Validate required bucket configuration before touching S3
+	inputBucket, err := requireEnv("INPUT_BUCKET")
+	if err != nil {
+		return nil, err
+	}
+
+	outputBucket, err := requireEnv("OUTPUT_BUCKET")
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Processor{inputBucket: inputBucket, outputBucket: outputBucket, config: config, logger: newLogger("")}
+
+	return p, p.configureClients(ctx)
+}
+
+// NewLocalProcessor validates every tunable and creates a Processor that reads cities from a local file
+//     and prints the reports instead of uploading them
+// Inputs:
+//     ctx: context of the run
+// Output:
+//     If success returns the Processor and nil, otherwise an error
+func NewLocalProcessor(ctx context.Context) (*Processor, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Processor{localInput: true, config: config, logger: newLogger("")}
+
+	return p, p.configureClients(ctx)
+}
+
+// newLogger creates a logger writing to the standard log output with an optional request id prefix
+// Inputs:
+//     requestID: id added to every line, empty for none
+// Output:
+//     The configured logger
+func newLogger(requestID string) *log.Logger {
+	prefix := ""
+	if requestID != "" {
+		prefix = "[" + requestID + "] "
+	}
+
+	return log.New(log.Writer(), prefix, log.Flags()|log.Lmsgprefix)
+}
+
+// SetRequestID tags every log line and Response produced by the Processor with the invocation's request id
+// Inputs:
+//     requestID: id of the invocation, such as the Lambda request id
+func (p *Processor) SetRequestID(requestID string) {
+	p.requestID = requestID
+	p.logger = newLogger(requestID)
+}
+
+// SetInputBucket overrides the INPUT_BUCKET the upload file is read from
+// Inputs:
+//     bucket: name of the bucket holding the upload file
+func (p *Processor) SetInputBucket(bucket string) {
+	p.inputBucket = bucket
+}
+
+// SetEventTime records when the upload happened, used to partition the report keys by date
+// Inputs:
+//     eventTime: time of the upload event, zero when unknown
+func (p *Processor) SetEventTime(eventTime time.Time) {
+	p.eventTime = eventTime
+}
+
+// SetStartTime records when the invocation started, so the duration posted to WEBHOOK_URL covers the whole invocation
+// Inputs:
+//     startTime: start of the invocation, zero to measure from the start of Process
+func (p *Processor) SetStartTime(startTime time.Time) {
+	p.startTime = startTime
+}
+
+// configureClients selects the weather provider and creates the aws service clients
+// Inputs:
+//     ctx: context of the invocation
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) configureClients(ctx context.Context) error {
+	var err error
+
+	// Metrics are inert until a run configures its backends
+	p.metrics = multiRecorder{}
+
+	if p.weatherProvider, err = selectWeatherProvider(p.config); err != nil {
+		return err
+	}
+
+	// Load the Shared AWS Configuration (~/.aws/config)
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Record S3 operations as X-Ray subsegments when tracing is enabled
+	instrumentAWSConfig(&cfg, p.config.XRay)
+
+	s3Retries, err := selectS3MaxRetries()
+	if err != nil {
+		return err
+	}
+
+	s3Endpoint, err := selectS3Endpoint()
+	if err != nil {
+		return err
+	}
+
+	// Create an Amazon S3 service client
+	p.s3Client = s3.NewFromConfig(cfg, s3ClientOptions(s3Retries, s3Endpoint))
+
+	// Create an Amazon SNS client for completion notifications
+	p.snsClient = sns.NewFromConfig(cfg)
+
+	// Create an Amazon SQS client for dead-lettering failed files
+	p.sqsClient = sqs.NewFromConfig(cfg)
+
+	// Create an Amazon DynamoDB client for persisting weather records and idempotency markers
+	dynamoDBClient := dynamodb.NewFromConfig(cfg)
+	p.dynamoClient = dynamoDBClient
+	p.idempotencyClient = dynamoDBClient
+	p.cacheClient = dynamoDBClient
+
+	return nil
+}
+
+// Process reads the cities in one input file, writes the ranked reports and returns the invocation Response
+// Inputs:
+//     ctx: context of the invocation
+//     key: s3 key of the upload file, or the file path for a local Processor
+//     etag: etag of the upload file used for idempotency, empty when unknown
+// Output:
+//     If success returns the Response and nil, otherwise a 400 Response and an error
+func (p *Processor) Process(ctx context.Context, key string, etag string) (Response, error) {
+	p.uploadKey = key
+	p.uploadETag = etag
+
+	if p.startTime.IsZero() {
+		p.startTime = time.Now()
+	}
+
+	p.metrics = newMetricsRecorder(p.config)
+	defer p.flushMetrics()
+
+	response := Response{RequestID: p.requestID}
+	err := p.processWeather(ctx, &response)
+
+	if err != nil {
+		// The file is parked for replay, a failure to do so is logged rather than masking the original error
+		if dlqErr := p.sendToDeadLetter(ctx, err); dlqErr != nil {
+			p.logger.Printf("%s", dlqErr)
+		}
+
+		failed := Response{StatusCode: "400", StatusMessage: fmt.Sprint("", err), RequestID: p.requestID, Errors: p.cityErrors}
+		failed.DurationMs = time.Since(p.startTime).Milliseconds()
+
+		// The run has already failed, so the webhook error is only logged
+		if webhookErr := p.notifyWebhook(ctx, failed); webhookErr != nil {
+			p.logger.Printf("%s", webhookErr)
+		}
+
+		return failed, err
+	}
+
+	response.StatusCode = "200"
+	response.Errors = p.cityErrors
+	if response.StatusMessage == "" {
+		response.StatusMessage = "Success"
+	}
+
+	// The webhook is posted before the caller can time the invocation, so the duration is measured here
+	response.DurationMs = time.Since(p.startTime).Milliseconds()
+
+	if err := p.notifyWebhook(ctx, response); err != nil {
+		return Response{StatusCode: "400", StatusMessage: fmt.Sprint("", err), RequestID: p.requestID, Errors: p.cityErrors}, err
+	}
+
+	return response, nil
+}
+
+// requireEnv reads a required environment variable
+// Inputs:
+//     name: name of the environment variable
+// Output:
+//     If set returns its value and nil, otherwise an error naming the missing variable
+func requireEnv(name string) (string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return "", fmt.Errorf("missing required environment variable! %s", name)
+	}
+
+	return value, nil
+}
+
+// s3ClientOptions configures the S3 client's retries and endpoint
+// Inputs:
+//     retries: S3_MAX_RETRIES, -1 to keep the SDK default, the first attempt is not counted as a retry
+//     endpoint: AWS_S3_ENDPOINT, empty to use AWS
+// Output:
+//     function applying both to the s3.Options the client is built with
+func s3ClientOptions(retries int, endpoint string) func(*s3.Options) {
+	return func(options *s3.Options) {
+		if retries >= 0 {
+			options.Retryer = retry.AddWithMaxAttempts(retry.NewStandard(), retries+1)
+		}
+
+		// LocalStack and MinIO serve every bucket from one host, so buckets go in the path
+		if endpoint != "" {
+			options.EndpointResolver = s3.EndpointResolverFromURL(endpoint)
+			options.UsePathStyle = true
+		}
+	}
+}
+
+// selectS3Endpoint reads the AWS_S3_ENDPOINT env var pointing the S3 client at a local service such as LocalStack
+// Output:
+//     If success returns the endpoint url, empty to use AWS, and nil, otherwise an error describing the malformed url
+func selectS3Endpoint() (string, error) {
+	endpoint := strings.TrimSpace(os.Getenv("AWS_S3_ENDPOINT"))
+	if endpoint == "" {
+		return "", nil
+	}
+
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid AWS_S3_ENDPOINT! %w", err)
+	}
+
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid AWS_S3_ENDPOINT! %s must be an absolute http(s) url", endpoint)
+	}
+
+	return strings.TrimSuffix(endpoint, "/"), nil
+}
+
+// selectWeatherBaseURL reads the OWM_BASE_URL env var used to call the weather api
+// Inputs:
+//     defaultURL: public OpenWeatherMap endpoint used when OWM_BASE_URL is unset
+// Output:
+//     If success returns the base url and nil, otherwise an error describing the malformed url
+func selectWeatherBaseURL(defaultURL string) (string, error) {
+	baseURL := strings.TrimSpace(os.Getenv("OWM_BASE_URL"))
+	if baseURL == "" {
+		return defaultURL, nil
+	}
+
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid OWM_BASE_URL! %w", err)
+	}
+
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid OWM_BASE_URL! %s must be an absolute http(s) url", baseURL)
+	}
+
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", fmt.Errorf("invalid OWM_BASE_URL! %s must not include a query or fragment", baseURL)
+	}
+
+	return strings.TrimSuffix(baseURL, "/"), nil
+}
+
+// envEnabled reports whether a boolean feature flag environment variable is set to true
+// Inputs:
+//     name: name of the environment variable
+// Output:
+//     true if the variable parses as a true boolean, otherwise false
+func envEnabled(name string) bool {
+	enabled, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(name)))
+	return err == nil && enabled
+}
+
+// dryRun reports whether DRY_RUN is enabled, in which case S3 writes and deletes are only logged,
+//     local runs are always dry runs
+func (p *Processor) dryRun() bool {
+	return p.localInput || p.config.DryRun
+}
+
+// processWeather calls relevant functions to process weather data
+// Inputs:
+//     ctx: context of the invocation
+//     response: Response to populate with the computed top cities
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) processWeather(ctx context.Context, response *Response) error {
+	config := p.config
+
+	defer p.flushOutput()
+
+	// Reports written to the input bucket would otherwise trigger another run on their own output
+	if !p.localInput && p.writesToBucket(p.inputBucket) && isOutputKey(p.uploadKey, config.OutputPrefix) {
+		p.logger.Printf("skipping %s! key is an output of this function", p.uploadKey)
+		response.StatusMessage = "input file is an output report"
+		return nil
+	}
+
+	// Archived uploads land back in the input bucket and would otherwise be processed again
+	if config.CleanupMode == "archive" && strings.HasPrefix(p.uploadKey, config.ArchivePrefix) {
+		response.StatusMessage = "input file is an archived upload"
+		return nil
+	}
+
+	partition := p.partition()
+
+	processed, err := p.alreadyProcessed(ctx)
+	if err != nil {
+		return err
+	}
+
+	if processed {
+		response.StatusMessage = "input file already processed"
+		return nil
+	}
+
+	if err := p.loadGeocodeCache(ctx); err != nil {
+		return err
+	}
+
+	// Cities are streamed from the input file into the fetch stage through a bounded buffer
+	// so the intermediate city list never has to be held in memory
+	streamCtx, cancelStream := context.WithCancel(ctx)
+	defer cancelStream()
+
+	cities := make(chan string, cityBufferSize)
+	extractErr := make(chan error, 1)
+
+	// Only read once extractErr has been received, after the extract goroutine is done with it
+	filtered := make([]string, 0)
+
+	go func() {
+		defer close(cities)
+		extractErr <- p.extractCities(streamCtx, cities, &filtered)
+	}()
+
+	weatherList := make([]Weather, 0)
+
+	// Cities already fetched in this invocation are reused instead of re-queried, the cache only
+	// holds each city's index in weatherList so large inputs do not keep a second copy of every Weather
+	weatherCache := make(map[string]int)
+
+	err = p.populateWeatherList(ctx, cities, weatherCache, config, &weatherList)
+
+	if err != nil {
+		return err
+	}
+
+	if err := <-extractErr; err != nil {
+		return err
+	}
+
+	response.Filtered = filtered
+
+	// Outputs are skipped entirely rather than written from a handful of cities during an api outage
+	if err := checkMinSuccess(config.MinCount, config.MinPercent, len(weatherList), len(p.failedCities)); err != nil {
+		return err
+	}
+
+	if len(weatherList) == 0 && len(p.failedCities) > 0 {
+		return fmt.Errorf("all %d cities failed! %s", len(p.failedCities), strings.Join(p.failedCities, ", "))
+	}
+
+	if len(weatherList) == 0 {
+		response.StatusMessage = "no cities found in input file"
+		return nil
+	}
+
+	// The run metadata summarizes every city fetched, including those the filters below drop
+	stats := summarizeWeather(weatherList, config.WindUnit)
+
+	weatherList, response.CountryFiltered = filterByCountry(weatherList, config.FilterCountry)
+
+	if len(weatherList) == 0 {
+		response.StatusMessage = "no cities matched FILTER_COUNTRY"
+		return nil
+	}
+
+	weatherList, response.OutOfRange = filterByTemperature(weatherList, config.MinTemp, config.MaxTemp)
+
+	if len(weatherList) == 0 {
+		response.StatusMessage = "no cities within MIN_TEMP and MAX_TEMP"
+		return nil
+	}
+
+	// A single unnamed group keeps the original report keys
+	groups := map[string][]Weather{"": weatherList}
+	if config.GroupByCountry {
+		groups = groupByCountry(weatherList)
+	}
+
+	reports := metricReports(config)
+
+	reportFiles := make([]ReportFile, 0)
+	if !config.OutputAllOnly {
+		reportFiles = planReports(groups, reports, config, partition)
+	}
+
+	// The dump follows SORT_BY when set, otherwise the hottest cities come first
+	if config.OutputAll {
+		sortBy := config.SortBy
+		if sortBy == "" {
+			sortBy = "temp"
+		}
+
+		reportFiles = append(reportFiles, ReportFile{
+			Key:  config.outputKey(allWeatherName, "", partition),
+			Rows: summaryRows(weatherList, sortBy, reports, config.WindUnit, config.SortOrders[sortBy]),
+		})
+	}
+
+	// The summary report covers every city across groups
+	if config.SortBy != "" {
+		reportFiles = append(reportFiles, ReportFile{
+			Key:  config.outputKey(summaryName, "", partition),
+			Rows: summaryRows(weatherList, config.SortBy, reports, config.WindUnit, config.SortOrders[config.SortBy]),
+		})
+	}
+
+	reportFiles = paginateReports(reportFiles, config.MaxRowsPerFile, config.Format)
+
+	keys := make([]string, 0, len(reportFiles))
+	for _, report := range reportFiles {
+		keys = append(keys, report.Key)
+	}
+
+	manifestKey := p.manifestKey(partition)
+
+	// Every object this run writes is checked, not only the reports
+	written := make([]string, 0, len(keys)+2)
+	written = append(written, keys...)
+	written = append(written, manifestKey)
+	if config.History {
+		written = append(written, config.historyKey())
+	}
+
+	if err := checkOutputKeys(written); err != nil {
+		return err
+	}
+
+	for _, report := range reportFiles {
+		err = p.writeReport(ctx, report, config.Format)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = p.writeManifest(ctx, manifestKey, weatherList, stats, keys)
+	if err != nil {
+		return err
+	}
+
+	err = p.appendHistory(ctx, weatherList, config.WindUnit)
+	if err != nil {
+		return err
+	}
+
+	response.TopTemperatures, response.TopWinds = extractWeatherInfo(weatherList, false, config.WindUnit, config.WindVector)
+	applySortOrder(response.TopTemperatures, false, config.SortOrders["temp"])
+	applySortOrder(response.TopWinds, false, config.SortOrders["wind"])
+	roundRows(response.TopTemperatures, config.FloatPrecision)
+	roundRows(response.TopWinds, config.FloatPrecision)
+
+	err = p.writeToDynamo(ctx, weatherList)
+	if err != nil {
+		return err
+	}
+
+	err = p.markProcessed(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = p.runCleanup(ctx, config.CleanupMode)
+	if err != nil {
+		return err
+	}
+
+	err = p.notifyCompletion(ctx, weatherList, keys)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// selectOutputFormat picks the serializer named by the OUTPUT_FORMAT env var, defaulting to csv
+// Output:
+//     If success returns the selected OutputFormat and nil, otherwise an error
+func selectOutputFormat() (OutputFormat, error) {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("OUTPUT_FORMAT")))
+	if name == "" {
+		name = "csv"
+	}
+
+	format, ok := outputFormats[name]
+	if !ok {
+		return OutputFormat{}, fmt.Errorf("unsupported output format! %s", name)
+	}
+
+	if name != "csv" {
+		return format, nil
+	}
+
+	delimiter, err := selectDelimiter()
+	if err != nil {
+		return OutputFormat{}, err
+	}
+
+	header, err := selectOutputHeader()
+	if err != nil {
+		return OutputFormat{}, err
+	}
+
+	titles, err := selectColumnTitles()
+	if err != nil {
+		return OutputFormat{}, err
+	}
+
+	if delimiter != ',' || !header || len(titles) > 0 {
+		format.Marshal = marshalDelimited(delimiter, header, titles)
+	}
+
+	if delimiter == '\t' {
+		format.Extension = "tsv"
+		format.ContentType = "text/tab-separated-values"
+	}
+
+	return format, nil
+}
+
+// selectDelimiter reads the OUTPUT_DELIMITER env var used to separate csv fields, defaulting to a comma
+// Output:
+//     If success returns the delimiter rune and nil, otherwise an error
+func selectDelimiter() (rune, error) {
+	value := os.Getenv("OUTPUT_DELIMITER")
+
+	// Allow tabs to be configured without embedding a literal tab character
+	switch strings.ToLower(value) {
+	case "":
+		return ',', nil
+	case "\\t", "tab":
+		return '\t', nil
+	}
+
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, fmt.Errorf("output delimiter must be a single character! %q", value)
+	}
+
+	delimiter, _ := utf8.DecodeRuneInString(value)
+	if delimiter == '"' || delimiter == '\r' || delimiter == '\n' || delimiter == utf8.RuneError {
+		return 0, fmt.Errorf("invalid output delimiter! %q", value)
+	}
+
+	return delimiter, nil
+}
+
+// selectOutputHeader reads the OUTPUT_HEADER env var controlling whether csv reports start with a header row
+// Output:
+//     If success returns whether to write the header row, defaulting to true, and nil, otherwise an error
+func selectOutputHeader() (bool, error) {
+	value := strings.TrimSpace(os.Getenv("OUTPUT_HEADER"))
+	if value == "" {
+		return true, nil
+	}
+
+	header, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid OUTPUT_HEADER! %q", value)
+	}
+
+	return header, nil
+}
+
+// selectColumnTitles reads the OUTPUT_COLUMN_TITLES env var renaming csv header columns,
+//     given as comma separated pairs of csv tag and title such as "Temperature=Temp C,City=Location"
+// Output:
+//     If success returns a map of csv tag to the title written in its place and nil, otherwise an error
+func selectColumnTitles() (map[string]string, error) {
+	titles := make(map[string]string)
+
+	value := strings.TrimSpace(os.Getenv("OUTPUT_COLUMN_TITLES"))
+	if value == "" {
+		return titles, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid OUTPUT_COLUMN_TITLES entry! %q", pair)
+		}
+
+		titles[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return titles, nil
+}
+
+// marshalNDJSON writes each element of a slice as a json object on its own line
+// Inputs:
+//     v: slice of output structs such as []TemperatureOutput
+// Output:
+//     If success returns the newline delimited json and nil, otherwise an error
+func marshalNDJSON(v interface{}) ([]byte, error) {
+	rows := reflect.ValueOf(v)
+	if rows.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("ndjson output requires a slice! got %T", v)
+	}
+
+	buffer := bytes.Buffer{}
+	encoder := json.NewEncoder(&buffer)
+
+	// Encode terminates every object with a newline
+	for i := 0; i < rows.Len(); i++ {
+		if err := encoder.Encode(rows.Index(i).Interface()); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// marshalDelimited builds a csv marshaller that separates fields with the given delimiter
+// Inputs:
+//     delimiter: rune written between fields
+//     header: whether to write a header row before the data rows
+//     titles: map of csv tag to the title written in its place in the header row
+// Output:
+//     function marshalling a slice of structs into delimited text
+func marshalDelimited(delimiter rune, header bool, titles map[string]string) func(v interface{}) ([]byte, error) {
+	return func(v interface{}) ([]byte, error) {
+		var buf bytes.Buffer
+
+		writer := csv.NewWriter(&buf)
+		writer.Comma = delimiter
+
+		// The header is written here rather than by csvutil so columns can be renamed
+		if header {
+			columns, err := csvutil.Header(reflect.Zero(reflect.TypeOf(v).Elem()).Interface(), "csv")
+			if err != nil {
+				return nil, err
+			}
+
+			for i, column := range columns {
+				if title, ok := titles[column]; ok {
+					columns[i] = title
+				}
+			}
+
+			if err := writer.Write(columns); err != nil {
+				return nil, err
+			}
+		}
+
+		encoder := csvutil.NewEncoder(writer)
+		encoder.AutoHeader = false
+
+		if err := encoder.Encode(v); err != nil {
+			return nil, err
+		}
+
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+}
+
+// selectReportDirection reads the REPORT_DIRECTION env var controlling which temperature reports are written
+// Output:
+//     If success returns whether to write the highest and lowest temperature reports and nil,
+//     otherwise an error
+func selectReportDirection() (bool, bool, error) {
+	direction := strings.ToLower(strings.TrimSpace(os.Getenv("REPORT_DIRECTION")))
+
+	switch direction {
+	case "", "highest":
+		return true, false, nil
+	case "lowest":
+		return false, true, nil
+	case "both":
+		return true, true, nil
+	default:
+		return false, false, fmt.Errorf("unsupported report direction! %s", direction)
+	}
+}
+
+// selectRankMode reads the RANK_MODE env var used to order the precipitation report
+// Output:
+//     If success returns "rain", "snow" or "" for combined precipitation and nil, otherwise an error
+func selectRankMode() (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("RANK_MODE")))
+
+	switch mode {
+	case "", "rain", "snow":
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported rank mode! %s", mode)
+	}
+}
+
+// outputKey builds the object key for a report, inserting the group name when grouped
+//     and prepending the configured OUTPUT_PREFIX
+// Inputs:
+//     report: base name of the report file
+//     group: name of the group the report covers, empty when ungrouped
+//     partition: date partition from Processor.partition, empty when unpartitioned
+// Output:
+//     The object key, e.g. prod/reports/highest_temperatures.GB.csv, or with a partition
+//     Hive style directories <prefix><report>/dt=<yyyy-mm-dd>[/country=<group>]/part.<ext>,
+//     e.g. prod/reports/highest_temperatures/dt=2024-06-01/country=GB/part.csv
+func (config Config) outputKey(report string, group string, partition string) string {
+	if partition != "" {
+		key := config.OutputPrefix + report + "/dt=" + partition + "/"
+		if group != "" {
+			key += "country=" + group + "/"
+		}
+
+		return key + "part." + config.Format.Extension
+	}
+
+	if group == "" {
+		return config.OutputPrefix + report + "." + config.Format.Extension
+	}
+
+	return config.OutputPrefix + report + "." + group + "." + config.Format.Extension
+}
+
+// selectPartition reads the PARTITION_OUTPUT env var controlling Hive style partitioned report keys
+// Output:
+//     If success returns "date" or empty when unset, and nil, otherwise an error
+func selectPartition() (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("PARTITION_OUTPUT")))
+
+	switch mode {
+	case "", "date":
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported output partitioning! %s", mode)
+	}
+}
+
+// partition dates the report keys when PARTITION_OUTPUT is set
+// Output:
+//     the UTC date of the upload event, or of the run when the event time is unknown, as yyyy-mm-dd,
+//     or empty when unpartitioned
+func (p *Processor) partition() string {
+	if p.config.Partition != "date" {
+		return ""
+	}
+
+	date := p.eventTime
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	return date.UTC().Format("2006-01-02")
+}
+
+// selectOutputPrefix reads the OUTPUT_PREFIX env var, normalized so prod/reports and prod/reports/ match
+// Output:
+//     The prefix ending in a single slash, or empty when unset
+func selectOutputPrefix() string {
+	prefix := strings.Trim(strings.TrimSpace(os.Getenv("OUTPUT_PREFIX")), "/")
+	if prefix == "" {
+		return ""
+	}
+
+	return prefix + "/"
+}
+
+// groupByCountry splits weather results by the country code returned from the api
+// Inputs:
+//     weatherList: list of Weather structs to group
+// Output:
+//     map of country code to the Weather structs in that country,
+//     cities without a country are grouped under "unknown"
+func groupByCountry(weatherList []Weather) map[string][]Weather {
+	groups := make(map[string][]Weather)
+
+	for _, city := range weatherList {
+		country := strings.TrimSpace(city.Sys.Country)
+		if country == "" {
+			country = "unknown"
+		}
+
+		groups[country] = append(groups[country], city)
+	}
+
+	return groups
+}
+
+// selectFilterCountry reads the FILTER_COUNTRY env var naming the single country whose cities are reported
+// Output:
+//     If success returns the upper case ISO 3166 alpha-2 code, empty to keep every city, and nil,
+//     otherwise an error
+func selectFilterCountry() (string, error) {
+	country := strings.ToUpper(strings.TrimSpace(os.Getenv("FILTER_COUNTRY")))
+	if country == "" {
+		return "", nil
+	}
+
+	if !countryCodePattern.MatchString(country) {
+		return "", fmt.Errorf("invalid FILTER_COUNTRY! %s must be a single two letter ISO country code", country)
+	}
+
+	return country, nil
+}
+
+// filterByCountry drops cities whose api returned country does not match the requested ISO code
+// Inputs:
+//     weatherList: list of Weather structs to filter
+//     country: ISO country code to keep, an empty code keeps every city
+// Output:
+//     []Weather: cities in the requested country
+//     []string: names of the cities that were dropped
+func filterByCountry(weatherList []Weather, country string) ([]Weather, []string) {
+	country = strings.TrimSpace(country)
+	if country == "" {
+		return weatherList, nil
+	}
+
+	kept := make([]Weather, 0, len(weatherList))
+	filtered := make([]string, 0)
+
+	for _, city := range weatherList {
+		if strings.EqualFold(strings.TrimSpace(city.Sys.Country), country) {
+			kept = append(kept, city)
+		} else {
+			filtered = append(filtered, cityName(city))
+		}
+	}
+
+	return kept, filtered
+}
+
+// selectTemperatureRange reads the MIN_TEMP and MAX_TEMP env vars bounding which cities are reported, in °C
+// Output:
+//     If success returns the lower and upper bounds, nil when unset, and nil, otherwise an error
+func selectTemperatureRange() (*float64, *float64, error) {
+	bounds := make([]*float64, 0, 2)
+
+	for _, name := range []string{"MIN_TEMP", "MAX_TEMP"} {
+		value := strings.TrimSpace(os.Getenv(name))
+		if value == "" {
+			bounds = append(bounds, nil)
+			continue
+		}
+
+		bound, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s! %s must be a number", name, value)
+		}
+		bounds = append(bounds, &bound)
+	}
+
+	if bounds[0] != nil && bounds[1] != nil && *bounds[0] > *bounds[1] {
+		return nil, nil, fmt.Errorf("invalid MIN_TEMP! %g is above MAX_TEMP %g", *bounds[0], *bounds[1])
+	}
+
+	return bounds[0], bounds[1], nil
+}
+
+// filterByTemperature drops cities outside the requested temperature range before they are ranked
+// Inputs:
+//     weatherList: list of Weather structs to filter
+//     minTemp: lowest temperature kept, inclusive, nil for no lower bound
+//     maxTemp: highest temperature kept, inclusive, nil for no upper bound
+// Output:
+//     []Weather: cities within the range
+//     []string: names of the cities that were dropped
+func filterByTemperature(weatherList []Weather, minTemp *float64, maxTemp *float64) ([]Weather, []string) {
+	if minTemp == nil && maxTemp == nil {
+		return weatherList, nil
+	}
+
+	kept := make([]Weather, 0, len(weatherList))
+	dropped := make([]string, 0)
+
+	for _, city := range weatherList {
+		temperature := temperatureValue(city)
+		if (minTemp != nil && temperature < *minTemp) || (maxTemp != nil && temperature > *maxTemp) {
+			dropped = append(dropped, cityName(city))
+			continue
+		}
+		kept = append(kept, city)
+	}
+
+	return kept, dropped
+}
+
+// checkOutputKeys ensures no two enabled writers target the same output key
+// Inputs:
+//     keys: list of output keys computed for every enabled writer
+// Output:
+//     If success returns nil, otherwise an error listing the colliding keys
+func checkOutputKeys(keys []string) error {
+	seen := make(map[string]bool, len(keys))
+	collisions := make([]string, 0)
+
+	for _, key := range keys {
+		if seen[key] {
+			collisions = append(collisions, key)
+			continue
+		}
+		seen[key] = true
+	}
+
+	if len(collisions) > 0 {
+		return fmt.Errorf("duplicate output keys! %s", strings.Join(collisions, ", "))
+	}
+
+	return nil
+}
+
+// extractCities opens uploaded file, extracts city names and sends them on the cities channel,
+//     when INPUT_IS_MANIFEST is set the uploaded file lists the input files to read instead
+// Inputs:
+//     ctx: context of the invocation, cancelled when the consumer stops reading
+//	   cities: channel to send each city name on
+//     filtered: list the cities removed by CITY_ALLOWLIST or CITY_DENYLIST are appended to
+// Output:
+//     If success returns nil, otherwise an error matching ErrInputRead
+func (p *Processor) extractCities(ctx context.Context, cities chan<- string, filtered *[]string) error {
+	config := p.config
+	count := 0
+
+	emit := func(token string) error {
+		city, ok := sanitizeCity(token)
+		if !ok {
+			p.logger.Printf("skipping invalid city token %q", token)
+			return nil
+		}
+
+		if !cityAllowed(city, config.CityAllowlist, config.CityDenylist) {
+			*filtered = append(*filtered, city)
+			return nil
+		}
+
+		// An accidentally huge input would otherwise use up the api quota
+		if config.MaxCities > 0 && count >= config.MaxCities {
+			if config.TruncateCities {
+				return errCityLimit
+			}
+			return fmt.Errorf("input has more than %d cities! MAX_CITIES %w", config.MaxCities, ErrSizeLimit)
+		}
+		count++
+
+		// Zip codes carry their country so they can be routed through the zip query
+		if zipCodePattern.MatchString(city) && !config.isCityID(city) {
+			city = city + "," + config.ZipCountry
+		}
+
+		select {
+		case cities <- city:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	keys := []string{p.uploadKey}
+	if config.InputIsManifest {
+		var err error
+		keys, err = p.readManifest(ctx, config.MaxInputBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		err := p.readInputFile(ctx, key, config.MaxInputBytes, emit)
+		if errors.Is(err, errCityLimit) {
+			p.logger.Printf("input truncated to the first %d cities! MAX_CITIES_BEHAVIOR is truncate", config.MaxCities)
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readManifest reads the uploaded file as a newline delimited list of input file keys
+// Inputs:
+//     ctx: context of the invocation
+//     maxBytes: most bytes read from the manifest
+// Output:
+//     If success returns the listed keys in order without blanks or duplicates and nil,
+//     otherwise an error matching ErrInputRead
+func (p *Processor) readManifest(ctx context.Context, maxBytes int64) ([]string, error) {
+	body, err := p.openInput(ctx, p.uploadKey)
+	if err != nil {
+		return nil, withKind(ErrInputRead, fmt.Errorf("failed to read manifest! %w", err))
+	}
+
+	defer body.Close()
+
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(newLimitedReader(body, maxBytes, "manifest"))
+	for scanner.Scan() {
+		key := strings.TrimSpace(scanner.Text())
+
+		// A manifest listing itself would otherwise be read as a city file
+		if key == "" || key == p.uploadKey || seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		keys = append(keys, key)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, withKind(ErrInputRead, fmt.Errorf("failed to read manifest! %w", err))
+	}
+
+	return keys, nil
+}
+
+// readInputFile opens a single input file and passes each city token it contains to emit
+// Inputs:
+//     ctx: context of the invocation, cancelled when the consumer stops reading
+//     key: key of the input file, a local path for a local Processor
+//     maxBytes: most bytes read from the file
+//     emit: called with each raw token, reading stops at its first error
+// Output:
+//     If success returns nil, otherwise an error matching ErrInputRead
+func (p *Processor) readInputFile(ctx context.Context, key string, maxBytes int64, emit func(token string) error) error {
+	body, err := p.openInput(ctx, key)
+	if err != nil {
+		return withKind(ErrInputRead, fmt.Errorf("failed to extract data from file! %w", err))
+	}
+
+	defer body.Close()
+
+	decompressed, err := decompressInput(body)
+	if err != nil {
+		return withKind(ErrInputRead, fmt.Errorf("failed to decompress %s! %w", key, err))
+	}
+
+	// The limit applies after decompression so a small archive cannot expand without bound
+	input := newLimitedReader(decompressed, maxBytes, "input file")
+
+	if isTarArchive(key) {
+		err = readTarCities(input, p.config.InputFormat, emit)
+	} else {
+		err = readCities(input, inputFormat(key, p.config.InputFormat), emit)
+	}
+
+	if err != nil && !errors.Is(err, ctx.Err()) {
+		return withKind(ErrInputRead, fmt.Errorf("failed to read cities from %s! %w", key, err))
+	}
+
+	return err
+}
+
+// readCities parses a single city file in the given format
+// Inputs:
+//     body: decompressed file contents
+//     format: "csv" or "json" from inputFormat
+//     emit: called with each raw token, reading stops at its first error
+// Output:
+//     If success returns nil, otherwise an error
+func readCities(body io.Reader, format string, emit func(token string) error) error {
+	decoded, err := skipBOM(body)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		return readJSONCities(decoded, emit)
+	}
+
+	return readDelimitedCities(decoded, emit)
+}
+
+// isTarArchive reports whether an input key names a tar archive, such as cities.tar.gz or cities.tgz
+func isTarArchive(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasSuffix(key, ".tar.gz") || strings.HasSuffix(key, ".tgz") || strings.HasSuffix(key, ".tar")
+}
+
+// readTarCities reads every regular file in a tar archive as a city list, in archive order
+// Inputs:
+//     archive: decompressed tar stream
+//     format: INPUT_FORMAT applied to every file, empty to use each file's extension
+//     emit: called with each raw token, reading stops at its first error
+// Output:
+//     If success returns nil, otherwise an error
+func readTarCities(archive io.Reader, format string, emit func(token string) error) error {
+	reader := tar.NewReader(archive)
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Directories, links and the like hold no cities
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := readCities(reader, inputFormat(header.Name, format), emit); err != nil {
+			return fmt.Errorf("failed to read %s! %w", header.Name, err)
+		}
+	}
+}
+
+// decompressInput transparently gunzips an input file, detected by its magic bytes rather than its key
+// Inputs:
+//     body: raw input file contents
+// Output:
+//     If success returns the decompressed contents, or body unchanged when it is not gzipped, and nil,
+//     otherwise an error
+func decompressInput(body io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(body)
+
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic, gzipMagic) {
+		return buffered, nil
+	}
+
+	return gzip.NewReader(buffered)
+}
+
+// skipBOM drops the UTF-8 byte order mark spreadsheet exports often start with,
+//     which would otherwise be read as part of the first city name
+// Inputs:
+//     body: input file contents
+// Output:
+//     If success returns the contents after any byte order mark and nil, otherwise an error
+func skipBOM(body io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(body)
+
+	mark, err := buffered.Peek(len(utf8BOM))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if bytes.Equal(mark, utf8BOM) {
+		if _, err := buffered.Discard(len(utf8BOM)); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffered, nil
+}
+
+// cityList parses a comma separated list of city names into a set keyed by normalized name
+// Inputs:
+//     value: comma separated city names
+// Output:
+//     set of normalized city names, empty when value is blank
+func cityList(value string) map[string]bool {
+	list := make(map[string]bool)
+
+	for _, city := range strings.Split(value, ",") {
+		if city, ok := sanitizeCity(city); ok {
+			list[normalizeCity(city)] = true
+		}
+	}
+
+	return list
+}
+
+// selectZipCountry reads the ZIP_COUNTRY_CODE env var naming the country zip codes in the input belong to
+// Output:
+//     lower case country code, defaulting to "us"
+func selectZipCountry() string {
+	country := strings.ToLower(strings.TrimSpace(os.Getenv("ZIP_COUNTRY_CODE")))
+	if country == "" {
+		return "us"
+	}
+
+	return country
+}
+
+// cityAllowed applies the allowlist and denylist to a sanitized city, the denylist takes precedence
+// Inputs:
+//     city: sanitized city name
+//     allowlist: set of normalized names allowed, empty to allow every city
+//     denylist: set of normalized names never queried
+// Output:
+//     true if the city should be queried, otherwise false
+func cityAllowed(city string, allowlist map[string]bool, denylist map[string]bool) bool {
+	key := normalizeCity(city)
+
+	if denylist[key] {
+		return false
+	}
+
+	return len(allowlist) == 0 || allowlist[key]
+}
+
+// selectInputFormat reads the INPUT_FORMAT env var overriding the format given by the input file's extension
+// Output:
+//     If success returns "csv", "json" or empty when unset, and nil, otherwise an error
+func selectInputFormat() (string, error) {
+	format := strings.ToLower(strings.TrimSpace(os.Getenv("INPUT_FORMAT")))
+
+	switch format {
+	case "", "csv", "json":
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported input format! %s", format)
+	}
+}
+
+// inputFormat picks the format an input file is parsed as
+// Inputs:
+//     key: key of the input file, a trailing .gz is ignored
+//     format: INPUT_FORMAT from selectInputFormat, empty to use the key's extension
+// Output:
+//     "csv" or "json"
+func inputFormat(key string, format string) string {
+	if format != "" {
+		return format
+	}
+
+	if strings.EqualFold(path.Ext(strings.TrimSuffix(strings.ToLower(key), ".gz")), ".json") {
+		return "json"
+	}
+
+	return "csv"
+}
+
+// readDelimitedCities reads comma separated city names, fields may be quoted to keep commas such as "Washington, DC"
+// Inputs:
+//     body: input file contents
+//     emit: called with each raw token, reading stops at its first error
+// Output:
+//     If success returns nil, otherwise an error
+func readDelimitedCities(body io.Reader, emit func(token string) error) error {
+	reader := csv.NewReader(body)
+
+	// Rows may hold any number of cities and lists are often written as "London, Paris"
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, token := range record {
+			// Trailing commas at the end of each line leave empty fields
+			if strings.TrimSpace(token) == "" {
+				continue
+			}
+
+			if err := emit(token); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readJSONCities decodes a json array of city names, either ["London"] or [{"city":"London"}]
+// Inputs:
+//     body: input file contents
+//     emit: called with each city name, reading stops at its first error
+// Output:
+//     If success returns nil, otherwise an error
+func readJSONCities(body io.Reader, emit func(token string) error) error {
+	decoder := json.NewDecoder(body)
+
+	// Elements are decoded one at a time so large arrays are never held in memory
+	if token, err := decoder.Token(); err != nil {
+		return err
+	} else if token != json.Delim('[') {
+		return fmt.Errorf("expected a json array of cities")
+	}
+
+	for decoder.More() {
+		var element json.RawMessage
+		if err := decoder.Decode(&element); err != nil {
+			return err
+		}
+
+		var city string
+		if err := json.Unmarshal(element, &city); err != nil {
+			entry := struct {
+				City string `json:"city"`
+			}{}
+			if err := json.Unmarshal(element, &entry); err != nil {
+				return fmt.Errorf("unsupported city entry %s", element)
+			}
+			city = entry.City
+		}
+
+		if err := emit(city); err != nil {
+			return err
+		}
+	}
+
+	_, err := decoder.Token()
+	return err
+}
+
+// openInput opens an input file, reading from the local filesystem for a local Processor
+// Inputs:
+//     ctx: context of the invocation
+//     key: key of the file in the input bucket, a local path for a local Processor
+// Output:
+//     If success returns the file body and nil, otherwise an error
+func (p *Processor) openInput(ctx context.Context, key string) (io.ReadCloser, error) {
+	if p.localInput {
+		return os.Open(key)
+	}
+
+	response, err := p.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(p.inputBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Body, nil
+}
+
+// locationQuery builds the api query parameter for a location read from the input file
+// Inputs:
+//     city: city name, or zip code with country such as 94040,us
+// Output:
+//     zip=<code>,<country> for zip codes, otherwise q=<city>
+func locationQuery(city string) string {
+	if zipCode := strings.SplitN(city, ",", 2)[0]; zipCodePattern.MatchString(zipCode) {
+		return "zip=" + url.QueryEscape(city)
+	}
+
+	return "q=" + url.QueryEscape(city)
+}
+
+// sanitizeCity cleans a raw token read from the input file
+// Inputs:
+//     token: raw text between separators
+// Output:
+//     string: token with surrounding whitespace removed and inner whitespace collapsed to single spaces
+//     bool: false when the token is empty, too long or contains control characters
+func sanitizeCity(token string) (string, bool) {
+	city := strings.Join(strings.Fields(token), " ")
+	if city == "" || utf8.RuneCountInString(city) > maxCityLength || !utf8.ValidString(city) {
+		return "", false
+	}
+
+	for _, r := range city {
+		if unicode.IsControl(r) {
+			return "", false
+		}
+	}
+
+	return city, true
+}
+
+// defaultProgressInterval is how many cities are processed between progress logs when PROGRESS_INTERVAL is unset
+const defaultProgressInterval = 100
+
+// selectProgressInterval reads the PROGRESS_INTERVAL env var giving how many cities are processed between
+//     progress logs, 0 disables them
+// Output:
+//     If success returns the interval, defaulting to defaultProgressInterval, and nil, otherwise an error
+func selectProgressInterval() (int, error) {
+	value := strings.TrimSpace(os.Getenv("PROGRESS_INTERVAL"))
+	if value == "" {
+		return defaultProgressInterval, nil
+	}
+
+	interval, err := strconv.Atoi(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid PROGRESS_INTERVAL! %s must be a non-negative integer", value)
+	}
+
+	return interval, nil
+}
+
+// logProgress logs how far through the input file the run is, the total is unknown while cities are streamed
+// Inputs:
+//     processed: number of cities taken from the input so far
+//     weatherList: list of Weather structs found so far
+func (p *Processor) logProgress(processed int, weatherList []Weather) {
+	p.logger.Printf("processed %d cities, %d succeeded, %d failed", processed, len(weatherList), len(p.failedCities))
+}
+
+// populateWeatherList calls api and populates list of Weather pointers based on city names
+// Inputs:
+//     ctx: context of the invocation, carrying any active trace segment
+//	   cities: channel of city names, read until closed
+//     weatherCache: map of normalized city name to the index in weatherList of its Weather already fetched in this run
+//     config: Config of the run providing the city timeout, cache ttl and progress interval
+//     weatherList: list of Weather struct pointers
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) populateWeatherList(ctx context.Context, cities <-chan string, weatherCache map[string]int, config Config,
+	weatherList *[]Weather) error {
+	// City ids are collected and looked up in batches through the group endpoint
+	pendingIDs := make([]string, 0, groupBatchSize)
+	processed := 0
+
+	for c := range cities {
+		city := c
+
+		// Nothing new is fetched once the invocation is cancelled or runs out of time
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("stopped fetching weather before %s! %w", city, err)
+		}
+
+		// Logged once the previous city is done, so the counts cover every city before this one
+		if config.ProgressInterval > 0 && processed > 0 && processed%config.ProgressInterval == 0 {
+			p.logProgress(processed, *weatherList)
+		}
+		processed++
+		cacheKey := normalizeCity(city)
+
+		if index, ok := weatherCache[cacheKey]; ok {
+			cached := (*weatherList)[index]
+			cached.InputName = config.reportedInputName(city)
+			*weatherList = append(*weatherList, cached)
+			continue
+		}
+
+		if config.isCityID(city) {
+			pendingIDs = append(pendingIDs, city)
+			if len(pendingIDs) < groupBatchSize {
+				continue
+			}
+
+			err := p.populateGroup(ctx, pendingIDs, weatherCache, config, weatherList)
+			if err != nil {
+				return err
+			}
+
+			pendingIDs = pendingIDs[:0]
+			continue
+		}
+
+		// Weather stored by a recent invocation is reused without calling the api
+		stored, ok, err := p.cachedWeather(ctx, city)
+		if err != nil {
+			p.logger.Printf("%s", err)
+		}
+
+		if ok {
+			p.metrics.CityProcessed()
+
+			stored.InputName = config.reportedInputName(city)
+
+			weatherCache[cacheKey] = len(*weatherList)
+			*weatherList = append(*weatherList, stored)
+			continue
+		}
+
+		cityWeather := Weather{}
+
+		// Trace each lookup in its own subsegment so slow cities stand out
+		start := time.Now()
+		err = p.traceSegment(ctx, "weather:"+city, func(ctx context.Context) error {
+			// A hung city is cancelled at its own deadline rather than the invocation's
+			if config.CityTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, config.CityTimeout)
+				defer cancel()
+			}
+
+			var err error
+			cityWeather, err = p.fetchWeather(ctx, city)
+			return err
+		})
+		p.metrics.ObserveAPILatency(time.Since(start))
+
+		// A lookup cut short by the invocation ending is not the city's fault, so it is not skipped
+		if err != nil && ctx.Err() != nil {
+			return fmt.Errorf("stopped fetching weather at %s! %w", city, ctx.Err())
+		}
+
+		if err != nil {
+			if err := p.cityFailed(city, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		p.metrics.CityProcessed()
+
+		if err := p.cacheWeather(ctx, city, cityWeather, config.CacheTTL); err != nil {
+			p.logger.Printf("%s", err)
+		}
+
+		cityWeather.InputName = config.reportedInputName(city)
+
+		weatherCache[cacheKey] = len(*weatherList)
+		*weatherList = append(*weatherList, cityWeather)
+	}
+
+	err := p.populateGroup(ctx, pendingIDs, weatherCache, config, weatherList)
+
+	if config.ProgressInterval > 0 && processed > 0 {
+		p.logProgress(processed, *weatherList)
+	}
+
+	return err
+}
+
+// cityFailed records a failed city lookup, skipping the city unless STRICT requires every city to succeed
+// Inputs:
+//     city: location token that failed
+//     err: error returned by the lookup
+// Output:
+//     nil when the city is skipped, otherwise err so the run stops
+func (p *Processor) cityFailed(city string, err error) error {
+	p.metrics.CityFailed()
+
+	// Only api failures are skipped, anything else, including a rejected key, means the run itself is broken
+	if p.config.Strict || !errors.Is(err, ErrAPICall) || errors.Is(err, ErrInvalidKey) {
+		return err
+	}
+
+	p.logger.Printf("skipping %s! %s", city, err)
+	p.failedCities = append(p.failedCities, city)
+
+	if p.cityErrors == nil {
+		p.cityErrors = make(map[string]string)
+	}
+	p.cityErrors[city] = failureReason(err)
+
+	return nil
+}
+
+// failureReason describes why a city lookup failed for the Response
+// Inputs:
+//     err: error returned by the lookup
+// Output:
+//     "timeout" when the lookup ran out of time, otherwise the error message including any api status
+func failureReason(err error) string {
+	var timeoutErr interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &timeoutErr) && timeoutErr.Timeout()) {
+		return "timeout"
+	}
+
+	return err.Error()
+}
+
+// selectMinSuccess reads the MIN_SUCCESS env var, either a city count such as 10 or a percentage such as 80%
+// Output:
+//     If success returns the minimum count, the minimum percentage and nil, both zero when unset,
+//     otherwise an error
+func selectMinSuccess() (int, float64, error) {
+	value := strings.TrimSpace(os.Getenv("MIN_SUCCESS"))
+	if value == "" {
+		return 0, 0, nil
+	}
+
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, "%")), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return 0, 0, fmt.Errorf("invalid MIN_SUCCESS! %s must be a percentage between 0 and 100", value)
+		}
+		return 0, percent, nil
+	}
+
+	count, err := strconv.Atoi(value)
+	if err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("invalid MIN_SUCCESS! %s must be a positive integer or percentage", value)
+	}
+
+	return count, 0, nil
+}
+
+// checkMinSuccess ensures enough cities were fetched for the reports to be meaningful
+// Inputs:
+//     minCount: minimum number of successful cities, zero for no minimum
+//     minPercent: minimum percentage of successful cities, zero for no minimum
+//     succeeded: number of cities fetched
+//     failed: number of cities skipped after failing
+// Output:
+//     If the threshold is met returns nil, otherwise an error
+func checkMinSuccess(minCount int, minPercent float64, succeeded int, failed int) error {
+	total := succeeded + failed
+
+	if minCount > 0 && succeeded < minCount {
+		return fmt.Errorf("only %d of %d cities succeeded! MIN_SUCCESS requires %d", succeeded, total, minCount)
+	}
+
+	if minPercent > 0 && total > 0 && float64(succeeded)*100 < minPercent*float64(total) {
+		return fmt.Errorf("only %d of %d cities succeeded! MIN_SUCCESS requires %g%%", succeeded, total, minPercent)
+	}
+
+	return nil
+}
+
+// newRateLimiter builds a token bucket from the API_RATE_LIMIT_PER_MIN env var
+// Output:
+//     If success returns the limiter, or nil when no limit is configured, and nil,
+//     otherwise an error
+func newRateLimiter() (*rate.Limiter, error) {
+	value := strings.TrimSpace(os.Getenv("API_RATE_LIMIT_PER_MIN"))
+	if value == "" {
+		return nil, nil
+	}
+
+	perMinute, err := strconv.Atoi(value)
+	if err != nil || perMinute <= 0 {
+		return nil, fmt.Errorf("invalid API_RATE_LIMIT_PER_MIN! %s must be a positive integer", value)
+	}
+
+	// A burst of one spaces calls evenly across the minute
+	return rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), 1), nil
+}
+
+// selectCityTimeout reads the CITY_TIMEOUT_MS env var bounding the api calls made for one city
+// Output:
+//     If success returns the timeout, or zero when unset, and nil, otherwise an error
+func selectCityTimeout() (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv("CITY_TIMEOUT_MS"))
+	if value == "" {
+		return 0, nil
+	}
+
+	millis, err := strconv.Atoi(value)
+	if err != nil || millis <= 0 {
+		return 0, fmt.Errorf("invalid CITY_TIMEOUT_MS! %s must be a positive integer", value)
+	}
+
+	return time.Duration(millis) * time.Millisecond, nil
+}
+
+// inputName strips the country appended to zip codes to recover the location as written in the input file
+// Inputs:
+//     city: location token read from the input file
+// Output:
+//     The original input label, names such as Washington, DC are kept whole
+func inputName(city string) string {
+	if zipCode := strings.SplitN(city, ",", 2)[0]; zipCodePattern.MatchString(zipCode) {
+		return zipCode
+	}
+
+	return city
+}
+
+// reportedInputName gives the input name recorded on a city's Weather, which cityName then reports
+// Inputs:
+//     city: location token read from the input file
+// Output:
+//     The location as written in the input file when USE_INPUT_NAME is enabled, otherwise empty
+func (config Config) reportedInputName(city string) string {
+	if !config.UseInputName {
+		return ""
+	}
+
+	return inputName(city)
+}
+
+// cityName picks the name shown in reports, the input name recorded when USE_INPUT_NAME is enabled
+//     or the api name
+// Inputs:
+//     city: Weather struct to name
+// Output:
+//     The city name to report
+func cityName(city Weather) string {
+	if city.InputName != "" {
+		return city.InputName
+	}
+
+	return city.Name
+}
+
+// normalizeCity folds a city name into the key used to detect repeated lookups
+// Inputs:
+//     city: city name as read from the input file
+// Output:
+//     The trimmed, lower case city name
+func normalizeCity(city string) string {
+	return strings.ToLower(strings.TrimSpace(city))
+}
+
+// fetchWeather looks up a single city through the configured WeatherProvider
+// Inputs:
+//     ctx: context of the request
+//     city: city name to look up
+// Output:
+//     If success returns the Weather and nil, otherwise an error matching ErrAPICall
+func (p *Processor) fetchWeather(ctx context.Context, city string) (Weather, error) {
+	cityWeather, err := p.weatherProvider.Fetch(ctx, city)
+
+	return cityWeather, withKind(ErrAPICall, err)
+}
+
+// extractWeatherInfo reads a list of weather information and splits into seperate slices for temperature and wind speed
+// Inputs:
+//     weatherList: list of Weather structs to split
+//     ascending: sort lowest values first instead of highest
+//     windUnit: WIND_UNIT the wind speeds are converted into
+//     vector: WIND_VECTOR, adding u/v components to the wind rows
+// Output:
+//     []TemperatureOutput: list of up to 3 cities with highest (or lowest) temperatures
+//	   []WindOutput: list of up to 3 cities with highest (or lowest) wind speeds
+func extractWeatherInfo(weatherList []Weather, ascending bool, windUnit string, vector bool) ([]TemperatureOutput, []WindOutput) {
+	temperatureList := temperatureRows(rankWeather(weatherList, temperatureValue, ascending)).([]TemperatureOutput)
+	windList := windRows(windUnit, vector)(rankWeather(weatherList, windValue, ascending)).([]WindOutput)
+
+	return temperatureList, windList
+}
+
+// primaryDescription returns the first weather condition description reported by the api
+// Inputs:
+//     city: Weather struct to describe
+// Output:
+//     The description, e.g. light rain, or empty when the api returned no conditions
+func primaryDescription(city Weather) string {
+	if len(city.Weather) == 0 {
+		return ""
+	}
+
+	return city.Weather[0].Description
+}
+
+// compassPoints lists the 16 compass labels clockwise from north, each covering 22.5 degrees
+var compassPoints = []string{
+	"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE",
+	"S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW",
+}
+
+// degreesToCompass converts a wind direction in degrees to a 16-point compass label
+// Inputs:
+//     degrees: meteorological wind direction, any integer is normalized into 0-359
+// Output:
+//     The compass label whose 22.5 degree sector contains the direction, e.g. 348 is N and 22 is NNE
+func degreesToCompass(degrees int) string {
+	normalized := ((degrees % 360) + 360) % 360
+
+	// Each label is centred on its bearing, so sectors start half a sector early at 348.75, 11.25, 33.75...
+	//     The api reports whole degrees, truncating 348.75 to 348, so boundaries are floored to whole
+	//     degrees by shifting 12 rather than 11.25, which makes N 348-10, NNE 11-32 and NE 33-55
+	index := int((float64(normalized)+12)/22.5) % len(compassPoints)
+
+	return compassPoints[index]
+}
+
+// windVector decomposes a wind into its eastward and northward components
+// Inputs:
+//     speed: wind speed in any unit
+//     deg: meteorological wind direction, the bearing the wind blows from
+// Output:
+//     u: eastward component, positive for a wind blowing towards the east
+//     v: northward component, positive for a wind blowing towards the north
+func windVector(speed float64, deg int) (u, v float64) {
+	radians := float64(deg) * math.Pi / 180
+
+	// The direction is where the wind comes from, so the components point the opposite way
+	return -speed * math.Sin(radians), -speed * math.Cos(radians)
+}
+
+// precipitationVolume converts an api precipitation object into a nullable volume
+// Inputs:
+//     precipitation: rain or snow object from the api, nil when dry
+// Output:
+//     pointer to the hourly volume, or nil when there was no precipitation
+func precipitationVolume(precipitation *Precipitation) *float64 {
+	if precipitation == nil {
+		return nil
+	}
+
+	volume := precipitation.OneHour
+	return &volume
+}
+
+// writeReport marshals report rows using the selected format and inserts file into s3 ouput bucket
+// Inputs:
+//     ctx: context of the invocation
+//     report: ReportFile holding the output key and rows to marshal
+//     format: OutputFormat used to serialize the rows
+// Output:
+//     If success returns nil, otherwise an error matching ErrOutputWrite
+func (p *Processor) writeReport(ctx context.Context, report ReportFile, format OutputFormat) error {
+	roundRows(report.Rows, p.config.FloatPrecision)
+
+	body, err := format.Marshal(report.Rows)
+
+	if err != nil {
+		return withKind(ErrOutputWrite, fmt.Errorf("failed to marshal %s for %s! %w", format.Extension, report.Key, err))
+	}
+
+	// The stdout sink already prints every report, and binary reports would only garble the logs
+	if p.config.Sink != "stdout" && !format.Binary {
+		fmt.Println(string(body))
+	}
+
+	// Reports without their own bucket go to OUTPUT_BUCKET
+	bucket := report.Bucket
+	if bucket == "" {
+		bucket = p.outputBucket
+	}
+
+	err = p.uploadOutput(ctx, bucket, report.Key, body, format)
+	if err != nil {
+		return withKind(ErrOutputWrite, fmt.Errorf("error uploading %s! %w", report.Key, err))
+	}
+
+	return nil
+}
+
+// uploadOutput writes a report to the selected OutputSink, mirroring S3 writes to BACKUP_OUTPUT_BUCKET when set
+// Inputs:
+//     ctx: context of the invocation
+//     bucket: bucket the report is written to by the s3 sink
+//     key: final output key of the report
+//     body: serialized report
+//     format: OutputFormat providing the content type
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) uploadOutput(ctx context.Context, bucket string, key string, body []byte, format OutputFormat) error {
+	if err := p.outputSink(bucket).Write(ctx, key, body, format.ContentType); err != nil {
+		return err
+	}
+
+	return p.backupOutput(ctx, bucket, key, body, format)
+}
+
+// selectCleanupMode reads the CLEANUP_MODE env var controlling what happens to the upload file, defaulting to delete
+// Output:
+//     If success returns "delete", "archive" or "none" and nil, otherwise an error
+func selectCleanupMode() (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("CLEANUP_MODE")))
+
+	switch mode {
+	case "":
+		return "delete", nil
+	case "delete", "archive", "none":
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported cleanup mode! %s", mode)
+	}
+}
+
+// selectArchivePrefix reads the ARCHIVE_PREFIX env var naming where archived upload files are kept
+// Output:
+//     normalized prefix with a trailing slash, defaulting to "archive/"
+func selectArchivePrefix() string {
+	prefix := strings.Trim(strings.TrimSpace(os.Getenv("ARCHIVE_PREFIX")), "/")
+	if prefix == "" {
+		prefix = "archive"
+	}
+
+	return prefix + "/"
+}
+
+// archiveKey builds the key the upload file is moved to when archiving
+// Output:
+//     upload key under the archive prefix
+func (p *Processor) archiveKey() string {
+	return p.config.ArchivePrefix + p.uploadKey
+}
+
+// runCleanup deletes or archives the upload file object in s3 input bucket
+// Inputs:
+//     ctx: context of the invocation
+//     mode: cleanup mode returned by selectCleanupMode
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) runCleanup(ctx context.Context, mode string) error {
+	// Local input files belong to the caller and are never removed
+	if mode == "none" || p.localInput {
+		return nil
+	}
+
+	if p.dryRun() {
+		if mode == "archive" {
+			p.logger.Printf("dry run: would archive s3://%s/%s to %s", p.inputBucket, p.uploadKey, p.archiveKey())
+		} else {
+			p.logger.Printf("dry run: would delete s3://%s/%s", p.inputBucket, p.uploadKey)
+		}
+		return nil
+	}
+
+	if mode == "archive" {
+		_, err := CopyObject(ctx, p.s3Client, &s3.CopyObjectInput{
+			Bucket:     aws.String(p.inputBucket),
+			Key:        aws.String(p.archiveKey()),
+			CopySource: aws.String(p.inputBucket + "/" + url.PathEscape(p.uploadKey)),
+		})
+		if err != nil {
+			return fmt.Errorf("error archiving upload file! %w", err)
+		}
+	}
+
+	params := &s3.DeleteObjectInput{
+		Bucket: aws.String(p.inputBucket),
+		Key:    aws.String(p.uploadKey),
+	}
+
+	_, err := DeleteObject(ctx, p.s3Client, params)
+	if err != nil {
+		return fmt.Errorf("error removing upload file! %w", err)
+	}
+
+	return nil
+}
+
+// PutFile uploads a file to an Amazon Simple Storage Service (Amazon S3) bucket
+// Inputs:
+//     c is the context of the method call, which includes the AWS Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a PutObjectOutput object containing the result of the service call and nil
+//     Otherwise, nil and an error from the call to PutObject
+func PutObject(c context.Context, api S3PutObjectAPI, input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return api.PutObject(c, input)
+}
+
+// CopyObject copies an object within Amazon Simple Storage Service (Amazon S3)
+// Inputs:
+//     c is the context of the method call, which includes the AWS Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a CopyObjectOutput object containing the result of the service call and nil
+//     Otherwise, nil and an error from the call to CopyObject
+func CopyObject(c context.Context, api S3CopyObjectAPI, input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	return api.CopyObject(c, input)
+}
+
+// DeleteItem deletes an object from an Amazon Simple Storage Service (Amazon S3) bucket
+// Inputs:
+//     c is the context of the method call, which includes the AWS Region
+//     api is the interface that defines the method call
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a DeleteObjectOutput object containing the result of the service call and nil
+//     Otherwise, an error from the call to DeleteObject
+func DeleteObject(c context.Context, api S3DeleteObjectAPI, input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	return api.DeleteObject(c, input)
+}
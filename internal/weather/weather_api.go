@@ -0,0 +1,552 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultCurrentWeatherURL is the 2.5 current weather endpoint used when OWM_BASE_URL is unset
+const defaultCurrentWeatherURL = "https://api.openweathermap.org/data/2.5/weather"
+
+// defaultOneCallURL is the 3.0 One Call endpoint used when OWM_BASE_URL is unset
+const defaultOneCallURL = "https://api.openweathermap.org/data/3.0/onecall"
+
+// defaultGeocodeURL is the geocoding endpoint used to resolve cities for the One Call api when OWM_BASE_URL is unset
+const defaultGeocodeURL = "https://api.openweathermap.org/geo/1.0"
+
+// owmLanguages are the language codes accepted by the api's lang parameter
+var owmLanguages = map[string]bool{
+	"af": true, "al": true, "ar": true, "az": true, "bg": true, "ca": true, "cz": true, "da": true,
+	"de": true, "el": true, "en": true, "es": true, "eu": true, "fa": true, "fi": true, "fr": true,
+	"gl": true, "he": true, "hi": true, "hr": true, "hu": true, "id": true, "it": true, "ja": true,
+	"kr": true, "la": true, "lt": true, "mk": true, "nl": true, "no": true, "pl": true, "pt": true,
+	"pt_br": true, "ro": true, "ru": true, "se": true, "sk": true, "sl": true, "sp": true, "sr": true,
+	"sv": true, "th": true, "tr": true, "ua": true, "uk": true, "vi": true, "zh_cn": true, "zh_tw": true,
+	"zu": true,
+}
+
+// defaultUserAgent identifies this service to the api when HTTP_USER_AGENT is unset
+const defaultUserAgent = "go_weather_aws/1.0"
+
+// WeatherProvider defines the interface for looking up the current weather of a single location
+type WeatherProvider interface {
+	Fetch(ctx context.Context, location string) (Weather, error)
+}
+
+// CurrentWeatherAPI fetches weather from the OpenWeatherMap 2.5 current weather endpoint
+type CurrentWeatherAPI struct {
+	Client *http.Client
+	// Limiter throttles every request made to the api, nil for no limit
+	Limiter          *rate.Limiter
+	BaseURL          string
+	MaxResponseBytes int64
+	MaxRetries       int
+	// IncludeAirQuality adds the air pollution api's reading to each city
+	IncludeAirQuality bool
+	AirPollutionURL   string
+	// Locations are looked up by coordinates rather than by name
+	Locations GeocodeCache
+}
+
+// OneCallAPI fetches weather from the OpenWeatherMap 3.0 One Call endpoint, resolving cities through the geocoding api
+type OneCallAPI struct {
+	Client *http.Client
+	// Limiter throttles every request made to the api, nil for no limit
+	Limiter          *rate.Limiter
+	BaseURL          string
+	GeocodeURL       string
+	MaxResponseBytes int64
+	MaxRetries       int
+	IncludeForecast  bool
+	// IncludeAirQuality adds the air pollution api's reading to each city
+	IncludeAirQuality bool
+	AirPollutionURL   string
+	// Locations resolve without calling the geocoding api
+	Locations GeocodeCache
+}
+
+// OneCallResponse defines the interface for the json object returned from the One Call api
+type OneCallResponse struct {
+	Current struct {
+		Temp      float64        `json:"temp"`
+		FeelsLike float64        `json:"feels_like"`
+		Pressure  int            `json:"pressure"`
+		Humidity  int            `json:"humidity"`
+		WindSpeed float64        `json:"wind_speed"`
+		WindDeg   int            `json:"wind_deg"`
+		Rain      *Precipitation `json:"rain"`
+		Snow      *Precipitation `json:"snow"`
+		Weather   []struct {
+			Description string `json:"description"`
+		} `json:"weather"`
+	} `json:"current"`
+	Hourly []struct {
+		Temp float64 `json:"temp"`
+	} `json:"hourly"`
+	Daily []struct {
+		Temp struct {
+			Min float64 `json:"min"`
+			Max float64 `json:"max"`
+		} `json:"temp"`
+	} `json:"daily"`
+}
+
+// GeocodeLocation defines the interface for a location returned from the geocoding api
+type GeocodeLocation struct {
+	Name    string  `json:"name"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Country string  `json:"country"`
+}
+
+// selectProviderName reads the WEATHER_PROVIDER env var, defaulting to OpenWeatherMap
+// Output:
+//     If success returns "openweathermap" or "static" and nil, otherwise an error
+func selectProviderName() (string, error) {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("WEATHER_PROVIDER")))
+
+	switch name {
+	case "", "openweathermap":
+		return "openweathermap", nil
+	case "static":
+		return name, nil
+	default:
+		return "", fmt.Errorf("unsupported WEATHER_PROVIDER! %s", name)
+	}
+}
+
+// selectAPIVersion reads the OWM_API_VERSION env var naming the OpenWeatherMap api called, defaulting to 2.5
+// Inputs:
+//     includeForecast: INCLUDE_FORECAST, which defaults the version to 3.0 as only One Call has the hourly forecast
+// Output:
+//     If success returns "2.5" or "3.0" and nil, otherwise an error
+func selectAPIVersion(includeForecast bool) (string, error) {
+	version := strings.TrimSpace(os.Getenv("OWM_API_VERSION"))
+
+	// The hourly forecast is only available from One Call
+	if includeForecast && version == "" {
+		version = "3.0"
+	}
+
+	switch version {
+	case "", "2.5":
+		if includeForecast {
+			return "", fmt.Errorf("INCLUDE_FORECAST requires OWM_API_VERSION 3.0")
+		}
+		return "2.5", nil
+	case "3.0":
+		return version, nil
+	default:
+		return "", fmt.Errorf("unsupported OWM_API_VERSION! %s", version)
+	}
+}
+
+// selectWeatherProvider builds the provider named by WEATHER_PROVIDER
+// Inputs:
+//     config: Config of the run naming the provider and its options
+// Output:
+//     If success returns the selected WeatherProvider and nil, otherwise an error
+func selectWeatherProvider(config Config) (WeatherProvider, error) {
+	if config.Provider == "static" {
+		return loadStaticProvider(config.StaticWeatherFile)
+	}
+
+	return selectWeatherAPI(config)
+}
+
+// selectWeatherAPI builds the OpenWeatherMap api named by OWM_API_VERSION
+// Inputs:
+//     config: Config of the run providing the api version and the optional lookups
+// Output:
+//     If success returns the selected WeatherProvider and nil, otherwise an error
+func selectWeatherAPI(config Config) (WeatherProvider, error) {
+	// Every request would be rejected without a key, so the run fails before any city is read
+	if owmAPIKey() == "" {
+		return nil, fmt.Errorf("missing OWM_API_KEY! set it in the environment or the file named by DOTENV_PATH")
+	}
+
+	maxBytes, err := selectByteLimit("MAX_RESPONSE_BYTES", defaultMaxResponseBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	maxRetries, err := selectMaxRetries()
+	if err != nil {
+		return nil, err
+	}
+
+	limiter, err := newRateLimiter()
+	if err != nil {
+		return nil, err
+	}
+
+	weatherClient := instrumentHTTPClient(&http.Client{
+		Timeout: time.Second * 2,
+	}, config.XRay)
+
+	switch config.APIVersion {
+	case "2.5":
+		baseURL, err := selectWeatherBaseURL(defaultCurrentWeatherURL)
+		if err != nil {
+			return nil, err
+		}
+		airPollutionURL, err := selectAirPollutionURL(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		return CurrentWeatherAPI{
+			Client:            weatherClient,
+			Limiter:           limiter,
+			BaseURL:           baseURL,
+			MaxResponseBytes:  maxBytes,
+			MaxRetries:        maxRetries,
+			IncludeAirQuality: config.IncludeAirQuality,
+			AirPollutionURL:   airPollutionURL,
+		}, nil
+	case "3.0":
+		baseURL, err := selectWeatherBaseURL(defaultOneCallURL)
+		if err != nil {
+			return nil, err
+		}
+		geocodeURL, err := selectGeocodeURL(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		airPollutionURL, err := selectAirPollutionURL(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		return OneCallAPI{
+			Client:            weatherClient,
+			Limiter:           limiter,
+			BaseURL:           baseURL,
+			GeocodeURL:        geocodeURL,
+			MaxResponseBytes:  maxBytes,
+			MaxRetries:        maxRetries,
+			IncludeForecast:   config.IncludeForecast,
+			IncludeAirQuality: config.IncludeAirQuality,
+			AirPollutionURL:   airPollutionURL,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported OWM_API_VERSION! %s", config.APIVersion)
+	}
+}
+
+// selectGeocodeURL derives the geocoding endpoint from OWM_BASE_URL the way groupURL derives the group endpoint,
+//     so a proxy or mock configured there also resolves the cities
+// Inputs:
+//     baseURL: One Call endpoint returned by selectWeatherBaseURL
+// Output:
+//     If success returns the geocoding url, defaultGeocodeURL when OWM_BASE_URL is unset, and nil,
+//     otherwise an error
+func selectGeocodeURL(baseURL string) (string, error) {
+	if strings.TrimSpace(os.Getenv("OWM_BASE_URL")) == "" {
+		return defaultGeocodeURL, nil
+	}
+
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid OWM_BASE_URL! %w", err)
+	}
+
+	// The geocoding api sits at the root of the host rather than beside the weather endpoints
+	parsed.Path = "/geo/1.0"
+
+	return parsed.String(), nil
+}
+
+// userAgent reads the HTTP_USER_AGENT env var sent with every api request, defaulting to defaultUserAgent
+func userAgent() string {
+	agent := strings.TrimSpace(os.Getenv("HTTP_USER_AGENT"))
+	if agent == "" {
+		return defaultUserAgent
+	}
+
+	return agent
+}
+
+// owmAPIKey reads the OWM_API_KEY env var sent with every api request, checked by selectWeatherAPI
+func owmAPIKey() string {
+	return strings.TrimSpace(os.Getenv("OWM_API_KEY"))
+}
+
+// owmLanguage reads the LANG env var used to localize weather descriptions
+// Output:
+//     supported api language code, such as "fr" for LANG=fr_FR.UTF-8, or empty to use the api default
+func owmLanguage() string {
+	lang := strings.ToLower(strings.TrimSpace(os.Getenv("LANG")))
+
+	// LANG is usually a POSIX locale, drop the encoding and try the full then the bare language
+	lang = strings.SplitN(lang, ".", 2)[0]
+	if owmLanguages[lang] {
+		return lang
+	}
+
+	lang = strings.SplitN(lang, "_", 2)[0]
+	if owmLanguages[lang] {
+		return lang
+	}
+
+	return ""
+}
+
+// getBody performs a GET request against the api and reads the whole response,
+//     retrying throttled and server error responses
+// Inputs:
+//     ctx: context of the request
+//     weatherClient: http client used to call the api
+//     limiter: rate limiter every attempt waits on, nil for no limit
+//     endpoint: full url including the query string
+//     maxBytes: largest response body accepted
+//     maxRetries: most retries after the first attempt
+// Output:
+//     If success returns the http status code, the response body and nil,
+//     otherwise an error, matching ErrInvalidKey when the api rejects the key
+func getBody(ctx context.Context, weatherClient *http.Client, limiter *rate.Limiter, endpoint string, maxBytes int64, maxRetries int) (int, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		status, body, retryAfter, err := getOnce(ctx, weatherClient, limiter, endpoint, maxBytes)
+		if err != nil || !retryableStatus(status) || attempt >= maxRetries {
+			return status, body, err
+		}
+
+		if err := sleepContext(ctx, retryDelay(attempt, retryAfter, time.Now())); err != nil {
+			return 0, nil, fmt.Errorf("retry wait failed! %w", err)
+		}
+	}
+}
+
+// getOnce performs a single GET request against the api and reads the whole response
+// Inputs:
+//     ctx: context of the request
+//     weatherClient: http client used to call the api
+//     limiter: rate limiter waited on before the request is sent, nil for no limit
+//     endpoint: full url including the query string
+//     maxBytes: largest response body accepted
+// Output:
+//     If success returns the http status code, the response body, the Retry-After header and nil,
+//     otherwise an error, matching ErrInvalidKey when the api rejects the key
+func getOnce(ctx context.Context, weatherClient *http.Client, limiter *rate.Limiter, endpoint string, maxBytes int64) (int, []byte, string, error) {
+	// Every request takes a token, so geocoding, air quality and retries count against the limit too
+	if limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			return 0, nil, "", fmt.Errorf("rate limiter wait failed! %w", err)
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+
+	if err != nil {
+		return 0, nil, "", fmt.Errorf("request failed! %w", err)
+	}
+
+	request.Header.Set("User-Agent", userAgent())
+
+	response, err := weatherClient.Do(request)
+
+	if err != nil {
+		return 0, nil, "", fmt.Errorf("response failed! %w", err)
+	}
+
+	if response.Body != nil {
+		defer response.Body.Close()
+	}
+
+	body, err := readLimited(response.Body, maxBytes, "response body")
+
+	if err != nil {
+		return 0, nil, "", fmt.Errorf("failed to read response body! %w", err)
+	}
+
+	// Every request fails the same way with a bad key, so this is never treated as a per city failure
+	if response.StatusCode == http.StatusUnauthorized {
+		return 0, nil, "", withKind(ErrInvalidKey, fmt.Errorf("invalid API key! %s", strings.TrimSpace(string(body))))
+	}
+
+	return response.StatusCode, body, response.Header.Get("Retry-After"), nil
+}
+
+// Fetch calls the 2.5 current weather endpoint for a single city
+// Inputs:
+//     ctx: context of the request
+//     city: city name to look up
+// Output:
+//     If success returns the decoded Weather and nil, otherwise an error
+func (api CurrentWeatherAPI) Fetch(ctx context.Context, city string) (Weather, error) {
+	query := locationQuery(city)
+	if location, ok := api.Locations[normalizeCity(city)]; ok {
+		query = coordinateQuery(location)
+	}
+
+	params := fmt.Sprintf("?%s&units=%s&appid=%s", query, weatherUnits, owmAPIKey())
+	if lang := owmLanguage(); lang != "" {
+		params += "&lang=" + lang
+	}
+
+	_, body, err := getBody(ctx, api.Client, api.Limiter, api.BaseURL+params, api.MaxResponseBytes, api.MaxRetries)
+	if err != nil {
+		return Weather{}, err
+	}
+
+	cityWeather := Weather{}
+	jsonErr := json.Unmarshal(body, &cityWeather)
+
+	if jsonErr != nil {
+		return Weather{}, fmt.Errorf("failed to load JSON into Struct! %w", jsonErr)
+	}
+
+	// The api reports failures such as unknown cities in the body, e.g. {"cod":"404","message":"city not found"}
+	if cityWeather.Cod != "" && cityWeather.Cod != "200" {
+		return Weather{}, fmt.Errorf("weather lookup failed for %s! %s %s", city, cityWeather.Cod, cityWeather.Message)
+	}
+
+	if api.IncludeAirQuality {
+		cityWeather.AirQuality, err = fetchAirQuality(ctx, api.Client, api.Limiter, api.AirPollutionURL, cityWeather, api.MaxResponseBytes, api.MaxRetries)
+		if err != nil {
+			return Weather{}, err
+		}
+	}
+
+	return cityWeather, nil
+}
+
+// Fetch resolves a single city through the geocoding api then calls the 3.0 One Call endpoint
+// Inputs:
+//     ctx: context of the request
+//     city: city name, or zip code with country, to look up
+// Output:
+//     If success returns the One Call response mapped into a Weather and nil, otherwise an error
+func (api OneCallAPI) Fetch(ctx context.Context, city string) (Weather, error) {
+	location, err := api.geocode(ctx, city)
+	if err != nil {
+		return Weather{}, err
+	}
+
+	params := url.Values{}
+	params.Set("lat", fmt.Sprint(location.Lat))
+	params.Set("lon", fmt.Sprint(location.Lon))
+	params.Set("exclude", "minutely,hourly,alerts")
+	if api.IncludeForecast {
+		params.Set("exclude", "minutely,alerts")
+	}
+	params.Set("units", weatherUnits)
+	params.Set("appid", owmAPIKey())
+	if lang := owmLanguage(); lang != "" {
+		params.Set("lang", lang)
+	}
+
+	status, body, err := getBody(ctx, api.Client, api.Limiter, api.BaseURL+"?"+params.Encode(), api.MaxResponseBytes, api.MaxRetries)
+	if err != nil {
+		return Weather{}, err
+	}
+
+	if status != http.StatusOK {
+		return Weather{}, fmt.Errorf("weather lookup failed for %s! %d %s", city, status, strings.TrimSpace(string(body)))
+	}
+
+	oneCall := OneCallResponse{}
+	if err := json.Unmarshal(body, &oneCall); err != nil {
+		return Weather{}, fmt.Errorf("failed to load JSON into Struct! %w", err)
+	}
+
+	cityWeather := Weather{Name: location.Name, Cod: "200"}
+	cityWeather.Main.Temp = oneCall.Current.Temp
+	cityWeather.Main.FeelsLike = oneCall.Current.FeelsLike
+	cityWeather.Main.Pressure = oneCall.Current.Pressure
+	cityWeather.Main.Humidity = oneCall.Current.Humidity
+	cityWeather.Wind.Speed = oneCall.Current.WindSpeed
+	cityWeather.Wind.Degrees = oneCall.Current.WindDeg
+	cityWeather.Sys.Country = location.Country
+	cityWeather.Coord.Lat = location.Lat
+	cityWeather.Coord.Lon = location.Lon
+	cityWeather.Rain = oneCall.Current.Rain
+	cityWeather.Snow = oneCall.Current.Snow
+
+	// hourly[0] is the current hour, so the next hour's forecast is the second entry
+	if api.IncludeForecast && len(oneCall.Hourly) > 1 {
+		cityWeather.ForecastTemp = &oneCall.Hourly[1].Temp
+	}
+
+	// One Call only reports the daily range, so today's forecast stands in for the current min and max
+	if len(oneCall.Daily) > 0 {
+		cityWeather.Main.TempMin = oneCall.Daily[0].Temp.Min
+		cityWeather.Main.TempMax = oneCall.Daily[0].Temp.Max
+	}
+
+	for _, condition := range oneCall.Current.Weather {
+		cityWeather.Weather = append(cityWeather.Weather, struct {
+			Description string `json:"description"`
+		}{Description: condition.Description})
+	}
+
+	if api.IncludeAirQuality {
+		cityWeather.AirQuality, err = fetchAirQuality(ctx, api.Client, api.Limiter, api.AirPollutionURL, cityWeather, api.MaxResponseBytes, api.MaxRetries)
+		if err != nil {
+			return Weather{}, err
+		}
+	}
+
+	return cityWeather, nil
+}
+
+// geocode resolves a city name or zip code into coordinates
+// Inputs:
+//     ctx: context of the request
+//     city: city name, or zip code with country such as 94040,us
+// Output:
+//     If success returns the first matching GeocodeLocation and nil, otherwise an error
+func (api OneCallAPI) geocode(ctx context.Context, city string) (GeocodeLocation, error) {
+	if location, ok := api.Locations[normalizeCity(city)]; ok {
+		return location, nil
+	}
+
+	params := url.Values{}
+	params.Set("appid", owmAPIKey())
+
+	endpoint := api.GeocodeURL + "/direct"
+	isZip := zipCodePattern.MatchString(strings.SplitN(city, ",", 2)[0])
+
+	if isZip {
+		endpoint = api.GeocodeURL + "/zip"
+		params.Set("zip", city)
+	} else {
+		params.Set("q", city)
+		params.Set("limit", "1")
+	}
+
+	status, body, err := getBody(ctx, api.Client, api.Limiter, endpoint+"?"+params.Encode(), api.MaxResponseBytes, api.MaxRetries)
+	if err != nil {
+		return GeocodeLocation{}, err
+	}
+
+	if status != http.StatusOK {
+		return GeocodeLocation{}, fmt.Errorf("geocoding failed for %s! %d %s", city, status, strings.TrimSpace(string(body)))
+	}
+
+	// The zip endpoint returns a single location while direct lookups return a list
+	if isZip {
+		location := GeocodeLocation{}
+		if err := json.Unmarshal(body, &location); err != nil {
+			return GeocodeLocation{}, fmt.Errorf("failed to load JSON into Struct! %w", err)
+		}
+		return location, nil
+	}
+
+	locations := make([]GeocodeLocation, 0)
+	if err := json.Unmarshal(body, &locations); err != nil {
+		return GeocodeLocation{}, fmt.Errorf("failed to load JSON into Struct! %w", err)
+	}
+
+	if len(locations) == 0 {
+		return GeocodeLocation{}, fmt.Errorf("weather lookup failed for %s! city not found", city)
+	}
+
+	return locations[0], nil
+}
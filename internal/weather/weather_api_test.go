@@ -0,0 +1,566 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// owmStub serves canned geocoding and One Call responses, recording when each request arrived
+type owmStub struct {
+	mu       sync.Mutex
+	requests []time.Time
+	paths    []string
+}
+
+func (s *owmStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, time.Now())
+	s.paths = append(s.paths, r.URL.Path)
+	s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/geo/1.0/direct":
+		fmt.Fprintf(w, `[{"name":%q,"lat":51.5,"lon":-0.12,"country":"GB"}]`, r.URL.Query().Get("q"))
+	case "/data/3.0/onecall":
+		fmt.Fprint(w, `{"current":{"temp":18.5,"wind_speed":4.1,"wind_deg":90},"daily":[{"temp":{"min":12,"max":21}}]}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestLimiterSpacesEveryRequest(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "test")
+
+	stub := &owmStub{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	interval := 50 * time.Millisecond
+	api := OneCallAPI{
+		Client:           server.Client(),
+		Limiter:          rate.NewLimiter(rate.Every(interval), 1),
+		BaseURL:          server.URL + "/data/3.0/onecall",
+		GeocodeURL:       server.URL + "/geo/1.0",
+		MaxResponseBytes: defaultMaxResponseBytes,
+	}
+
+	// Each city costs a geocoding request and a One Call request
+	for _, city := range []string{"London", "Leeds"} {
+		if _, err := api.Fetch(context.Background(), city); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(stub.requests) != 4 {
+		t.Fatalf("got requests %v, want 4", stub.paths)
+	}
+
+	// Allow some timer slack below the configured interval
+	for i := 1; i < len(stub.requests); i++ {
+		if gap := stub.requests[i].Sub(stub.requests[i-1]); gap < interval*8/10 {
+			t.Errorf("request %d (%s) came %v after the previous one, want at least %v", i, stub.paths[i], gap, interval)
+		}
+	}
+}
+
+func TestSelectGeocodeURL(t *testing.T) {
+	tests := []struct {
+		baseEnv string
+		baseURL string
+		want    string
+	}{
+		{"", defaultOneCallURL, defaultGeocodeURL},
+		{"http://localhost:8080/data/3.0/onecall", "http://localhost:8080/data/3.0/onecall", "http://localhost:8080/geo/1.0"},
+		{"https://owm.internal/onecall", "https://owm.internal/onecall", "https://owm.internal/geo/1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("OWM_BASE_URL", tt.baseEnv)
+
+		got, err := selectGeocodeURL(tt.baseURL)
+		if err != nil || got != tt.want {
+			t.Errorf("selectGeocodeURL(%q) = %q, %v, want %q", tt.baseURL, got, err, tt.want)
+		}
+	}
+}
+
+func TestSelectWeatherAPIRequiresKey(t *testing.T) {
+	t.Setenv("OWM_API_VERSION", "")
+	t.Setenv("INCLUDE_FORECAST", "")
+	t.Setenv("OWM_API_KEY", " ")
+
+	if _, err := selectWeatherAPI(testConfig(t)); err == nil || !strings.Contains(err.Error(), "missing OWM_API_KEY") {
+		t.Errorf("got error %v, want missing OWM_API_KEY", err)
+	}
+
+	t.Setenv("OWM_API_KEY", "test")
+
+	if _, err := selectWeatherAPI(testConfig(t)); err != nil {
+		t.Errorf("got error %v with a key set", err)
+	}
+}
+
+func TestSelectWeatherBaseURL(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"", defaultCurrentWeatherURL, false},
+		{" http://localhost:8080/data/2.5/weather/ ", "http://localhost:8080/data/2.5/weather", false},
+		{"https://proxy.internal/owm/weather", "https://proxy.internal/owm/weather", false},
+		{"localhost:8080/weather", "", true},
+		{"ftp://proxy.internal/weather", "", true},
+		{"http:///weather", "", true},
+		{"http://localhost:8080/weather?appid=abc", "", true},
+		{"http://localhost:8080/weather#current", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("OWM_BASE_URL", tt.env)
+
+		got, err := selectWeatherBaseURL(defaultCurrentWeatherURL)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("selectWeatherBaseURL with OWM_BASE_URL %q = %q, %v, want %q", tt.env, got, err, tt.want)
+		}
+	}
+}
+
+func TestWeatherAPIUsesBaseURL(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		fmt.Fprintf(w, `{"name":%q,"main":{"temp":12.5}}`, r.URL.Query().Get("q"))
+	}))
+	defer server.Close()
+
+	t.Setenv("OWM_API_VERSION", "")
+	t.Setenv("INCLUDE_FORECAST", "")
+	t.Setenv("OWM_API_KEY", "test")
+	t.Setenv("OWM_BASE_URL", server.URL+"/mock/weather")
+
+	provider, err := selectWeatherAPI(testConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cityWeather, err := provider.Fetch(context.Background(), "London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cityWeather.Name != "London" || cityWeather.Main.Temp != 12.5 {
+		t.Errorf("got %+v from the mock", cityWeather)
+	}
+
+	if len(paths) != 1 || paths[0] != "/mock/weather" {
+		t.Errorf("got requests %v, want one to /mock/weather", paths)
+	}
+}
+
+func TestFetchCityNotFound(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "test")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") != "Atlantis" {
+			fmt.Fprintf(w, `{"cod":200,"name":%q,"main":{"temp":12.5}}`, r.URL.Query().Get("q"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"cod":"404","message":"city not found"}`)
+	}))
+	defer server.Close()
+
+	api := CurrentWeatherAPI{Client: server.Client(), BaseURL: server.URL, MaxResponseBytes: defaultMaxResponseBytes}
+
+	if _, err := api.Fetch(context.Background(), "Atlantis"); err == nil || !strings.Contains(err.Error(), "404 city not found") {
+		t.Errorf("got error %v, want the api's 404 message", err)
+	}
+
+	p := &Processor{weatherProvider: api, metrics: multiRecorder{}, logger: log.New(io.Discard, "", 0)}
+
+	cities := make(chan string, 2)
+	cities <- "Atlantis"
+	cities <- "London"
+	close(cities)
+
+	var weatherList []Weather
+	if err := p.populateWeatherList(context.Background(), cities, map[string]int{}, Config{}, &weatherList); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(weatherList) != 1 || weatherList[0].Name != "London" {
+		t.Errorf("got %+v, want only London", weatherList)
+	}
+
+	if !reflect.DeepEqual(p.failedCities, []string{"Atlantis"}) {
+		t.Errorf("got failed cities %v, want Atlantis skipped", p.failedCities)
+	}
+}
+
+func TestCityTimeoutSkipsSlowCity(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "test")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		city := r.URL.Query().Get("q")
+		if city == "Slowtown" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+
+		fmt.Fprintf(w, `{"name":%q,"main":{"temp":12.5}}`, city)
+	}))
+	defer server.Close()
+
+	api := CurrentWeatherAPI{Client: server.Client(), BaseURL: server.URL, MaxResponseBytes: defaultMaxResponseBytes}
+	p := &Processor{weatherProvider: api, metrics: multiRecorder{}, logger: log.New(io.Discard, "", 0)}
+
+	cities := make(chan string, 3)
+	for _, city := range []string{"London", "Slowtown", "Paris"} {
+		cities <- city
+	}
+	close(cities)
+
+	start := time.Now()
+
+	var weatherList []Weather
+	err := p.populateWeatherList(context.Background(), cities, map[string]int{}, Config{CityTimeout: 50 * time.Millisecond}, &weatherList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v, want the slow city cancelled at its own timeout", elapsed)
+	}
+
+	if len(weatherList) != 2 || weatherList[0].Name != "London" || weatherList[1].Name != "Paris" {
+		t.Errorf("got %+v, want London and Paris", weatherList)
+	}
+
+	if !reflect.DeepEqual(p.failedCities, []string{"Slowtown"}) {
+		t.Errorf("got failed cities %v, want Slowtown skipped", p.failedCities)
+	}
+}
+
+func TestOWMLanguage(t *testing.T) {
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"", ""},
+		{"C", ""},
+		{"es", "es"},
+		{"fr_FR.UTF-8", "fr"},
+		{"pt_BR.UTF-8", "pt_br"},
+		{"ZH_CN", "zh_cn"},
+		{"xx_YY", ""},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("LANG", tt.lang)
+
+		if got := owmLanguage(); got != tt.want {
+			t.Errorf("owmLanguage() with LANG %q = %q, want %q", tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestFetchSendsLanguage(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "test")
+
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		fmt.Fprint(w, `{"name":"Madrid","weather":[{"description":"cielo claro"}]}`)
+	}))
+	defer server.Close()
+
+	api := CurrentWeatherAPI{Client: server.Client(), BaseURL: server.URL, MaxResponseBytes: defaultMaxResponseBytes}
+
+	for _, lang := range []string{"es_ES.UTF-8", ""} {
+		t.Setenv("LANG", lang)
+
+		if _, err := api.Fetch(context.Background(), "Madrid"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := queries[0]["lang"]; !reflect.DeepEqual(got, []string{"es"}) {
+		t.Errorf("got lang %v with LANG set, want [es]", got)
+	}
+
+	if _, ok := queries[1]["lang"]; ok {
+		t.Errorf("got lang %v with LANG unset, want none", queries[1]["lang"])
+	}
+}
+
+// recordedOneCall serves the recorded geocoding and One Call responses in testdata,
+//     keeping the query of each One Call request
+func recordedOneCall(t *testing.T, queries *[]url.Values) *httptest.Server {
+	t.Helper()
+
+	geocode, err := os.ReadFile(filepath.Join("testdata", "geocode.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oneCall, err := os.ReadFile(filepath.Join("testdata", "onecall.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/geo/1.0/direct":
+			w.Write(geocode)
+		case "/data/3.0/onecall":
+			*queries = append(*queries, r.URL.Query())
+			w.Write(oneCall)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestOneCallRecordedResponse(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "test")
+
+	for _, includeForecast := range []bool{true, false} {
+		var queries []url.Values
+		server := recordedOneCall(t, &queries)
+
+		api := OneCallAPI{
+			Client:           server.Client(),
+			BaseURL:          server.URL + "/data/3.0/onecall",
+			GeocodeURL:       server.URL + "/geo/1.0",
+			MaxResponseBytes: defaultMaxResponseBytes,
+			IncludeForecast:  includeForecast,
+		}
+
+		cityWeather, err := api.Fetch(context.Background(), "London")
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cityWeather.Name != "London" || cityWeather.Sys.Country != "GB" || cityWeather.Coord.Lat != 51.5073219 {
+			t.Errorf("got location %s %s %v", cityWeather.Name, cityWeather.Sys.Country, cityWeather.Coord)
+		}
+
+		if cityWeather.Main.Temp != 17.82 || cityWeather.Main.FeelsLike != 17.35 || cityWeather.Main.Humidity != 63 ||
+			cityWeather.Main.TempMin != 11.37 || cityWeather.Main.TempMax != 19.46 {
+			t.Errorf("got main %+v", cityWeather.Main)
+		}
+
+		if cityWeather.Wind.Speed != 4.63 || cityWeather.Wind.Degrees != 250 || primaryDescription(cityWeather) != "light rain" {
+			t.Errorf("got wind %+v and description %q", cityWeather.Wind, primaryDescription(cityWeather))
+		}
+
+		if cityWeather.Rain == nil || cityWeather.Rain.OneHour != 0.21 {
+			t.Errorf("got rain %v, want 0.21", cityWeather.Rain)
+		}
+
+		if len(queries) != 1 || queries[0].Get("lat") != "51.5073219" || queries[0].Get("lon") != "-0.1276474" {
+			t.Fatalf("got One Call queries %v", queries)
+		}
+
+		if includeForecast {
+			if cityWeather.ForecastTemp == nil || *cityWeather.ForecastTemp != 18.41 {
+				t.Errorf("got forecast %v, want hourly[1] 18.41", cityWeather.ForecastTemp)
+			}
+			if exclude := queries[0].Get("exclude"); exclude != "minutely,alerts" {
+				t.Errorf("got exclude %q, want hourly requested", exclude)
+			}
+		} else if cityWeather.ForecastTemp != nil {
+			t.Errorf("got forecast %v without INCLUDE_FORECAST", *cityWeather.ForecastTemp)
+		}
+	}
+}
+
+func TestInvalidKeyFailsFast(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "revoked")
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"cod":401, "message": "Invalid API key. Please see https://openweathermap.org/faq#error401 for more info."}`)
+	}))
+	defer server.Close()
+
+	api := CurrentWeatherAPI{Client: server.Client(), BaseURL: server.URL, MaxResponseBytes: defaultMaxResponseBytes, MaxRetries: 3}
+	p := &Processor{weatherProvider: api, metrics: multiRecorder{}, logger: log.New(io.Discard, "", 0)}
+
+	cities := make(chan string, 3)
+	for _, city := range []string{"London", "Paris", "Berlin"} {
+		cities <- city
+	}
+	close(cities)
+
+	var weatherList []Weather
+	err := p.populateWeatherList(context.Background(), cities, map[string]int{}, Config{}, &weatherList)
+	if !errors.Is(err, ErrInvalidKey) || !strings.Contains(err.Error(), "invalid API key") {
+		t.Errorf("got error %v, want ErrInvalidKey", err)
+	}
+
+	if requests != 1 {
+		t.Errorf("got %d requests, want the run stopped after the first 401 without retrying", requests)
+	}
+
+	if len(weatherList) != 0 || len(p.failedCities) != 0 {
+		t.Errorf("got %d cities and skipped %v, want neither", len(weatherList), p.failedCities)
+	}
+}
+
+func TestUserAgentHeader(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "test")
+
+	var agents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.Header.Get("User-Agent"))
+		fmt.Fprint(w, `{"name":"London","main":{"temp":12.5}}`)
+	}))
+	defer server.Close()
+
+	api := CurrentWeatherAPI{Client: server.Client(), BaseURL: server.URL, MaxResponseBytes: defaultMaxResponseBytes}
+
+	for _, agent := range []string{"", " weather-reports/2.3 (ops@example.com) "} {
+		t.Setenv("HTTP_USER_AGENT", agent)
+
+		if _, err := api.Fetch(context.Background(), "London"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{defaultUserAgent, "weather-reports/2.3 (ops@example.com)"}
+	if !reflect.DeepEqual(agents, want) {
+		t.Errorf("got User-Agent %q, want %q", agents, want)
+	}
+}
+
+func TestCancelMidRun(t *testing.T) {
+	t.Setenv("OWM_API_KEY", "test")
+
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	var mu sync.Mutex
+	var requested []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		city := r.URL.Query().Get("q")
+
+		mu.Lock()
+		requested = append(requested, city)
+		mu.Unlock()
+
+		if city == "Slowtown" {
+			close(started)
+			select {
+			case <-r.Context().Done():
+				close(aborted)
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+
+		fmt.Fprintf(w, `{"name":%q,"main":{"temp":12.5}}`, city)
+	}))
+	defer server.Close()
+
+	api := CurrentWeatherAPI{Client: server.Client(), BaseURL: server.URL, MaxResponseBytes: defaultMaxResponseBytes}
+	p := &Processor{weatherProvider: api, metrics: multiRecorder{}, logger: log.New(io.Discard, "", 0)}
+
+	cities := make(chan string, 4)
+	for _, city := range []string{"London", "Slowtown", "Paris", "Rome"} {
+		cities <- city
+	}
+	close(cities)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	start := time.Now()
+
+	var weatherList []Weather
+	err := p.populateWeatherList(ctx, cities, map[string]int{}, Config{}, &weatherList)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v, want a prompt return once cancelled", elapsed)
+	}
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Error("the in-flight request was not cancelled")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !reflect.DeepEqual(requested, []string{"London", "Slowtown"}) {
+		t.Errorf("got requests %v, want nothing fetched after the cancel", requested)
+	}
+
+	// The cancelled city is not the city's fault, so it is not recorded as failed
+	if len(p.failedCities) != 0 {
+		t.Errorf("got failed cities %v, want none", p.failedCities)
+	}
+}
+
+func TestProcessCancelled(t *testing.T) {
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("London\nParis\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+		logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	response, err := p.Process(ctx, "cities.csv", "")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+
+	if response.StatusCode != "400" {
+		t.Errorf("got status %s, want 400", response.StatusCode)
+	}
+
+	if len(provider.calls) != 0 {
+		t.Errorf("got api calls %v after the invocation was cancelled", provider.calls)
+	}
+
+	if _, ok := client.objects["output/highest_temperatures.csv"]; ok {
+		t.Error("got a report written by a cancelled run")
+	}
+}
@@ -0,0 +1,1819 @@
+package weather
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestInputName(t *testing.T) {
+	tests := []struct {
+		city string
+		want string
+	}{
+		{"94040,us", "94040"},
+		{"94040-1234,us", "94040-1234"},
+		{"SW1A,gb", "SW1A,gb"},
+		{"London", "London"},
+		{"Washington, DC", "Washington, DC"},
+		{"Paris,FR", "Paris,FR"},
+	}
+
+	for _, tt := range tests {
+		if got := inputName(tt.city); got != tt.want {
+			t.Errorf("inputName(%q) = %q, want %q", tt.city, got, tt.want)
+		}
+	}
+}
+
+func TestLocationQuery(t *testing.T) {
+	tests := []struct {
+		city string
+		want string
+	}{
+		{"94040,us", "zip=94040%2Cus"},
+		{"London", "q=London"},
+		{"Washington, DC", "q=Washington%2C+DC"},
+	}
+
+	for _, tt := range tests {
+		if got := locationQuery(tt.city); got != tt.want {
+			t.Errorf("locationQuery(%q) = %q, want %q", tt.city, got, tt.want)
+		}
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		binary      bool
+	}{
+		{"csv", "text/csv", false},
+		{"json", "application/json", false},
+		{"ndjson", "application/x-ndjson", false},
+		{"msgpack", "application/x-msgpack", true},
+		{"parquet", "application/vnd.apache.parquet", true},
+	}
+
+	for _, tt := range tests {
+		format, ok := outputFormats[tt.name]
+		if !ok {
+			t.Errorf("missing output format %s", tt.name)
+			continue
+		}
+
+		if format.Extension != tt.name || format.ContentType != tt.contentType || format.Binary != tt.binary {
+			t.Errorf("%s: got extension %s, content type %s, binary %v", tt.name, format.Extension, format.ContentType, format.Binary)
+		}
+	}
+}
+
+func TestDegreesToCompass(t *testing.T) {
+	tests := []struct {
+		degrees int
+		want    string
+	}{
+		{0, "N"},
+		{10, "N"},
+		{11, "NNE"},
+		{22, "NNE"},
+		{32, "NNE"},
+		{33, "NE"},
+		{45, "NE"},
+		{56, "ENE"},
+		{90, "E"},
+		{101, "ESE"},
+		{135, "SE"},
+		{168, "S"},
+		{180, "S"},
+		{191, "SSW"},
+		{225, "SW"},
+		{258, "W"},
+		{270, "W"},
+		{281, "WNW"},
+		{315, "NW"},
+		{325, "NW"},
+		{326, "NNW"},
+		{347, "NNW"},
+		{348, "N"},
+		{359, "N"},
+		{360, "N"},
+		{372, "NNE"},
+		{-12, "N"},
+		{-13, "NNW"},
+		{-90, "W"},
+	}
+
+	for _, tt := range tests {
+		if got := degreesToCompass(tt.degrees); got != tt.want {
+			t.Errorf("degreesToCompass(%d) = %s, want %s", tt.degrees, got, tt.want)
+		}
+	}
+}
+
+func TestFilterByCountry(t *testing.T) {
+	t.Setenv("USE_INPUT_NAME", "")
+
+	weatherList := []Weather{{Name: "London"}, {Name: "Paris"}, {Name: "Leeds"}}
+	weatherList[0].Sys.Country = "GB"
+	weatherList[1].Sys.Country = "FR"
+	weatherList[2].Sys.Country = " gb "
+
+	response := Response{StatusCode: "200", Filtered: []string{"Atlantis"}}
+
+	kept, dropped := filterByCountry(weatherList, "gb")
+	response.CountryFiltered = dropped
+
+	if len(kept) != 2 || kept[0].Name != "London" || kept[1].Name != "Leeds" {
+		t.Errorf("got kept %v", kept)
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Country filtering is reported apart from the allowlist and denylist
+	for _, want := range []string{`"countryFilteredCities":["Paris"]`, `"filtered":["Atlantis"]`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("got %s, want it to contain %s", body, want)
+		}
+	}
+
+	if kept, dropped := filterByCountry(weatherList, ""); len(kept) != 3 || dropped != nil {
+		t.Errorf("empty country: got kept %d, dropped %v", len(kept), dropped)
+	}
+}
+
+// syntheticCities generates a comma separated city file on the fly, cycling through distinct city names
+type syntheticCities struct {
+	next     int
+	count    int
+	distinct int
+	pending  []byte
+}
+
+func (s *syntheticCities) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.next == s.count {
+			return 0, io.EOF
+		}
+
+		s.pending = []byte(fmt.Sprintf("City %d,\n", s.next%s.distinct))
+		s.next++
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+
+	return n, nil
+}
+
+// BenchmarkExtractCities runs a 100k row local input file through extractCities and populateWeatherList
+//     as processWeather does, half of the rows repeating a city already fetched
+func BenchmarkExtractCities(b *testing.B) {
+	const cityCount, distinct = 100000, 50000
+
+	path := filepath.Join(b.TempDir(), "cities.csv")
+	file, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := io.Copy(file, &syntheticCities{count: cityCount, distinct: distinct}); err != nil {
+		b.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	provider := StaticProvider{Weather: make(map[string]Weather, distinct)}
+	for i := 0; i < distinct; i++ {
+		name := fmt.Sprintf("City %d", i)
+		provider.Weather[normalizeCity(name)] = Weather{Name: name}
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		p := &Processor{localInput: true, uploadKey: path, config: config, weatherProvider: provider, metrics: multiRecorder{},
+			logger: log.New(io.Discard, "", 0)}
+
+		cities := make(chan string, cityBufferSize)
+		extractErr := make(chan error, 1)
+		filtered := make([]string, 0)
+
+		go func() {
+			defer close(cities)
+			extractErr <- p.extractCities(context.Background(), cities, &filtered)
+		}()
+
+		weatherList := make([]Weather, 0)
+		if err := p.populateWeatherList(context.Background(), cities, map[string]int{}, config, &weatherList); err != nil {
+			b.Fatal(err)
+		}
+
+		if err := <-extractErr; err != nil {
+			b.Fatal(err)
+		}
+
+		if len(weatherList) != cityCount {
+			b.Fatalf("got %d cities, want %d", len(weatherList), cityCount)
+		}
+	}
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	forecast := 19.5
+	rows := []TemperatureOutput{
+		{Rank: 1, City: "Cairo", Lat: 30.06, Lon: 31.25, Temperature: 31.01, FeelsLike: 30.44, Description: "few clouds", ForecastTemp: &forecast},
+		{Rank: 2, City: "São Paulo", Lat: -23.55, Lon: -46.63, Temperature: 24.5, TempMin: 22, TempMax: 26.25},
+	}
+
+	body, err := outputFormats["msgpack"].Marshal(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := make([]TemperatureOutput, 0)
+	if err := msgpack.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, rows) {
+		t.Errorf("got %+v, want %+v", decoded, rows)
+	}
+}
+
+func TestCheckOutputKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		want string
+	}{
+		{"empty", nil, ""},
+		{"distinct", []string{"highest_temperatures.csv", "lowest_temperatures.csv", "highest_wind.csv"}, ""},
+		{"one collision", []string{"highest_wind.csv", "summary.csv", "highest_wind.csv"}, "duplicate output keys! highest_wind.csv"},
+		{"every collision listed", []string{"a.csv", "b.csv", "a.csv", "b.csv"}, "duplicate output keys! a.csv, b.csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkOutputKeys(tt.keys)
+			if got := fmt.Sprint(err); (err == nil && tt.want != "") || (err != nil && got != tt.want) {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessOutputKeysAcrossWriters(t *testing.T) {
+	// Every writer is enabled at once, with paginated reports, the summary, the dump, history and the manifest
+	t.Setenv("OUTPUT_ALL", "true")
+	t.Setenv("SORT_BY", "city")
+	t.Setenv("MAX_ROWS_PER_FILE", "1")
+	t.Setenv("REPORT_DIRECTION", "both")
+	t.Setenv("ENABLE_HISTORY", "true")
+
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("Lima\nCairo\nOslo\nParis\n")
+
+	p := &Processor{s3Client: client, weatherProvider: &countingProvider{calls: map[string]int{}}, inputBucket: "input",
+		outputBucket: "output", logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Staged uploads are copied into place, no object is copied twice so no writer overwrote another
+	written := make(map[string]bool)
+	for _, call := range client.calls {
+		if !strings.HasPrefix(call, "copy output/") {
+			continue
+		}
+
+		if written[call] {
+			t.Errorf("got %s twice", call)
+		}
+		written[call] = true
+	}
+
+	for _, key := range []string{"output/summary/part-0001.csv", "output/all_weather/part-0004.csv", "output/history.csv",
+		"output/run_metadata/cities.json", "output/lowest_temperatures/part-0003.csv"} {
+		if !written["copy "+key] {
+			t.Errorf("got no %s", key)
+		}
+	}
+}
+
+func TestNewProcessorRequiresBuckets(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		output string
+		want   string
+	}{
+		{"both missing", "", "", "INPUT_BUCKET"},
+		{"input missing", "", "weather-output-bucket", "INPUT_BUCKET"},
+		{"input blank", "  ", "weather-output-bucket", "INPUT_BUCKET"},
+		{"output missing", "weather-input-bucket", "", "OUTPUT_BUCKET"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("INPUT_BUCKET", tt.input)
+			t.Setenv("OUTPUT_BUCKET", tt.output)
+
+			_, err := NewProcessor(context.Background())
+			if err == nil || err.Error() != "missing required environment variable! "+tt.want {
+				t.Errorf("got %v, want the missing %s error", err, tt.want)
+			}
+		})
+	}
+}
+
+// countryWeather builds cities with a temperature and the country code returned by the api
+func countryWeather(cities map[string]string, temps map[string]float64) []Weather {
+	names := make([]string, 0, len(cities))
+	for name := range cities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	weatherList := make([]Weather, 0, len(names))
+	for _, name := range names {
+		city := Weather{Name: name}
+		city.Sys.Country = cities[name]
+		city.Main.Temp = temps[name]
+		weatherList = append(weatherList, city)
+	}
+
+	return weatherList
+}
+
+func TestGroupByCountry(t *testing.T) {
+	t.Setenv("OUTPUT_PREFIX", "")
+	t.Setenv("USE_INPUT_NAME", "")
+
+	weatherList := countryWeather(
+		map[string]string{"London": "GB", "Leeds": "GB", "Paris": "FR", "Lyon": "FR", "Nice": "FR", "Atlantis": ""},
+		map[string]float64{"London": 14, "Leeds": 11, "Paris": 17, "Lyon": 19, "Nice": 21, "Atlantis": 30},
+	)
+
+	groups := groupByCountry(weatherList)
+
+	sizes := map[string]int{}
+	for country, cities := range groups {
+		sizes[country] = len(cities)
+	}
+
+	if !reflect.DeepEqual(sizes, map[string]int{"GB": 2, "FR": 3, "unknown": 1}) {
+		t.Errorf("got group sizes %v", sizes)
+	}
+
+	config := Config{Metrics: []string{"temp"}, WriteHighest: true, Format: outputFormats["csv"]}
+	reportFiles := planReports(groups, metricReports(Config{WindUnit: "m/s"}), config, "")
+
+	got := map[string]string{}
+	for _, report := range reportFiles {
+		got[report.Key] = report.Rows.([]TemperatureOutput)[0].City
+	}
+
+	want := map[string]string{
+		"highest_temperatures.FR.csv":      "Nice",
+		"highest_temperatures.GB.csv":      "London",
+		"highest_temperatures.unknown.csv": "Atlantis",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got hottest city per report %v, want %v", got, want)
+	}
+}
+
+// countingProvider returns a fixed temperature per city and counts the lookups of each,
+//     cities listed in failing are not found
+type countingProvider struct {
+	calls   map[string]int
+	failing map[string]bool
+}
+
+func (f *countingProvider) Fetch(ctx context.Context, location string) (Weather, error) {
+	f.calls[location]++
+
+	if f.failing[location] {
+		return Weather{}, fmt.Errorf("weather lookup failed for %s! 404 city not found", location)
+	}
+
+	city := Weather{Name: strings.TrimSpace(location)}
+	city.Main.Temp = float64(len(location))
+
+	return city, nil
+}
+
+func TestPopulateWeatherListFetchesRepeatedCityOnce(t *testing.T) {
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{weatherProvider: provider, metrics: multiRecorder{}, logger: log.New(io.Discard, "", 0)}
+
+	cities := make(chan string, 4)
+	for _, city := range []string{"London", "Paris", "london ", "London"} {
+		cities <- city
+	}
+	close(cities)
+
+	var weatherList []Weather
+	if err := p.populateWeatherList(context.Background(), cities, map[string]int{}, Config{}, &weatherList); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(weatherList) != 4 {
+		t.Errorf("got %d cities, want every input row kept", len(weatherList))
+	}
+
+	if !reflect.DeepEqual(provider.calls, map[string]int{"London": 1, "Paris": 1}) {
+		t.Errorf("got api calls %v, want one per distinct city", provider.calls)
+	}
+}
+
+// extractLocal writes contents to a local input file and returns the cities extractCities sends for it
+func extractLocal(t *testing.T, name string, contents string) ([]string, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Processor{localInput: true, uploadKey: path, config: testConfig(t), logger: log.New(io.Discard, "", 0)}
+
+	cities := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		var filtered []string
+		done <- p.extractCities(context.Background(), cities, &filtered)
+		close(cities)
+	}()
+
+	got := make([]string, 0)
+	for city := range cities {
+		got = append(got, city)
+	}
+
+	return got, <-done
+}
+
+func TestExtractCitiesZipCodes(t *testing.T) {
+	tests := []struct {
+		country string
+		want    []string
+	}{
+		{"", []string{"94040,us", "London", "10001-1234,us", "Paris"}},
+		{" GB ", []string{"94040,gb", "London", "10001-1234,gb", "Paris"}},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("ZIP_COUNTRY_CODE", tt.country)
+
+		got, err := extractLocal(t, "cities.csv", "94040\nLondon\n10001-1234\nParis\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ZIP_COUNTRY_CODE %q: got %q, want %q", tt.country, got, tt.want)
+		}
+
+		for i, city := range got {
+			want := "q="
+			if i%2 == 0 {
+				want = "zip="
+			}
+
+			if query := locationQuery(city); !strings.HasPrefix(query, want) {
+				t.Errorf("locationQuery(%q) = %q, want a %s query", city, query, want)
+			}
+		}
+	}
+}
+
+func TestProcessEmptyUpload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cities.csv")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{localInput: true, weatherProvider: provider, logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	response, err := p.Process(context.Background(), path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != "200" || response.StatusMessage != "no cities found in input file" {
+		t.Errorf("got status %s %q, want 200 no cities found in input file", response.StatusCode, response.StatusMessage)
+	}
+
+	if len(response.TopTemperatures) != 0 || len(provider.calls) != 0 {
+		t.Errorf("got %d ranked cities and api calls %v from an empty file", len(response.TopTemperatures), provider.calls)
+	}
+}
+
+func TestProcessDryRunMakesNoWrites(t *testing.T) {
+	for _, mode := range []string{"delete", "archive"} {
+		t.Run(mode, func(t *testing.T) {
+			t.Setenv("DRY_RUN", "true")
+			t.Setenv("CLEANUP_MODE", mode)
+
+			client := newFakeS3()
+			client.objects["input/cities.csv"] = []byte("London\nParis\n")
+
+			provider := &countingProvider{calls: map[string]int{}}
+			p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+				logger: log.New(io.Discard, "", 0)}
+
+			p.config = testConfig(t)
+			response, err := p.Process(context.Background(), "cities.csv", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(response.TopTemperatures) != 2 || response.TopTemperatures[0].City != "London" {
+				t.Errorf("got top temperatures %+v, want the computed results returned", response.TopTemperatures)
+			}
+
+			if !reflect.DeepEqual(client.calls, []string{"get input/cities.csv"}) {
+				t.Errorf("got s3 calls %v, want only the input read", client.calls)
+			}
+		})
+	}
+}
+
+func TestRunCleanup(t *testing.T) {
+	tests := []struct {
+		mode    string
+		prefix  string
+		calls   []string
+		objects []string
+	}{
+		{"delete", "", []string{"delete input/uploads/cities.csv"}, []string{}},
+		{"archive", "", []string{"copy input/archive/uploads/cities.csv", "delete input/uploads/cities.csv"},
+			[]string{"input/archive/uploads/cities.csv"}},
+		{"archive", "old/", []string{"copy input/old/uploads/cities.csv", "delete input/uploads/cities.csv"},
+			[]string{"input/old/uploads/cities.csv"}},
+		{"none", "", nil, []string{"input/uploads/cities.csv"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+tt.prefix, func(t *testing.T) {
+			t.Setenv("DRY_RUN", "")
+			t.Setenv("ARCHIVE_PREFIX", tt.prefix)
+
+			client := newFakeS3()
+			client.objects["input/uploads/cities.csv"] = []byte("London\n")
+
+			p := &Processor{s3Client: client, inputBucket: "input", uploadKey: "uploads/cities.csv", config: testConfig(t), logger: log.New(io.Discard, "", 0)}
+
+			if err := p.runCleanup(context.Background(), tt.mode); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(client.calls, tt.calls) {
+				t.Errorf("got calls %v, want %v", client.calls, tt.calls)
+			}
+
+			objects := make([]string, 0)
+			for object := range client.objects {
+				objects = append(objects, object)
+			}
+
+			if !reflect.DeepEqual(objects, tt.objects) {
+				t.Errorf("got objects %v, want %v", objects, tt.objects)
+			}
+		})
+	}
+}
+
+func TestSelectCleanupMode(t *testing.T) {
+	for value, want := range map[string]string{"": "delete", "Delete": "delete", " archive ": "archive", "none": "none"} {
+		t.Setenv("CLEANUP_MODE", value)
+		if got, err := selectCleanupMode(); err != nil || got != want {
+			t.Errorf("selectCleanupMode(%q) = %q, %v, want %q", value, got, err, want)
+		}
+	}
+
+	t.Setenv("CLEANUP_MODE", "move")
+	if _, err := selectCleanupMode(); err == nil {
+		t.Error("selectCleanupMode(move) returned no error")
+	}
+}
+
+func TestSanitizeCity(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+		ok    bool
+	}{
+		{"  London ", "London", true},
+		{"New   York", "New York", true},
+		{"São Paulo", "São Paulo", true},
+		{"Zürich\t", "Zürich", true},
+		{"", "", false},
+		{"   ", "", false},
+		{"Paris\x00", "", false},
+		{"Lon\x1bdon", "", false},
+		{"Lima\xff", "", false},
+		{strings.Repeat("a", maxCityLength), strings.Repeat("a", maxCityLength), true},
+		{strings.Repeat("a", maxCityLength+1), "", false},
+		{strings.Repeat("ã", maxCityLength), strings.Repeat("ã", maxCityLength), true},
+	}
+
+	for _, tt := range tests {
+		got, ok := sanitizeCity(tt.token)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("sanitizeCity(%q) = %q, %v, want %q, %v", tt.token, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLocationQueryEscapesCity(t *testing.T) {
+	tests := []struct {
+		city string
+		want string
+	}{
+		{"São Paulo", "q=S%C3%A3o+Paulo"},
+		{"London&appid=stolen", "q=London%26appid%3Dstolen"},
+		{"Paris#frag", "q=Paris%23frag"},
+		{"Rome?units=imperial", "q=Rome%3Funits%3Dimperial"},
+		{"'; DROP TABLE cities;--", "q=%27%3B+DROP+TABLE+cities%3B--"},
+	}
+
+	for _, tt := range tests {
+		query := locationQuery(tt.city)
+		if query != tt.want {
+			t.Errorf("locationQuery(%q) = %q, want %q", tt.city, query, tt.want)
+			continue
+		}
+
+		// The city must round trip as a single parameter without adding any of its own
+		values, err := url.ParseQuery(query)
+		if err != nil || len(values) != 1 || values.Get("q") != tt.city {
+			t.Errorf("locationQuery(%q) parses as %v, %v", tt.city, values, err)
+		}
+	}
+}
+
+func TestExtractCitiesJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		format   string
+		contents string
+	}{
+		{"strings", "cities.json", "", `["London", "Paris", "São Paulo"]`},
+		{"objects", "cities.json", "", `[{"city": "London"}, {"city": "Paris", "country": "FR"}, {"city": "São Paulo"}]`},
+		{"mixed", "cities.json", "", "[\"London\",\n{\"city\": \"Paris\"},\n\"São Paulo\"]\n"},
+		{"INPUT_FORMAT", "cities.txt", "json", `["London", "Paris", "São Paulo"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("INPUT_FORMAT", tt.format)
+
+			got, err := extractLocal(t, tt.file, tt.contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := []string{"London", "Paris", "São Paulo"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestExtractCitiesInvalidJSON(t *testing.T) {
+	t.Setenv("INPUT_FORMAT", "")
+
+	for _, contents := range []string{`{"city": "London"}`, `[42]`, `["London"`} {
+		if _, err := extractLocal(t, "cities.json", contents); !errors.Is(err, ErrInputRead) {
+			t.Errorf("%s: got error %v, want ErrInputRead", contents, err)
+		}
+	}
+}
+
+func TestCityAllowedOverlappingLists(t *testing.T) {
+	allowlist := cityList("London, Paris ,Berlin")
+	denylist := cityList("paris,Moscow")
+
+	tests := []struct {
+		city string
+		want bool
+	}{
+		{"London", true},
+		{"berlin", true},
+		{"Paris", false},
+		{"PARIS", false},
+		{"Moscow", false},
+		{"Madrid", false},
+	}
+
+	for _, tt := range tests {
+		if got := cityAllowed(tt.city, allowlist, denylist); got != tt.want {
+			t.Errorf("cityAllowed(%q) = %v, want %v", tt.city, got, tt.want)
+		}
+	}
+
+	// Without an allowlist only the denylist applies
+	if !cityAllowed("Madrid", cityList(""), denylist) || cityAllowed("Moscow", cityList(" "), denylist) {
+		t.Error("an empty allowlist should allow every city not denied")
+	}
+}
+
+func TestProcessReportsFilteredCities(t *testing.T) {
+	t.Setenv("DRY_RUN", "true")
+	t.Setenv("CITY_ALLOWLIST", "London,Paris,Berlin")
+	t.Setenv("CITY_DENYLIST", "Paris,Moscow")
+
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("London\nParis\nMoscow\nMadrid\nBerlin\n")
+
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+		logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	response, err := p.Process(context.Background(), "cities.csv", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"Paris", "Moscow", "Madrid"}; !reflect.DeepEqual(response.Filtered, want) {
+		t.Errorf("got filtered %v, want %v", response.Filtered, want)
+	}
+
+	if !reflect.DeepEqual(provider.calls, map[string]int{"London": 1, "Berlin": 1}) {
+		t.Errorf("got api calls %v, want only the allowed cities", provider.calls)
+	}
+}
+
+func TestCheckMinSuccess(t *testing.T) {
+	tests := []struct {
+		name       string
+		minCount   int
+		minPercent float64
+		succeeded  int
+		failed     int
+		wantErr    bool
+	}{
+		{"no minimum", 0, 0, 1, 49, false},
+		{"count met exactly", 10, 0, 10, 40, false},
+		{"count one short", 10, 0, 9, 41, true},
+		{"count with no cities", 1, 0, 0, 0, true},
+		{"percent met exactly", 0, 80, 40, 10, false},
+		{"percent just under", 0, 80, 39, 11, true},
+		{"fractional percent met", 0, 33.3, 1, 2, false},
+		{"fractional percent under", 0, 33.4, 1, 2, true},
+		{"percent with no cities", 0, 80, 0, 0, false},
+		{"all required", 0, 100, 49, 1, true},
+	}
+
+	for _, tt := range tests {
+		err := checkMinSuccess(tt.minCount, tt.minPercent, tt.succeeded, tt.failed)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSelectMinSuccess(t *testing.T) {
+	tests := []struct {
+		value   string
+		count   int
+		percent float64
+		wantErr bool
+	}{
+		{"", 0, 0, false},
+		{"10", 10, 0, false},
+		{" 80% ", 0, 80, false},
+		{"100%", 0, 100, false},
+		{"0", 0, 0, true},
+		{"-1", 0, 0, true},
+		{"0%", 0, 0, true},
+		{"101%", 0, 0, true},
+		{"half", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("MIN_SUCCESS", tt.value)
+
+		count, percent, err := selectMinSuccess()
+		if count != tt.count || percent != tt.percent || (err != nil) != tt.wantErr {
+			t.Errorf("MIN_SUCCESS %q: got %d, %g, %v", tt.value, count, percent, err)
+		}
+	}
+}
+
+func TestProcessBelowMinSuccess(t *testing.T) {
+	tests := []struct {
+		minSuccess string
+		wantErr    bool
+	}{
+		{"2", false},
+		{"3", true},
+		{"66%", false},
+		{"67%", true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("MIN_SUCCESS", tt.minSuccess)
+
+		client := newFakeS3()
+		client.objects["input/cities.csv"] = []byte("London\nAtlantis\nParis\n")
+
+		provider := &countingProvider{calls: map[string]int{}, failing: map[string]bool{"Atlantis": true}}
+		p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+			logger: log.New(io.Discard, "", 0)}
+
+		p.config = testConfig(t)
+		response, err := p.Process(context.Background(), "cities.csv", "")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("MIN_SUCCESS %s: got error %v, want error %v", tt.minSuccess, err, tt.wantErr)
+		}
+
+		_, written := client.objects["output/highest_temperatures.csv"]
+		if written == tt.wantErr {
+			t.Errorf("MIN_SUCCESS %s: got status %s and report written %v", tt.minSuccess, response.StatusCode, written)
+		}
+	}
+}
+
+func TestCSVHeader(t *testing.T) {
+	rows := []HumidityOutput{{City: "Oslo", Humidity: 81}, {City: "Cairo", Humidity: 23}}
+
+	tests := []struct {
+		header string
+		titles string
+		want   string
+	}{
+		{"", "", "City,Humidity\nOslo,81\nCairo,23\n"},
+		{"true", "", "City,Humidity\nOslo,81\nCairo,23\n"},
+		{"false", "", "Oslo,81\nCairo,23\n"},
+		{"", "City=Location, Humidity=Humidity %", "Location,Humidity %\nOslo,81\nCairo,23\n"},
+		{"false", "City=Location", "Oslo,81\nCairo,23\n"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("OUTPUT_FORMAT", "csv")
+		t.Setenv("OUTPUT_DELIMITER", "")
+		t.Setenv("OUTPUT_HEADER", tt.header)
+		t.Setenv("OUTPUT_COLUMN_TITLES", tt.titles)
+
+		format, err := selectOutputFormat()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := format.Marshal(rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(body) != tt.want {
+			t.Errorf("OUTPUT_HEADER %q, OUTPUT_COLUMN_TITLES %q: got %q, want %q", tt.header, tt.titles, body, tt.want)
+		}
+	}
+}
+
+func TestCSVHeaderInvalid(t *testing.T) {
+	t.Setenv("OUTPUT_FORMAT", "csv")
+
+	for header, titles := range map[string]string{"maybe": "", "": "City", "false": "=Location"} {
+		t.Setenv("OUTPUT_HEADER", header)
+		t.Setenv("OUTPUT_COLUMN_TITLES", titles)
+
+		if _, err := selectOutputFormat(); err == nil {
+			t.Errorf("OUTPUT_HEADER %q, OUTPUT_COLUMN_TITLES %q: got no error", header, titles)
+		}
+	}
+}
+
+func TestProcessManifest(t *testing.T) {
+	t.Setenv("DRY_RUN", "true")
+	t.Setenv("INPUT_IS_MANIFEST", "true")
+
+	client := newFakeS3()
+	client.objects["input/manifest.txt"] = []byte("cities/europe.csv\n\nmanifest.txt\ncities/africa.json\ncities/europe.csv\n")
+	client.objects["input/cities/europe.csv"] = []byte("London\nParis\n")
+	client.objects["input/cities/africa.json"] = []byte(`["Cairo", {"city": "Nairobi"}]`)
+
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+		logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	if _, err := p.Process(context.Background(), "manifest.txt", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"get input/manifest.txt", "get input/cities/europe.csv", "get input/cities/africa.json"}
+	if !reflect.DeepEqual(client.calls, want) {
+		t.Errorf("got s3 calls %v, want %v", client.calls, want)
+	}
+
+	if !reflect.DeepEqual(provider.calls, map[string]int{"London": 1, "Paris": 1, "Cairo": 1, "Nairobi": 1}) {
+		t.Errorf("got api calls %v, want the cities of both files", provider.calls)
+	}
+}
+
+func TestProcessPerReportBuckets(t *testing.T) {
+	tests := []struct {
+		temperatureBucket string
+		windBucket        string
+		want              []string
+	}{
+		{"", "", []string{"output/highest_temperatures.csv", "output/highest_wind.csv"}},
+		{"temperature-reports", "", []string{"output/highest_wind.csv", "temperature-reports/highest_temperatures.csv"}},
+		{"temperature-reports", " wind-reports ", []string{"temperature-reports/highest_temperatures.csv", "wind-reports/highest_wind.csv"}},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("TEMPERATURE_OUTPUT_BUCKET", tt.temperatureBucket)
+		t.Setenv("WIND_OUTPUT_BUCKET", tt.windBucket)
+		t.Setenv("CLEANUP_MODE", "none")
+
+		client := newFakeS3()
+		client.objects["input/cities.csv"] = []byte("London\nParis\n")
+
+		p := &Processor{s3Client: client, weatherProvider: &countingProvider{calls: map[string]int{}},
+			inputBucket: "input", outputBucket: "output", logger: log.New(io.Discard, "", 0)}
+
+		p.config = testConfig(t)
+		if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		var reports []string
+		for object := range client.objects {
+			if strings.Contains(object, "highest_") {
+				reports = append(reports, object)
+			}
+		}
+		sort.Strings(reports)
+
+		if !reflect.DeepEqual(reports, tt.want) {
+			t.Errorf("TEMPERATURE_OUTPUT_BUCKET %q, WIND_OUTPUT_BUCKET %q: got reports %v, want %v",
+				tt.temperatureBucket, tt.windBucket, reports, tt.want)
+		}
+	}
+}
+
+// gzipped compresses contents the way an uploaded cities.csv.gz would be
+func gzipped(t *testing.T, contents string) string {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.String()
+}
+
+func TestExtractCitiesGzip(t *testing.T) {
+	t.Setenv("INPUT_FORMAT", "")
+
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"cities.csv.gz", gzipped(t, "London\nSão Paulo\n\"Washington, DC\"\n")},
+		// Detected from the magic bytes even without the extension
+		{"cities.csv", gzipped(t, "London\nSão Paulo\n\"Washington, DC\"\n")},
+		{"cities.json.gz", gzipped(t, `["London", "São Paulo", {"city": "Washington, DC"}]`)},
+		// Plain files are read unchanged
+		{"plain.csv", "London\nSão Paulo\n\"Washington, DC\"\n"},
+	}
+
+	for _, tt := range tests {
+		got, err := extractLocal(t, tt.name, tt.contents)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if want := []string{"London", "São Paulo", "Washington, DC"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestExtractCitiesCorruptGzip(t *testing.T) {
+	contents := gzipped(t, "London\nParis\n")
+
+	if _, err := extractLocal(t, "cities.csv.gz", contents[:len(contents)-6]); !errors.Is(err, ErrInputRead) {
+		t.Errorf("got %v, want ErrInputRead for a truncated archive", err)
+	}
+}
+
+func TestProcessReportsDuration(t *testing.T) {
+	t.Setenv("DRY_RUN", "true")
+
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("London\nParis\n")
+
+	p := &Processor{s3Client: client, weatherProvider: &countingProvider{calls: map[string]int{}},
+		inputBucket: "input", outputBucket: "output", logger: log.New(io.Discard, "", 0)}
+	p.SetStartTime(time.Now().Add(-25 * time.Millisecond))
+
+	p.config = testConfig(t)
+	response, err := p.Process(context.Background(), "cities.csv", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.DurationMs < 25 {
+		t.Errorf("got durationMs %d, want at least the 25ms since the start time", response.DurationMs)
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), fmt.Sprintf(`"durationMs":%d`, response.DurationMs)) {
+		t.Errorf("got response %s without durationMs", body)
+	}
+}
+
+func TestReadDelimitedCities(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"one per line", "London\nParis\n", []string{"London", "Paris"}},
+		{"bare list", "London, Paris,Berlin", []string{"London", "Paris", "Berlin"}},
+		{"trailing commas", "London,\nParis,,\n", []string{"London", "Paris"}},
+		{"quoted comma", "\"Washington, DC\"\nLondon\n", []string{"Washington, DC", "London"}},
+		{"quoted and bare", "London, \"Washington, DC\", Paris", []string{"London", "Washington, DC", "Paris"}},
+		{"escaped quote", "\"Val d\"\"Or, QC\",Oslo", []string{"Val d\"Or, QC", "Oslo"}},
+		{"crlf", "London,Paris\r\n\"Portland, OR\"\r\n", []string{"London", "Paris", "Portland, OR"}},
+		{"blank lines", "\n\nLondon\n\n", []string{"London"}},
+	}
+
+	for _, tt := range tests {
+		got := make([]string, 0)
+		err := readDelimitedCities(strings.NewReader(tt.input), func(token string) error {
+			got = append(got, token)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProcessStrictMode(t *testing.T) {
+	tests := []struct {
+		strict  string
+		wantErr bool
+	}{
+		{"", false},
+		{"false", false},
+		{"true", true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("STRICT", tt.strict)
+
+		client := newFakeS3()
+		client.objects["input/cities.csv"] = []byte("London\nAtlantis\nParis\n")
+
+		provider := &countingProvider{calls: map[string]int{}, failing: map[string]bool{"Atlantis": true}}
+		p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+			logger: log.New(io.Discard, "", 0)}
+
+		p.config = testConfig(t)
+		response, err := p.Process(context.Background(), "cities.csv", "")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("STRICT %q: got error %v, want error %v", tt.strict, err, tt.wantErr)
+			continue
+		}
+
+		_, written := client.objects["output/highest_temperatures.csv"]
+		if written == tt.wantErr {
+			t.Errorf("STRICT %q: report written %v, want %v", tt.strict, written, !tt.wantErr)
+		}
+
+		if tt.wantErr {
+			if !strings.Contains(err.Error(), "Atlantis") {
+				t.Errorf("STRICT %q: got error %v, want it to name the failed city", tt.strict, err)
+			}
+			continue
+		}
+
+		if _, skipped := response.Errors["Atlantis"]; !skipped || len(response.Errors) != 1 {
+			t.Errorf("STRICT %q: got errors %v, want only Atlantis skipped", tt.strict, response.Errors)
+		}
+	}
+}
+
+func TestLoadConfigStrictExcludesMinSuccess(t *testing.T) {
+	t.Setenv("STRICT", "true")
+	t.Setenv("MIN_SUCCESS", "2")
+
+	if _, err := loadConfig(); err == nil {
+		t.Error("expected STRICT with MIN_SUCCESS to be rejected")
+	}
+}
+
+func TestExtractCitiesBOM(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     []string
+	}{
+		{"cities.csv", "\ufeffLondon\nParis\n", []string{"London", "Paris"}},
+		{"cities.csv", "\ufeff\"Washington, DC\",Oslo\n", []string{"Washington, DC", "Oslo"}},
+		{"cities.json", "\ufeff[\"London\", \"Paris\"]", []string{"London", "Paris"}},
+		{"cities.csv", "\ufeff", []string{}},
+		{"cities.csv", "London\ufeff\n", []string{"London\ufeff"}},
+	}
+
+	for _, tt := range tests {
+		got, err := extractLocal(t, tt.name, tt.contents)
+		if err != nil {
+			t.Errorf("%q: %v", tt.contents, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got cities %q, want %q", tt.contents, got, tt.want)
+		}
+	}
+}
+
+func TestProcessBOMFirstCityResolves(t *testing.T) {
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("\ufeffLondon\nParis\n")
+
+	provider := &countingProvider{calls: map[string]int{}, failing: map[string]bool{"\ufeffLondon": true}}
+	p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+		logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	response, err := p.Process(context.Background(), "cities.csv", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(provider.calls, map[string]int{"London": 1, "Paris": 1}) {
+		t.Errorf("got api calls %q, want London looked up without its byte order mark", provider.calls)
+	}
+
+	if len(response.Errors) != 0 {
+		t.Errorf("got errors %v, want every city resolved", response.Errors)
+	}
+}
+
+func TestExtractCitiesMaxCities(t *testing.T) {
+	tests := []struct {
+		contents string
+		behavior string
+		want     []string
+		wantErr  bool
+	}{
+		{"London\nParis\n", "", []string{"London", "Paris"}, false},
+		{"London\nParis\nOslo\n", "", []string{"London", "Paris", "Oslo"}, false},
+		{"London\nParis\nOslo\nCairo\n", "", []string{"London", "Paris", "Oslo"}, true},
+		{"London\nParis\nOslo\nCairo\n", "error", []string{"London", "Paris", "Oslo"}, true},
+		{"London\nParis\nOslo\n", "truncate", []string{"London", "Paris", "Oslo"}, false},
+		{"London\nParis\nOslo\nCairo\nLima\n", "truncate", []string{"London", "Paris", "Oslo"}, false},
+	}
+
+	t.Setenv("MAX_CITIES", "3")
+
+	for _, tt := range tests {
+		t.Setenv("MAX_CITIES_BEHAVIOR", tt.behavior)
+
+		got, err := extractLocal(t, "cities.csv", tt.contents)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q with %q: got error %v, want error %v", tt.contents, tt.behavior, err, tt.wantErr)
+			continue
+		}
+
+		if err != nil && !errors.Is(err, ErrSizeLimit) {
+			t.Errorf("%q: got error %v, want ErrSizeLimit", tt.contents, err)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q with %q: got cities %q, want %q", tt.contents, tt.behavior, got, tt.want)
+		}
+	}
+}
+
+func TestSelectMaxCities(t *testing.T) {
+	tests := []struct {
+		limit        string
+		behavior     string
+		want         int
+		wantTruncate bool
+		wantErr      bool
+	}{
+		{"", "", 0, false, false},
+		{"", "bogus", 0, false, false},
+		{"1", "", 1, false, false},
+		{"500", "ERROR", 500, false, false},
+		{"500", "truncate", 500, true, false},
+		{"0", "", 0, false, true},
+		{"-3", "", 0, false, true},
+		{"many", "", 0, false, true},
+		{"500", "drop", 0, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("MAX_CITIES", tt.limit)
+		t.Setenv("MAX_CITIES_BEHAVIOR", tt.behavior)
+
+		got, truncate, err := selectMaxCities()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("MAX_CITIES %q %q: got error %v, want error %v", tt.limit, tt.behavior, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want || truncate != tt.wantTruncate {
+			t.Errorf("MAX_CITIES %q %q: got %d truncate %v, want %d truncate %v",
+				tt.limit, tt.behavior, got, truncate, tt.want, tt.wantTruncate)
+		}
+	}
+}
+
+func TestCoordinatesInOutput(t *testing.T) {
+	responses := []string{
+		`{"coord":{"lon":-0.1257,"lat":51.5085},"name":"London","main":{"temp":14.2},"wind":{"speed":5.1},"cod":200}`,
+		`{"coord":{"lon":151.2073,"lat":-33.8679},"name":"Sydney","main":{"temp":21.7},"wind":{"speed":3.6},"cod":200}`,
+	}
+
+	weatherList := make([]Weather, len(responses))
+	for i, response := range responses {
+		if err := json.Unmarshal([]byte(response), &weatherList[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string][2]float64{"London": {51.5085, -0.1257}, "Sydney": {-33.8679, 151.2073}}
+
+	temperatures, winds := extractWeatherInfo(weatherList, false, "m/s", false)
+	for _, row := range temperatures {
+		if got := [2]float64{row.Lat, row.Lon}; got != want[row.City] {
+			t.Errorf("temperature row %s: got coordinates %v, want %v", row.City, got, want[row.City])
+		}
+	}
+	for _, row := range winds {
+		if got := [2]float64{row.Lat, row.Lon}; got != want[row.City] {
+			t.Errorf("wind row %s: got coordinates %v, want %v", row.City, got, want[row.City])
+		}
+	}
+
+	summary := summaryRows(weatherList, "city", metricReports(Config{WindUnit: "m/s"}), "m/s", "")
+	if len(summary) != 2 {
+		t.Fatalf("got %d summary rows, want 2", len(summary))
+	}
+	for _, row := range summary {
+		if got := [2]float64{row.Lat, row.Lon}; got != want[row.City] {
+			t.Errorf("summary row %s: got coordinates %v, want %v", row.City, got, want[row.City])
+		}
+	}
+
+	body, err := outputFormats["csv"].Marshal(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "Lat,Lon") || !strings.Contains(string(body), "Sydney,-33.8679,151.2073") {
+		t.Errorf("got summary csv %q, want Lat and Lon columns", body)
+	}
+}
+
+func TestSelectS3Endpoint(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{" http://localhost:4566 ", "http://localhost:4566", false},
+		{"https://minio.internal:9000", "https://minio.internal:9000", false},
+		{"localhost:4566", "", true},
+		{"ftp://localhost:4566", "", true},
+		{"http://", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("AWS_S3_ENDPOINT", tt.env)
+
+		got, err := selectS3Endpoint()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("AWS_S3_ENDPOINT %q: got error %v, want error %v", tt.env, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("AWS_S3_ENDPOINT %q: got %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestS3EndpointPathStyle(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Host+r.URL.Path)
+	}))
+	defer server.Close()
+
+	cfg := aws.Config{Region: "us-east-1", Credentials: aws.AnonymousCredentials{}, HTTPClient: server.Client()}
+	client := s3.NewFromConfig(cfg, s3ClientOptions(0, server.URL))
+
+	if _, err := client.HeadBucket(context.Background(), &s3.HeadBucketInput{Bucket: aws.String("output")}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.TrimPrefix(server.URL, "http://") + "/output"
+	if len(paths) != 1 || paths[0] != want {
+		t.Errorf("got requests %v, want one to %s", paths, want)
+	}
+}
+
+// TestS3EndpointLocalStack runs against the service named by AWS_S3_ENDPOINT, such as LocalStack or MinIO
+func TestS3EndpointLocalStack(t *testing.T) {
+	if os.Getenv("AWS_S3_ENDPOINT") == "" {
+		t.Skip("AWS_S3_ENDPOINT not set")
+	}
+
+	endpoint, err := selectS3Endpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var options s3.Options
+	s3ClientOptions(-1, endpoint)(&options)
+
+	resolved, err := options.EndpointResolver.ResolveEndpoint("us-east-1", s3.EndpointResolverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.URL != endpoint {
+		t.Errorf("got endpoint %s, want the override %s", resolved.URL, endpoint)
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := s3.NewFromConfig(cfg, s3ClientOptions(0, endpoint))
+	if _, err := client.ListBuckets(context.Background(), &s3.ListBucketsInput{}); err != nil {
+		t.Errorf("failed to list buckets at %s! %s", endpoint, err)
+	}
+}
+
+func TestWindVector(t *testing.T) {
+	tests := []struct {
+		speed float64
+		deg   int
+		wantU float64
+		wantV float64
+	}{
+		{10, 0, 0, -10},
+		{10, 90, -10, 0},
+		{10, 180, 0, 10},
+		{10, 270, 10, 0},
+		{10, 360, 0, -10},
+		{10, -90, 10, 0},
+		{2, 45, -math.Sqrt2, -math.Sqrt2},
+		{4, 210, 2, 2 * math.Sqrt(3)},
+		{0, 123, 0, 0},
+	}
+
+	for _, tt := range tests {
+		u, v := windVector(tt.speed, tt.deg)
+		if math.Abs(u-tt.wantU) > 1e-9 || math.Abs(v-tt.wantV) > 1e-9 {
+			t.Errorf("windVector(%v, %d) = (%v, %v), want (%v, %v)", tt.speed, tt.deg, u, v, tt.wantU, tt.wantV)
+		}
+	}
+}
+
+func TestWindRowsVector(t *testing.T) {
+	var city Weather
+	city.Name = "Oslo"
+	city.Wind.Speed = 5
+	city.Wind.Degrees = 270
+
+	rows := windRows("m/s", false)([]Weather{city}).([]WindOutput)
+	if rows[0].WindU != nil || rows[0].WindV != nil {
+		t.Errorf("got vector %v, %v without WIND_VECTOR", rows[0].WindU, rows[0].WindV)
+	}
+
+	rows = windRows("km/h", true)([]Weather{city}).([]WindOutput)
+	if rows[0].WindU == nil || rows[0].WindV == nil {
+		t.Fatal("got no vector with WIND_VECTOR")
+	}
+
+	// A westerly wind blows east, with the components in the converted unit
+	if math.Abs(*rows[0].WindU-18) > 1e-9 || math.Abs(*rows[0].WindV) > 1e-9 {
+		t.Errorf("got vector (%v, %v), want (18, 0)", *rows[0].WindU, *rows[0].WindV)
+	}
+}
+
+func TestWeatherDecodesCleanFloats(t *testing.T) {
+	body := `{"name":"Oslo","coord":{"lat":59.9133,"lon":10.739},` +
+		`"main":{"temp":18.12,"feels_like":17.61,"temp_min":16.03,"temp_max":19.87},"wind":{"speed":4.63,"deg":250}}`
+
+	var city Weather
+	if err := json.Unmarshal([]byte(body), &city); err != nil {
+		t.Fatal(err)
+	}
+
+	if city.Main.Temp != 18.12 || city.Main.FeelsLike != 17.61 || city.Main.TempMin != 16.03 || city.Main.TempMax != 19.87 {
+		t.Errorf("got main %+v, want the values exactly as sent", city.Main)
+	}
+
+	if city.Wind.Speed != 4.63 || city.Coord.Lat != 59.9133 || city.Coord.Lon != 10.739 {
+		t.Errorf("got wind %+v and coord %+v, want the values exactly as sent", city.Wind, city.Coord)
+	}
+
+	// Even unrounded, the report carries the value as sent
+	rows := temperatureRows(rankWeather([]Weather{city}, temperatureValue, false))
+	got, err := outputFormats["csv"].Marshal(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(got), ",18.12,") || strings.Contains(string(got), "18.1200") {
+		t.Errorf("got csv %q, want a clean 18.12", got)
+	}
+}
+
+func TestNDJSONLines(t *testing.T) {
+	rows := []WindOutput{
+		{Rank: 1, City: "Reykjavik", WindSpeed: 15.2, Direction: "NW"},
+		{Rank: 2, City: "Wellington, NZ", WindSpeed: 12, Direction: "N"},
+		{Rank: 3, City: "Oslo", WindSpeed: 9.75, Direction: "SSW"},
+	}
+
+	body, err := outputFormats["ndjson"].Marshal(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasSuffix(body, []byte("\n")) || bytes.HasPrefix(body, []byte("[")) {
+		t.Errorf("got %q, want newline terminated objects rather than an array", body)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	if len(lines) != len(rows) {
+		t.Fatalf("got %d lines, want one per row: %q", len(lines), body)
+	}
+
+	for i, line := range lines {
+		var decoded WindOutput
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Errorf("line %d %q does not parse on its own! %s", i+1, line, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(decoded, rows[i]) {
+			t.Errorf("line %d: got %+v, want %+v", i+1, decoded, rows[i])
+		}
+	}
+
+	if _, err := outputFormats["ndjson"].Marshal(rows[0]); err == nil {
+		t.Error("expected a single row rather than a slice to be rejected")
+	}
+}
+
+func TestProcessNDJSON(t *testing.T) {
+	t.Setenv("OUTPUT_FORMAT", "ndjson")
+
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("London\nParis\nOslo\n")
+
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+		logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	if _, err := p.Process(context.Background(), "cities.csv", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	body, ok := client.objects["output/highest_temperatures.ndjson"]
+	if !ok {
+		t.Fatal("got no highest_temperatures.ndjson report")
+	}
+
+	if got := bytes.Count(body, []byte("\n")); got != 3 {
+		t.Errorf("got %d lines, want one per city: %q", got, body)
+	}
+
+	if got := client.contentTypes["output/highest_temperatures.ndjson"]; got != "application/x-ndjson" {
+		t.Errorf("got content type %q, want application/x-ndjson", got)
+	}
+}
+
+func TestPopulateWeatherListLogsProgress(t *testing.T) {
+	tests := []struct {
+		cities   int
+		interval int
+		want     []string
+	}{
+		{250, 100, []string{"processed 100 cities", "processed 200 cities", "processed 250 cities"}},
+		{200, 100, []string{"processed 100 cities", "processed 200 cities"}},
+		{99, 100, []string{"processed 99 cities"}},
+		{5, 2, []string{"processed 2 cities", "processed 4 cities", "processed 5 cities"}},
+		{250, 0, []string{}},
+	}
+
+	for _, tt := range tests {
+		var logs bytes.Buffer
+		provider := &countingProvider{calls: map[string]int{}}
+		p := &Processor{weatherProvider: provider, metrics: multiRecorder{}, logger: log.New(&logs, "", 0)}
+
+		cities := make(chan string, tt.cities)
+		for i := 0; i < tt.cities; i++ {
+			cities <- fmt.Sprintf("City %d", i)
+		}
+		close(cities)
+
+		var weatherList []Weather
+		if err := p.populateWeatherList(context.Background(), cities, map[string]int{}, Config{ProgressInterval: tt.interval}, &weatherList); err != nil {
+			t.Fatal(err)
+		}
+
+		got := make([]string, 0)
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if strings.HasPrefix(line, "processed ") {
+				got = append(got, strings.SplitN(line, ",", 2)[0])
+			}
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d cities every %d: got progress %q, want %q", tt.cities, tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestSelectProgressInterval(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{"", defaultProgressInterval, false},
+		{"0", 0, false},
+		{" 25 ", 25, false},
+		{"-5", 0, true},
+		{"often", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("PROGRESS_INTERVAL", tt.env)
+
+		got, err := selectProgressInterval()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("PROGRESS_INTERVAL %q: got error %v, want error %v", tt.env, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("PROGRESS_INTERVAL %q: got %d, want %d", tt.env, got, tt.want)
+		}
+	}
+}
+
+// tarGzipped bundles files into an in-memory tar.gz archive, in the order given
+func tarGzipped(t *testing.T, files [][2]string) string {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	compressed := gzip.NewWriter(&buffer)
+	archive := tar.NewWriter(compressed)
+
+	if err := archive.WriteHeader(&tar.Header{Name: "cities/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		header := &tar.Header{Name: file[0], Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(file[1]))}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write([]byte(file[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressed.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.String()
+}
+
+func TestExtractCitiesTarGzip(t *testing.T) {
+	contents := tarGzipped(t, [][2]string{
+		{"cities/europe.csv", "London\n\"Paris\"\n"},
+		{"cities/asia.json", `["Tokyo", "Seoul"]`},
+	})
+
+	for _, name := range []string{"cities.tar.gz", "cities.tgz"} {
+		got, err := extractLocal(t, name, contents)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if want := []string{"London", "Paris", "Tokyo", "Seoul"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got cities %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestExtractCitiesTarInvalidEntry(t *testing.T) {
+	contents := tarGzipped(t, [][2]string{
+		{"cities/europe.csv", "London\n"},
+		{"cities/asia.json", `["Tokyo", `},
+	})
+
+	_, err := extractLocal(t, "cities.tar.gz", contents)
+	if err == nil || !strings.Contains(err.Error(), "cities/asia.json") {
+		t.Errorf("got error %v, want the invalid entry named", err)
+	}
+
+	if !errors.Is(err, ErrInputRead) {
+		t.Errorf("got error %v, want ErrInputRead", err)
+	}
+}
+
+func TestIsTarArchive(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"cities.tar.gz", true},
+		{"uploads/CITIES.TGZ", true},
+		{"cities.tar", true},
+		{"cities.csv.gz", false},
+		{"cities.csv", false},
+		{"tar.gz/cities.csv", false},
+	}
+
+	for _, tt := range tests {
+		if got := isTarArchive(tt.key); got != tt.want {
+			t.Errorf("isTarArchive(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestFilterByTemperatureInclusive(t *testing.T) {
+	temps := map[string]float64{"Athens": 29.99, "Cairo": 30, "Dubai": 30.01, "Doha": 35, "Kuwait City": 35.01}
+	cities := map[string]string{"Athens": "GR", "Cairo": "EG", "Dubai": "AE", "Doha": "QA", "Kuwait City": "KW"}
+
+	bound := func(value float64) *float64 { return &value }
+
+	tests := []struct {
+		name        string
+		minTemp     *float64
+		maxTemp     *float64
+		wantKept    []string
+		wantDropped []string
+	}{
+		{"both bounds", bound(30), bound(35), []string{"Cairo", "Doha", "Dubai"}, []string{"Athens", "Kuwait City"}},
+		{"lower bound", bound(30), nil, []string{"Cairo", "Doha", "Dubai", "Kuwait City"}, []string{"Athens"}},
+		{"upper bound", nil, bound(35), []string{"Athens", "Cairo", "Doha", "Dubai"}, []string{"Kuwait City"}},
+		{"single value", bound(35), bound(35), []string{"Doha"}, []string{"Athens", "Cairo", "Dubai", "Kuwait City"}},
+		{"unbounded", nil, nil, []string{"Athens", "Cairo", "Doha", "Dubai", "Kuwait City"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		kept, dropped := filterByTemperature(countryWeather(cities, temps), tt.minTemp, tt.maxTemp)
+
+		keptNames := make([]string, 0, len(kept))
+		for _, city := range kept {
+			keptNames = append(keptNames, city.Name)
+		}
+		sort.Strings(keptNames)
+		sort.Strings(dropped)
+
+		if !reflect.DeepEqual(keptNames, tt.wantKept) {
+			t.Errorf("%s: kept %v, want %v", tt.name, keptNames, tt.wantKept)
+		}
+		if len(dropped) != len(tt.wantDropped) || (len(dropped) > 0 && !reflect.DeepEqual(dropped, tt.wantDropped)) {
+			t.Errorf("%s: dropped %v, want %v", tt.name, dropped, tt.wantDropped)
+		}
+	}
+}
+
+func TestSelectTemperatureRange(t *testing.T) {
+	tests := []struct {
+		minTemp string
+		maxTemp string
+		wantMin string
+		wantMax string
+		wantErr bool
+	}{
+		{"", "", "<nil>", "<nil>", false},
+		{"30", "", "30", "<nil>", false},
+		{"", "-2.5", "<nil>", "-2.5", false},
+		{"20", "20", "20", "20", false},
+		{"25", "20", "", "", true},
+		{"warm", "", "", "", true},
+	}
+
+	format := func(bound *float64) string {
+		if bound == nil {
+			return "<nil>"
+		}
+		return fmt.Sprint(*bound)
+	}
+
+	for _, tt := range tests {
+		t.Setenv("MIN_TEMP", tt.minTemp)
+		t.Setenv("MAX_TEMP", tt.maxTemp)
+
+		minTemp, maxTemp, err := selectTemperatureRange()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("MIN_TEMP %q MAX_TEMP %q: got error %v, want error %v", tt.minTemp, tt.maxTemp, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (format(minTemp) != tt.wantMin || format(maxTemp) != tt.wantMax) {
+			t.Errorf("MIN_TEMP %q MAX_TEMP %q: got %s-%s, want %s-%s", tt.minTemp, tt.maxTemp,
+				format(minTemp), format(maxTemp), tt.wantMin, tt.wantMax)
+		}
+	}
+}
+
+func TestProcessReportsOutOfRangeCities(t *testing.T) {
+	t.Setenv("MIN_TEMP", "5")
+	t.Setenv("MAX_TEMP", "5")
+
+	client := newFakeS3()
+	client.objects["input/cities.csv"] = []byte("Oslo\nParis\nLondon\n")
+
+	// countingProvider reports the length of the city name as its temperature
+	provider := &countingProvider{calls: map[string]int{}}
+	p := &Processor{s3Client: client, weatherProvider: provider, inputBucket: "input", outputBucket: "output",
+		logger: log.New(io.Discard, "", 0)}
+
+	p.config = testConfig(t)
+	response, err := p.Process(context.Background(), "cities.csv", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(response.OutOfRange)
+	if !reflect.DeepEqual(response.OutOfRange, []string{"London", "Oslo"}) {
+		t.Errorf("got out of range cities %v, want London and Oslo", response.OutOfRange)
+	}
+
+	report := string(client.objects["output/highest_temperatures.csv"])
+	if !strings.Contains(report, "Paris") || strings.Contains(report, "Oslo") || strings.Contains(report, "London") {
+		t.Errorf("got report %q, want only Paris at exactly 5", report)
+	}
+}
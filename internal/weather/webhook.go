@@ -0,0 +1,108 @@
+package weather
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// webhookTimeout bounds the completion webhook so a slow receiver cannot hold up the invocation
+const webhookTimeout = 5 * time.Second
+
+// selectWebhookURL reads the WEBHOOK_URL env var the Response is posted to once a run ends
+// Output:
+//     If success returns the url, empty when unset, and nil, otherwise an error describing the malformed url
+func selectWebhookURL() (string, error) {
+	endpoint := strings.TrimSpace(os.Getenv("WEBHOOK_URL"))
+	if endpoint == "" {
+		return "", nil
+	}
+
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid WEBHOOK_URL! %w", err)
+	}
+
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid WEBHOOK_URL! %s must be an absolute http(s) url", endpoint)
+	}
+
+	return endpoint, nil
+}
+
+// postWebhook posts the Response of a run as json to WEBHOOK_URL, skipped when unset or during a dry run
+// Inputs:
+//     ctx: context of the invocation
+//     response: final Response of the run
+// Output:
+//     If success returns nil, otherwise an error
+func (p *Processor) postWebhook(ctx context.Context, response Response) error {
+	endpoint := p.config.WebhookURL
+	if endpoint == "" {
+		return nil
+	}
+
+	if p.dryRun() {
+		p.logger.Printf("dry run: would post the response to %s", endpoint)
+		return nil
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook body! %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook request failed! %w", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", userAgent())
+
+	webhookResponse, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("webhook response failed! %w", err)
+	}
+
+	defer webhookResponse.Body.Close()
+
+	// The receiver's reply is not used, it is drained so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(webhookResponse.Body, defaultMaxResponseBytes))
+
+	if webhookResponse.StatusCode < 200 || webhookResponse.StatusCode > 299 {
+		return fmt.Errorf("webhook rejected the response! %s", webhookResponse.Status)
+	}
+
+	return nil
+}
+
+// notifyWebhook posts the Response of a run, a failed post is only logged unless WEBHOOK_STRICT is enabled
+// Inputs:
+//     ctx: context of the invocation
+//     response: final Response of the run
+// Output:
+//     If success or the failure is tolerated returns nil, otherwise an error
+func (p *Processor) notifyWebhook(ctx context.Context, response Response) error {
+	err := p.postWebhook(ctx, response)
+	if err == nil {
+		return nil
+	}
+
+	if p.config.WebhookStrict {
+		return err
+	}
+
+	p.logger.Printf("%s", err)
+	return nil
+}
@@ -0,0 +1,98 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// webhookReceiver sends the responses posted to it on received, replying with status
+func webhookReceiver(t *testing.T, status int, received chan<- Response) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+
+		response := Response{}
+		if err := json.NewDecoder(r.Body).Decode(&response); err != nil {
+			t.Errorf("failed to decode webhook body! %s", err)
+		}
+		received <- response
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestPostWebhook(t *testing.T) {
+	received := make(chan Response, 1)
+	server := webhookReceiver(t, http.StatusOK, received)
+
+	t.Setenv("WEBHOOK_URL", server.URL)
+	t.Setenv("DRY_RUN", "")
+
+	p := &Processor{config: testConfig(t), logger: log.New(io.Discard, "", 0)}
+	response := Response{StatusCode: "200", StatusMessage: "Success", RequestID: "req-1", Errors: map[string]string{"Atlantis": "city not found"}}
+
+	if err := p.postWebhook(context.Background(), response); err != nil {
+		t.Fatal(err)
+	}
+
+	if posted := <-received; posted.RequestID != "req-1" || posted.Errors["Atlantis"] != "city not found" {
+		t.Errorf("got %+v", posted)
+	}
+}
+
+func TestNotifyWebhookStrict(t *testing.T) {
+	received := make(chan Response, 2)
+	server := webhookReceiver(t, http.StatusInternalServerError, received)
+
+	t.Setenv("WEBHOOK_URL", server.URL)
+	t.Setenv("DRY_RUN", "")
+
+	p := &Processor{logger: log.New(io.Discard, "", 0)}
+
+	t.Setenv("WEBHOOK_STRICT", "")
+	p.config = testConfig(t)
+	if err := p.notifyWebhook(context.Background(), Response{StatusCode: "200"}); err != nil {
+		t.Errorf("got %v, want a rejected post to be tolerated", err)
+	}
+
+	t.Setenv("WEBHOOK_STRICT", "true")
+	p.config = testConfig(t)
+	if err := p.notifyWebhook(context.Background(), Response{StatusCode: "200"}); err == nil {
+		t.Error("expected WEBHOOK_STRICT to fail on a rejected post")
+	}
+}
+
+func TestProcessPostsDuration(t *testing.T) {
+	received := make(chan Response, 1)
+	server := webhookReceiver(t, http.StatusOK, received)
+
+	t.Setenv("WEBHOOK_URL", server.URL)
+	t.Setenv("DRY_RUN", "")
+	t.Setenv("WEBHOOK_STRICT", "")
+
+	// The upload file is missing, so the run fails before any city is read
+	p := &Processor{s3Client: newFakeS3(), inputBucket: "input", config: testConfig(t), logger: log.New(io.Discard, "", 0)}
+	p.SetStartTime(time.Now().Add(-1500 * time.Millisecond))
+
+	p.config = testConfig(t)
+	response, err := p.Process(context.Background(), "cities.csv", "abc123")
+	if err == nil {
+		t.Fatal("expected the run to fail")
+	}
+
+	if posted := <-received; posted.DurationMs < 1500 || posted.DurationMs != response.DurationMs {
+		t.Errorf("got posted durationMs %d and returned durationMs %d", posted.DurationMs, response.DurationMs)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"example.com/weather/internal/weather"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestInputKeyMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"", "anything/at/all.txt", true},
+		{"cities/*.csv", "cities/europe.csv", true},
+		{"cities/*.csv", "cities/europe.json", false},
+		{"cities/*.csv", "cities/eu/london.csv", false},
+		{"cities/*.csv", "archive/cities/europe.csv", false},
+		{"*.csv", "europe.csv", true},
+		{"cities/202?-*.csv", "cities/2024-06.csv", true},
+		{"cities/[a-m]*.csv", "cities/paris.csv", false},
+	}
+
+	for _, tt := range tests {
+		got, err := inputKeyMatches(tt.pattern, tt.key)
+		if err != nil || got != tt.want {
+			t.Errorf("inputKeyMatches(%q, %q) = %v, %v, want %v", tt.pattern, tt.key, got, err, tt.want)
+		}
+	}
+
+	if _, err := inputKeyMatches("cities/[.csv", "cities/a.csv"); err == nil {
+		t.Error("got no error for a malformed pattern")
+	}
+}
+
+func TestParseInvocation(t *testing.T) {
+	s3Event := `{"Records": [{
+		"eventSource": "aws:s3",
+		"eventTime": "2024-06-01T12:30:00.000Z",
+		"eventName": "ObjectCreated:Put",
+		"s3": {"bucket": {"name": "weather-input"}, "object": {"key": "cities/europe.csv", "eTag": "0123abcd"}}
+	}]}`
+
+	tests := []struct {
+		name    string
+		payload string
+		want    invocation
+		wantErr bool
+	}{
+		{"s3 event", s3Event, invocation{Key: "cities/europe.csv", ETag: "0123abcd",
+			EventTime: time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)}, false},
+		{"direct with bucket", `{"bucket": "other-input", "key": "cities/asia.csv"}`,
+			invocation{Bucket: "other-input", Key: "cities/asia.csv"}, false},
+		{"direct without bucket", `{"key": "cities/asia.csv"}`, invocation{Key: "cities/asia.csv"}, false},
+		{"direct without key", `{"bucket": "other-input"}`, invocation{}, true},
+		{"empty records", `{"Records": []}`, invocation{}, true},
+		{"not an object", `["cities/asia.csv"]`, invocation{}, true},
+		{"malformed", `{"key": `, invocation{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseInvocation("test", json.RawMessage(tt.payload))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+
+		if got.Bucket != tt.want.Bucket || got.Key != tt.want.Key || got.ETag != tt.want.ETag || !got.EventTime.Equal(tt.want.EventTime) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// s3Record builds an S3 event record for an object
+func s3Record(bucket string, key string, etag string) events.S3EventRecord {
+	record := events.S3EventRecord{}
+	record.S3.Bucket.Name = bucket
+	record.S3.Object.Key = key
+	record.S3.Object.ETag = etag
+
+	return record
+}
+
+func TestUniqueRecords(t *testing.T) {
+	records := []events.S3EventRecord{
+		s3Record("input", "cities/europe.csv", "first"),
+		s3Record("input", "cities/asia.csv", "a"),
+		s3Record("input", "cities/europe.csv", "second"),
+		s3Record("other", "cities/europe.csv", "b"),
+	}
+
+	got := make([]string, 0)
+	for _, record := range uniqueRecords(records) {
+		got = append(got, record.S3.Bucket.Name+"/"+record.S3.Object.Key+"@"+record.S3.Object.ETag)
+	}
+
+	// The first delivery of a key is kept, the same key in another bucket is a different object
+	want := []string{"input/cities/europe.csv@first", "input/cities/asia.csv@a", "other/cities/europe.csv@b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("uniqueRecords = %v, want %v", got, want)
+	}
+
+	if got := uniqueRecords(nil); len(got) != 0 {
+		t.Errorf("uniqueRecords(nil) = %v, want none", got)
+	}
+}
+
+func TestParseInvocationDuplicatedRecord(t *testing.T) {
+	event := events.S3Event{Records: []events.S3EventRecord{
+		s3Record("input", "cities/europe.csv", "0123abcd"),
+		s3Record("input", "cities/europe.csv", "0123abcd"),
+	}}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseInvocation("test", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Key != "cities/europe.csv" || got.ETag != "0123abcd" {
+		t.Errorf("got %+v, want the duplicated record processed once", got)
+	}
+}
+
+func TestHandleEventRoutesHealthCheck(t *testing.T) {
+	newProcessor = func(ctx context.Context) (*weather.Processor, error) { return &weather.Processor{}, nil }
+	defer func() { newProcessor = weather.NewProcessor }()
+
+	checks := 0
+	runHealthCheck = func(p *weather.Processor, ctx context.Context) weather.Response {
+		checks++
+		return weather.Response{StatusCode: "200", StatusMessage: "Healthy"}
+	}
+	defer func() { runHealthCheck = (*weather.Processor).RunHealthCheck }()
+
+	tests := []struct {
+		payload    string
+		wantChecks int
+		wantStatus string
+	}{
+		{`{"healthCheck":true}`, 1, "200"},
+		// Anything else is parsed as a file to process, which this payload does not name
+		{`{"healthCheck":false}`, 0, "400"},
+	}
+
+	for _, tt := range tests {
+		checks = 0
+
+		response, _ := handleEvent(context.Background(), "req-1", time.Now(), json.RawMessage(tt.payload))
+		if checks != tt.wantChecks || response.StatusCode != tt.wantStatus {
+			t.Errorf("%s: got %d health checks and status %s, want %d and %s", tt.payload, checks, response.StatusCode,
+				tt.wantChecks, tt.wantStatus)
+		}
+	}
+}
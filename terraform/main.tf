@@ -22,6 +22,12 @@ locals {
   lambda_name   = "go-weather-lambda"
 }
 
+variable "owm_api_key" {
+  description = "OpenWeatherMap API key passed to the lambda as OWM_API_KEY"
+  type        = string
+  sensitive   = true
+}
+
 //***Buckets***//
 resource "aws_s3_bucket" "input_bucket" {
   bucket = "weather-input-bucket"
@@ -100,7 +106,41 @@ resource "aws_iam_policy" "weather_lambda_policy" {
             "Action": [
                 "s3:ListBucket"
             ],
-            "Resource":"${aws_s3_bucket.input_bucket.arn}"
+            "Resource": [
+              "${aws_s3_bucket.input_bucket.arn}",
+              "${aws_s3_bucket.output_bucket.arn}"
+            ]
+        },
+        {
+            "Effect": "Allow",
+            "Action": [
+                "dynamodb:GetItem",
+                "dynamodb:PutItem",
+                "dynamodb:BatchWriteItem"
+            ],
+            "Resource": "arn:aws:dynamodb:*:*:table/*"
+        },
+        {
+            "Effect": "Allow",
+            "Action": [
+                "sns:Publish"
+            ],
+            "Resource": "arn:aws:sns:*:*:*"
+        },
+        {
+            "Effect": "Allow",
+            "Action": [
+                "sqs:SendMessage"
+            ],
+            "Resource": "arn:aws:sqs:*:*:*"
+        },
+        {
+            "Effect": "Allow",
+            "Action": [
+                "xray:PutTraceSegments",
+                "xray:PutTelemetryRecords"
+            ],
+            "Resource": "*"
         }
     ]
   }
@@ -133,6 +173,7 @@ resource "aws_lambda_function" "weather_lambda" {
     variables = {
       INPUT_BUCKET  = local.input_bucket
       OUTPUT_BUCKET = local.output_bucket
+      OWM_API_KEY   = var.owm_api_key
     }
   }
 